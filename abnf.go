@@ -0,0 +1,393 @@
+package resrap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// RFC 5234 ABNF is the notation of IETF specifications, not to be confused with Resrap's own
+// "Awesome BNF":
+//
+//	URI         = scheme ":" hier-part [ "?" query ] [ "#" fragment ]
+//	scheme      = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+//
+// Rule names are case insensitive and every rule is imported as a lexical rule. The core rules
+// of RFC 5234 appendix B are added when a grammar uses them without defining them.
+
+type abnfTokenKind uint8
+
+const (
+	bEOF    abnfTokenKind = iota
+	bName                 //Rule name
+	bNumber               //Repetition count
+	bString               //"..." char-val, %s"..." or %i"...", text is the content
+	bValue                //%x, %d or %b num-val, expr holds it
+	bProse                //<...> prose-val
+	bPunct                //= =/ / ( ) [ ] *
+)
+
+type abnfToken struct {
+	kind abnfTokenKind
+	text string
+	expr *gexpr
+	line int
+}
+
+// abnfCoreRules are the core rules of RFC 5234 appendix B
+const abnfCoreRules = `
+ALPHA  = %x41-5A / %x61-7A
+BIT    = "0" / "1"
+CHAR   = %x01-7F
+CR     = %x0D
+CRLF   = CR LF
+CTL    = %x00-1F / %x7F
+DIGIT  = %x30-39
+DQUOTE = %x22
+HEXDIG = DIGIT / "A" / "B" / "C" / "D" / "E" / "F"
+HTAB   = %x09
+LF     = %x0A
+LWSP   = *(WSP / CRLF WSP)
+OCTET  = %x00-FF
+SP     = %x20
+VCHAR  = %x21-7E
+WSP    = SP / HTAB
+`
+
+// tokenizeABNF splits an RFC 5234 grammar into tokens, dropping comments
+func tokenizeABNF(src string) ([]abnfToken, error) {
+	var toks []abnfToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == ';':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case isAlpha(rune(c)):
+			j := i
+			for j < len(src) && (isIdentPart(rune(src[j])) || src[j] == '-') {
+				j++
+			}
+			toks = append(toks, abnfToken{kind: bName, text: src[i:j], line: line})
+			i = j
+		case isDigit(rune(c)):
+			j := i
+			for j < len(src) && isDigit(rune(src[j])) {
+				j++
+			}
+			toks = append(toks, abnfToken{kind: bNumber, text: src[i:j], line: line})
+			i = j
+		case c == '"' || strings.HasPrefix(src[i:], `%s"`) || strings.HasPrefix(src[i:], `%i"`):
+			if c == '%' { //Generated as written, case sensitive or not
+				i += 2
+			}
+			end := strings.IndexAny(src[i+1:], "\"\n")
+			if end < 0 || src[i+1+end] != '"' {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			toks = append(toks, abnfToken{kind: bString, text: src[i+1 : i+1+end], line: line})
+			i += end + 2
+		case c == '%':
+			j := min(i+2, len(src))
+			for j < len(src) && (isIdentPart(rune(src[j])) || src[j] == '.' || src[j] == '-') {
+				j++
+			}
+			e, err := abnfValue(src[i:j])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			toks = append(toks, abnfToken{kind: bValue, text: src[i:j], expr: e, line: line})
+			i = j
+		case c == '<':
+			end := strings.IndexByte(src[i:], '>')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated prose", line)
+			}
+			toks = append(toks, abnfToken{kind: bProse, text: src[i+1 : i+end], line: line})
+			line += strings.Count(src[i:i+end], "\n")
+			i += end + 1
+		case strings.HasPrefix(src[i:], "=/"):
+			toks = append(toks, abnfToken{kind: bPunct, text: "=/", line: line})
+			i += 2
+		case strings.ContainsRune("=/()[]*", rune(c)):
+			toks = append(toks, abnfToken{kind: bPunct, text: string(c), line: line})
+			i++
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, r)
+		}
+	}
+	return append(toks, abnfToken{kind: bEOF, line: line}), nil
+}
+
+// abnfValue reads a num-val: %x41, %x41-5A or %x41.42.43, in hex, decimal (%d) or binary (%b)
+func abnfValue(text string) (*gexpr, error) {
+	if len(text) < 3 {
+		return nil, fmt.Errorf("invalid value '%s'", text)
+	}
+	base := map[byte]int{'x': 16, 'X': 16, 'd': 10, 'D': 10, 'b': 2, 'B': 2}[text[1]]
+	if base == 0 {
+		return nil, fmt.Errorf("invalid value '%s', expected %%x, %%d or %%b", text)
+	}
+	num := func(s string) (rune, error) {
+		n, err := strconv.ParseUint(s, base, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value '%s'", text)
+		}
+		return rune(n), nil
+	}
+	body := text[2:]
+	if lo, hi, ok := strings.Cut(body, "-"); ok {
+		from, err := num(lo)
+		if err != nil {
+			return nil, err
+		}
+		to, err := num(hi)
+		if err != nil {
+			return nil, err
+		}
+		return gChars(charSet{}.union(charSet{{from, max(from, to)}})), nil
+	}
+	var b strings.Builder
+	for _, part := range strings.Split(body, ".") {
+		r, err := num(part)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteRune(r)
+	}
+	return gLiteral(b.String()), nil
+}
+
+type abnfParser struct {
+	toks    []abnfToken
+	pos     int
+	g       *ggrammar
+	current string //Rule being read, for warnings
+}
+
+// importABNF converts an RFC 5234 ABNF grammar
+func importABNF(src string, _ importConfig) (*ggrammar, error) {
+	g, err := parseABNF(src)
+	if err != nil {
+		return nil, err
+	}
+	if g.start == "" {
+		return nil, fmt.Errorf("grammar has no rules")
+	}
+	core, err := parseABNF(abnfCoreRules)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rule names are case insensitive, references take the spelling of the definition
+	defined := map[string]string{}
+	for _, r := range g.rules {
+		defined[strings.ToLower(r.name)] = r.name
+	}
+	var rename func(e *gexpr)
+	rename = func(e *gexpr) {
+		if e.kind == gRef {
+			lower := strings.ToLower(e.text)
+			if name, ok := defined[lower]; ok {
+				e.text = name
+			} else if c := ruleNamed(core.rules, lower); c != nil {
+				defined[lower] = c.name
+				g.rules = append(g.rules, c)
+				e.text = c.name
+				rename(c.expr)
+			}
+		}
+		for _, item := range e.items {
+			rename(item)
+		}
+	}
+	for i := 0; i < len(g.rules); i++ { //Core rules added on the way are renamed too
+		rename(g.rules[i].expr)
+	}
+	return g, nil
+}
+
+// ruleNamed returns the rule named lower, ignoring case
+func ruleNamed(rules []*grule, lower string) *grule {
+	for _, r := range rules {
+		if strings.ToLower(r.name) == lower {
+			return r
+		}
+	}
+	return nil
+}
+
+// parseABNF reads the rules of an ABNF grammar, merging incremental alternatives (=/)
+func parseABNF(src string) (*ggrammar, error) {
+	toks, err := tokenizeABNF(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &abnfParser{toks: toks, g: &ggrammar{}}
+	rules := map[string]*grule{}
+	for p.peek().kind != bEOF {
+		name := p.next()
+		if name.kind != bName {
+			p.pos--
+			return nil, p.errorf("expected a rule like 'name = ...'")
+		}
+		incremental := p.accept("=/")
+		if !incremental && !p.accept("=") {
+			return nil, p.errorf("expected '=' or '=/' after %s", name.text)
+		}
+		p.current = name.text
+		expr, err := p.alternatives()
+		if err != nil {
+			return nil, err
+		}
+		lower := strings.ToLower(name.text)
+		if r, ok := rules[lower]; ok {
+			if !incremental {
+				p.g.warn(name.text, "defined more than once, alternatives of the definitions are merged")
+			}
+			r.expr = gAltOf(r.expr, expr)
+			continue
+		}
+		rules[lower] = &grule{name: name.text, expr: expr, lexical: true}
+		p.g.rules = append(p.g.rules, rules[lower])
+		if p.g.start == "" {
+			p.g.start = name.text
+		}
+	}
+	return p.g, nil
+}
+
+func (p *abnfParser) peek() abnfToken { return p.toks[p.pos] }
+
+func (p *abnfParser) next() abnfToken {
+	tok := p.toks[p.pos]
+	if tok.kind != bEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *abnfParser) is(punct string) bool {
+	tok := p.peek()
+	return tok.kind == bPunct && tok.text == punct
+}
+
+func (p *abnfParser) accept(punct string) bool {
+	if p.is(punct) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *abnfParser) errorf(format string, args ...any) error {
+	tok := p.peek()
+	found := "end of grammar"
+	if tok.kind != bEOF {
+		found = fmt.Sprintf("'%s'", tok.text)
+	}
+	return fmt.Errorf("line %d: %s, found %s", tok.line, fmt.Sprintf(format, args...), found)
+}
+
+// ruleStart reports whether the tokens from the current one start the next rule
+func (p *abnfParser) ruleStart() bool {
+	if p.peek().kind == bEOF {
+		return true
+	}
+	after := p.toks[p.pos+1]
+	return p.peek().kind == bName && after.kind == bPunct && (after.text == "=" || after.text == "=/")
+}
+
+func (p *abnfParser) alternatives() (*gexpr, error) {
+	var alts []*gexpr
+	for {
+		var items []*gexpr
+		for !p.ruleStart() && !p.is("/") && !p.is(")") && !p.is("]") {
+			item, err := p.repetition()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		alts = append(alts, gSeqOf(items...))
+		if !p.accept("/") {
+			return gAltOf(alts...), nil
+		}
+	}
+}
+
+// repetition reads an element and the counts before it: n, *, n*, *m or n*m
+func (p *abnfParser) repetition() (*gexpr, error) {
+	least, most := 1, 1
+	counted := p.peek().kind == bNumber
+	if counted {
+		least, _ = strconv.Atoi(p.next().text)
+		most = least
+	}
+	if p.accept("*") {
+		if !counted {
+			least = 0
+		}
+		most = -1
+		if p.peek().kind == bNumber {
+			most, _ = strconv.Atoi(p.next().text)
+		}
+	}
+	e, err := p.element()
+	if err != nil {
+		return nil, err
+	}
+	if least == 1 && most == 1 {
+		return e, nil
+	}
+	if most >= 0 && most < least {
+		return nil, p.errorf("repetition of at least %d and at most %d", least, most)
+	}
+	repeated, exact := gCount(e, least, most)
+	if !exact {
+		p.g.warn(p.current, "repetition counts above %d are approximated", maxCopies)
+	}
+	return repeated, nil
+}
+
+func (p *abnfParser) element() (*gexpr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case bName:
+		return gRule(tok.text), nil
+	case bString:
+		return gLiteral(tok.text), nil
+	case bValue:
+		return tok.expr, nil
+	case bProse:
+		p.g.warn(p.current, "prose <%s> generated as nothing", tok.text)
+		return &gexpr{kind: gEmpty}, nil
+	case bPunct:
+		switch tok.text {
+		case "(", "[":
+			closing := map[string]string{"(": ")", "[": "]"}[tok.text]
+			e, err := p.alternatives()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(closing) {
+				return nil, p.errorf("expected '%s'", closing)
+			}
+			if tok.text == "[" {
+				return gRepeat(gOpt, e), nil
+			}
+			return e, nil
+		}
+	}
+	p.pos--
+	return nil, p.errorf("expected an element")
+}
@@ -0,0 +1,56 @@
+package resrap
+
+import "testing"
+
+func TestImportABNF(t *testing.T) {
+	testImports(t, FormatRFC5234, []importCase{
+		{
+			name: "literals and alternatives",
+			src: `; comment
+greeting = %s"Hi" / %i"yo" / "hey" ; case insensitive
+greeting =/ %x48.49 / %d72 / %b1001000
+`,
+			want: `program: greeting;
+greeting: 'Hi' | 'yo' | 'hey' | 'HI' | 'H' | 'H';
+`,
+			sample: "Hi",
+		},
+		{
+			name: "ranges, options and repetitions",
+			src: `num    = 1*3%x30-32 [ "." 2DIGIT ] *("-" 0*1ALPHA)
+`,
+			want: `program: num;
+num: num_1 num_2? num_3? num_4? num_5*;
+num_1: '0' | '1' | '2';
+num_2: '0' | '1' | '2';
+num_3: '0' | '1' | '2';
+num_4: '.' DIGIT DIGIT;
+num_5: '-' ALPHA?;
+DIGIT: DIGIT_1;
+DIGIT_1: '0' | '1' | '2' | '3' | '4' | '5' | '6' | '7' | '8' | '9';
+ALPHA: ALPHA_1 | ALPHA_2;
+ALPHA_1: 'A' | 'B' | 'C' | 'D' | 'E' | 'F' | 'G' | 'H' | 'I' | 'J' | 'K' | 'L' | 'M' | 'N' | 'O' | 'P' | 'Q' | 'R' | 'S' | 'T' | 'U' | 'V' | 'W' | 'X' | 'Y' | 'Z';
+ALPHA_2: 'a' | 'b' | 'c' | 'd' | 'e' | 'f' | 'g' | 'h' | 'i' | 'j' | 'k' | 'l' | 'm' | 'n' | 'o' | 'p' | 'q' | 'r' | 's' | 't' | 'u' | 'v' | 'w' | 'x' | 'y' | 'z';
+`,
+			sample: "1-",
+		},
+		{
+			name: "approximations",
+			src: `long   = 20*40"a" 
+blurb  = <any text> / name
+name   = 1*ALPHA
+Name   = "N"
+`,
+			want: `program: long;
+long: 'aaaaaaaaaaaaaaa' 'a'+;
+blurb: name?;
+name: ALPHA+ | 'N';
+ALPHA: ALPHA_1 | ALPHA_2;
+ALPHA_1: 'A' | 'B' | 'C' | 'D' | 'E' | 'F' | 'G' | 'H' | 'I' | 'J' | 'K' | 'L' | 'M' | 'N' | 'O' | 'P' | 'Q' | 'R' | 'S' | 'T' | 'U' | 'V' | 'W' | 'X' | 'Y' | 'Z';
+ALPHA_2: 'a' | 'b' | 'c' | 'd' | 'e' | 'f' | 'g' | 'h' | 'i' | 'j' | 'k' | 'l' | 'm' | 'n' | 'o' | 'p' | 'q' | 'r' | 's' | 't' | 'u' | 'v' | 'w' | 'x' | 'y' | 'z';
+`,
+			warnings: []string{"long: repetition counts above 16 are approximated", "blurb: prose <any text> generated as nothing", "Name: defined more than once, alternatives of the definitions are merged"},
+			sample:   "aaaaaaaaaaaaaaaa",
+		},
+	})
+}
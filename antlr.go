@@ -0,0 +1,632 @@
+package resrap
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ANTLR4 grammars are imported by reading their parser and lexer rules into a ggrammar.
+// Lexer rules (capitalized) become lexical rules, parser rules are generated with a space
+// after every token. Actions, predicates, lexer commands and skipped tokens are dropped
+// with a warning, labels, arguments and options are ignored.
+
+type antlrTokenKind uint8
+
+const (
+	aEOF    antlrTokenKind = iota
+	aIdent                 //Rule name or keyword
+	aString                //'literal', text is decoded
+	aSet                   //[...] character set or rule arguments, text is raw
+	aAction                //{...}
+	aPred                  //{...}?
+	aPunct                 //Operators, text holds them
+)
+
+type antlrToken struct {
+	kind antlrTokenKind
+	text string
+	line int
+}
+
+// tokenizeANTLR splits an ANTLR4 grammar into tokens, dropping comments
+func tokenizeANTLR(src string) ([]antlrToken, error) {
+	var toks []antlrToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case isIdentStart(rune(c)):
+			j := i
+			for j < len(src) && isIdentPart(rune(src[j])) {
+				j++
+			}
+			toks = append(toks, antlrToken{aIdent, src[i:j], line})
+			i = j
+		case c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != '\'' && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != '\'' {
+				return nil, fmt.Errorf("line %d: unterminated literal", line)
+			}
+			toks = append(toks, antlrToken{aString, unescapeANTLR(src[i+1 : j]), line})
+			i = j + 1
+		case c == '[':
+			j := i + 1
+			for j < len(src) && src[j] != ']' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated set", line)
+			}
+			toks = append(toks, antlrToken{aSet, src[i+1 : j], line})
+			line += strings.Count(src[i:j], "\n")
+			i = j + 1
+		case c == '{':
+			end, err := skipAction(src, i)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			tok := antlrToken{aAction, src[i+1 : end-1], line}
+			line += strings.Count(src[i:end], "\n")
+			if i = end; i < len(src) && src[i] == '?' {
+				tok.kind = aPred
+				i++
+			}
+			toks = append(toks, tok)
+		default:
+			op := string(c)
+			for _, long := range []string{"..", "->", "+=", "::"} {
+				if strings.HasPrefix(src[i:], long) {
+					op = long
+				}
+			}
+			if len(op) == 1 && !strings.Contains(":;|()?*+~.#=<>,@!^$", op) {
+				return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+			}
+			toks = append(toks, antlrToken{aPunct, op, line})
+			i += len(op)
+		}
+	}
+	return append(toks, antlrToken{aEOF, "", line}), nil
+}
+
+// skipAction returns the index after the '}' closing the action opened at src[start],
+// skipping nested braces and the strings and comments of the target language
+func skipAction(src string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '/':
+			if strings.HasPrefix(src[i:], "//") {
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+			} else if end := strings.Index(src[i:], "*/"); strings.HasPrefix(src[i:], "/*") && end > 0 {
+				i += end + 1
+			}
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i + 1, nil
+			}
+		case '"', '\'':
+			quote := src[i]
+			for i++; i < len(src) && src[i] != quote && src[i] != '\n'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated action")
+}
+
+// unescapeANTLR decodes the escapes of an ANTLR literal or set
+func unescapeANTLR(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			hex := ""
+			if strings.HasPrefix(s[i+1:], "{") {
+				if end := strings.IndexByte(s[i:], '}'); end > 0 {
+					hex, i = s[i+2:i+end], i+end
+				}
+			} else if i+5 <= len(s) {
+				hex, i = s[i+1:i+5], i+4
+			}
+			if n, err := strconv.ParseUint(hex, 16, 32); err == nil {
+				b.WriteRune(rune(n))
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// parseANTLRSet reads the body of a lexer set like a-zA-Z_\-
+func parseANTLRSet(body string) (charSet, bool) {
+	runes := []rune(unescapeSetBody(body))
+	var set charSet
+	exact := true
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == setProperty {
+			set, exact = set.union(charSet{{'a', 'z'}, {'A', 'Z'}}), false
+			continue
+		}
+		lo, hi := runes[i], runes[i]
+		if i+2 < len(runes) && runes[i+1] == setRange {
+			hi = runes[i+2]
+			i += 2
+		}
+		if lo <= hi {
+			set = set.union(charSet{{lo, hi}})
+		}
+	}
+	return set, exact
+}
+
+// Markers of unescapeSetBody, from a private use area so they can't clash with set members
+const (
+	setRange    = '\uE000' //An unescaped '-' between two members
+	setProperty = '\uE001' //A \p{...} unicode property
+)
+
+// unescapeSetBody decodes the escapes of a set, marking ranges and properties
+func unescapeSetBody(body string) string {
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '-' && i > 0 && i+1 < len(body):
+			b.WriteRune(setRange)
+		case body[i] == '\\' && i+1 < len(body) && (body[i+1] == 'p' || body[i+1] == 'P'):
+			if end := strings.IndexByte(body[i:], '}'); end > 0 {
+				i += end
+			} else {
+				i++
+			}
+			b.WriteRune(setProperty)
+		case body[i] == '\\' && i+1 < len(body) && body[i+1] == 'u':
+			end := i + 6
+			if strings.HasPrefix(body[i+2:], "{") {
+				end = i + strings.IndexByte(body[i:], '}') + 1
+			}
+			end = min(max(end, i+2), len(body))
+			b.WriteString(unescapeANTLR(body[i:end]))
+			i = end - 1
+		case body[i] == '\\' && i+1 < len(body):
+			b.WriteString(unescapeANTLR(body[i : i+2]))
+			i++
+		default:
+			r, size := utf8.DecodeRuneInString(body[i:])
+			b.WriteRune(r)
+			i += size - 1
+		}
+	}
+	return b.String()
+}
+
+var tokenVocabOption = regexp.MustCompile(`tokenVocab\s*=\s*(\w+)`)
+
+// antlrParser reads the tokens of an ANTLR4 grammar into a ggrammar
+type antlrParser struct {
+	toks    []antlrToken
+	pos     int
+	g       *ggrammar
+	current string //Rule being read, for warnings
+	lexical bool   //Whether rule is a lexer rule
+}
+
+// importANTLR converts an ANTLR4 grammar. Several grammars, like the parser and lexer
+// of a split grammar, can be imported together by concatenating them.
+func importANTLR(src string, _ importConfig) (*ggrammar, error) {
+	toks, err := tokenizeANTLR(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &antlrParser{toks: toks, g: &ggrammar{sep: " "}}
+	if err := p.grammar(); err != nil {
+		return nil, err
+	}
+	return p.g, nil
+}
+
+func (p *antlrParser) peek() antlrToken { return p.toks[p.pos] }
+
+func (p *antlrParser) next() antlrToken {
+	tok := p.toks[p.pos]
+	if tok.kind != aEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// is reports whether the next token is the punctuation or keyword text
+func (p *antlrParser) is(text string) bool {
+	tok := p.peek()
+	return (tok.kind == aPunct || tok.kind == aIdent) && tok.text == text
+}
+
+func (p *antlrParser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *antlrParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected '%s'", text)
+	}
+	return nil
+}
+
+func (p *antlrParser) errorf(format string, args ...any) error {
+	tok := p.peek()
+	found := "end of grammar"
+	if tok.kind != aEOF {
+		found = fmt.Sprintf("'%s'", tok.text)
+	}
+	return fmt.Errorf("line %d: %s, found %s", tok.line, fmt.Sprintf(format, args...), found)
+}
+
+// keyword accepts a keyword of the grammar level, which can also be a rule name
+func (p *antlrParser) keyword(text string) bool {
+	after := p.toks[min(p.pos+1, len(p.toks)-1)]
+	if after.kind == aSet || after.kind == aPunct && after.text == ":" {
+		return false
+	}
+	return p.accept(text)
+}
+
+// skipUntil skips tokens up to and including the punctuation text
+func (p *antlrParser) skipUntil(text string) {
+	for p.peek().kind != aEOF && !p.accept(text) {
+		p.next()
+	}
+}
+
+func (p *antlrParser) grammar() error {
+	var lexerTokens []*gexpr
+	grammars, vocabs := map[string]bool{}, []string{}
+	skipped := map[string]bool{}
+	for p.peek().kind != aEOF {
+		tok := p.peek()
+		switch {
+		case p.keyword("lexer"), p.keyword("parser"):
+			if !p.is("grammar") {
+				return p.errorf("expected 'grammar'")
+			}
+		case p.keyword("grammar"):
+			grammars[p.next().text] = true
+			p.skipUntil(";")
+		case p.keyword("mode"):
+			p.skipUntil(";")
+		case p.keyword("import"):
+			var names []string
+			for p.peek().kind == aIdent {
+				names = append(names, p.next().text)
+				if p.accept("=") {
+					p.next()
+				}
+				p.accept(",")
+			}
+			p.g.warn("", "imported grammars %s are not loaded, convert them together with this one", strings.Join(names, ", "))
+			p.skipUntil(";")
+		case p.keyword("options"):
+			if body := p.next(); body.kind == aAction {
+				if m := tokenVocabOption.FindStringSubmatch(body.text); m != nil {
+					vocabs = append(vocabs, m[1])
+				}
+			}
+		case p.keyword("tokens"), p.keyword("channels"):
+			p.next()
+		case p.accept("@"):
+			for p.peek().kind != aAction && p.peek().kind != aEOF {
+				p.next()
+			}
+			p.next()
+		case tok.kind == aIdent:
+			r, commands, err := p.rule()
+			if err != nil {
+				return err
+			}
+			switch {
+			case commands["skip"] || commands["channel"] || commands["more"]:
+				skipped[r.name] = true
+				p.g.warn(r.name, "skipped token left out, tokens are separated by a space instead")
+			case r.lexical:
+				p.g.rules = append(p.g.rules, r)
+				if !commands["fragment"] {
+					lexerTokens = append(lexerTokens, gRule(r.name))
+				}
+			default:
+				p.g.rules = append(p.g.rules, r)
+				if p.g.start == "" {
+					p.g.start = r.name
+				}
+			}
+		default:
+			return p.errorf("expected a rule")
+		}
+	}
+
+	for _, vocab := range vocabs {
+		if !grammars[vocab] {
+			p.g.warn("", "tokens come from the lexer grammar %s, convert it together with this one", vocab)
+		}
+	}
+	for _, r := range p.g.rules {
+		r.expr.refs(func(name string) {
+			if skipped[name] {
+				p.g.warn(r.name, "uses the skipped token %s, which is left out", name)
+			}
+		})
+		r.expr = leaveOut(r.expr, skipped)
+	}
+	if p.g.start == "" && len(lexerTokens) > 0 {
+		// A lexer grammar generates a stream of its tokens
+		p.g.rules = append(p.g.rules, &grule{name: "tokens", expr: gRepeat(gPlus, gAltOf(lexerTokens...))})
+		p.g.start = "tokens"
+	}
+	if p.g.start == "" {
+		return fmt.Errorf("grammar has no rules")
+	}
+	return nil
+}
+
+// rule reads a rule, returning its lexer commands and whether it is a fragment as commands["fragment"]
+func (p *antlrParser) rule() (*grule, map[string]bool, error) {
+	commands := map[string]bool{}
+	if p.accept("fragment") {
+		commands["fragment"] = true
+	}
+	name := p.next()
+	if name.kind != aIdent {
+		p.pos--
+		return nil, nil, p.errorf("expected a rule name")
+	}
+	p.current, p.lexical = name.text, unicode.IsUpper(rune(name.text[0]))
+
+	// Arguments, return values, locals, exceptions, options and actions don't shape the output
+	for !p.is(":") {
+		switch tok := p.next(); {
+		case tok.kind == aEOF:
+			return nil, nil, p.errorf("expected ':' after rule %s", name.text)
+		case tok.kind == aAction && !p.is(":") && p.toks[p.pos-2].text != "options":
+			p.g.warn(p.current, "action dropped")
+		}
+	}
+	p.next()
+	expr, err := p.alternatives(commands)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, nil, err
+	}
+	for p.accept("catch") || p.accept("finally") {
+		if p.peek().kind == aSet {
+			p.next()
+		}
+		p.next()
+	}
+	return &grule{name: name.text, expr: expr, lexical: p.lexical}, commands, nil
+}
+
+// alternatives reads alternatives up to ';' or ')', recording the lexer commands it meets
+func (p *antlrParser) alternatives(commands map[string]bool) (*gexpr, error) {
+	var alts []*gexpr
+	for {
+		alt, err := p.sequence(commands)
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, alt)
+		if !p.accept("|") {
+			return gAltOf(alts...), nil
+		}
+	}
+}
+
+func (p *antlrParser) sequence(commands map[string]bool) (*gexpr, error) {
+	var items []*gexpr
+	p.options()
+	for {
+		switch tok := p.peek(); {
+		case p.is("|") || p.is(")") || p.is(";") || tok.kind == aEOF:
+			return gSeqOf(items...), nil
+		case p.accept("#"):
+			p.next() //Alternative label
+		case p.accept("->"):
+			p.lexerCommands(commands)
+		case tok.kind == aAction:
+			p.next()
+			p.g.warn(p.current, "action dropped")
+		case tok.kind == aPred:
+			p.next()
+			p.g.warn(p.current, "semantic predicate dropped, alternatives are generated regardless of it")
+		default:
+			item, err := p.element()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+}
+
+// lexerCommands reads the commands after '->'
+func (p *antlrParser) lexerCommands(commands map[string]bool) {
+	for {
+		cmd := p.next()
+		if p.accept("(") {
+			p.skipUntil(")")
+		}
+		switch cmd.text {
+		case "skip", "channel", "more":
+			commands[cmd.text] = true
+		default:
+			p.g.warn(p.current, "lexer command %s ignored", cmd.text)
+		}
+		if !p.accept(",") {
+			return
+		}
+	}
+}
+
+// options skips element options like <assoc=right>
+func (p *antlrParser) options() {
+	if p.accept("<") {
+		p.skipUntil(">")
+	}
+}
+
+func (p *antlrParser) element() (*gexpr, error) {
+	// Labels name what an element matched, they don't change it
+	if p.peek().kind == aIdent && (p.toks[p.pos+1].text == "=" || p.toks[p.pos+1].text == "+=") &&
+		p.toks[p.pos+1].kind == aPunct {
+		p.pos += 2
+	}
+	var e *gexpr
+	var err error
+	if p.accept("~") {
+		e, err = p.negation()
+	} else {
+		e, err = p.atom()
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.options()
+	for _, suffix := range []struct {
+		op   string
+		kind gexprKind
+	}{{"?", gOpt}, {"*", gStar}, {"+", gPlus}} {
+		if p.accept(suffix.op) {
+			e = gRepeat(suffix.kind, e)
+			p.accept("?") //Non greedy
+			break
+		}
+	}
+	return e, nil
+}
+
+func (p *antlrParser) atom() (*gexpr, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == aString:
+		if p.accept("..") {
+			hi := p.next()
+			if hi.kind != aString {
+				p.pos--
+				return nil, p.errorf("expected a literal after '..'")
+			}
+			lo, _ := utf8.DecodeRuneInString(tok.text)
+			end, _ := utf8.DecodeRuneInString(hi.text)
+			return gChars(charSet{}.union(charSet{{lo, max(lo, end)}})), nil
+		}
+		return gLiteral(tok.text), nil
+	case tok.kind == aSet && p.lexical:
+		set, exact := parseANTLRSet(tok.text)
+		if !exact {
+			p.g.warn(p.current, "unicode properties in [%s] generated as ASCII letters", tok.text)
+		}
+		return gChars(set), nil
+	case tok.kind == aIdent:
+		if p.peek().kind == aSet {
+			p.next() //Rule arguments
+		}
+		if tok.text == "EOF" {
+			return &gexpr{kind: gEmpty}, nil
+		}
+		return gRule(tok.text), nil
+	case tok.text == "." && tok.kind == aPunct:
+		if !p.lexical {
+			p.g.warn(p.current, "alternatives using the wildcard '.' of tokens dropped")
+			return &gexpr{kind: gNone}, nil
+		}
+		return gChars(anyChar), nil
+	case tok.text == "(" && tok.kind == aPunct:
+		if p.peek().kind == aIdent && p.toks[p.pos+1].text == ":" {
+			p.pos += 2 //Block options
+		}
+		e, err := p.alternatives(map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	p.pos--
+	return nil, p.errorf("expected an element")
+}
+
+// negation reads the element after '~', which matches the characters (or tokens) it doesn't
+func (p *antlrParser) negation() (*gexpr, error) {
+	e, err := p.atom()
+	if err != nil {
+		return nil, err
+	}
+	if p.lexical {
+		return gMinus(gChars(anyChar), e), nil
+	}
+	p.g.warn(p.current, "alternatives using the negation '~' of tokens dropped")
+	return &gexpr{kind: gNone}, nil
+}
+
+// leaveOut replaces the references of e to skipped tokens by nothing
+func leaveOut(e *gexpr, skipped map[string]bool) *gexpr {
+	if e.kind == gRef && skipped[e.text] {
+		return &gexpr{kind: gEmpty}
+	}
+	for i, item := range e.items {
+		e.items[i] = leaveOut(item, skipped)
+	}
+	return e
+}
@@ -0,0 +1,121 @@
+package resrap
+
+import "testing"
+
+func TestImportANTLR(t *testing.T) {
+	testImports(t, FormatANTLR4, []importCase{
+		{
+			name: "parser and lexer rules",
+			src: `grammar Expr;
+prog: stat+ EOF ;
+stat: expr ';' | ID '=' expr ';' ;
+expr: expr op=('*'|'/') expr   # Mul
+    | INT
+    | '(' expr ')'
+    ;
+ID  : [a-c] [a-c_]*? ;
+INT : '0' | [1-3] DIGIT* ;
+fragment DIGIT : '0'..'3' ;
+WS  : [ \t\r\n]+ -> skip ;
+`,
+			want: `program: prog;
+prog: stat+;
+stat: expr '; ' | ID ' ' '= ' expr '; ';
+expr: expr expr_1 expr | INT ' ' | '( ' expr ') ';
+expr_1: '* ' | '/ ';
+ID: ID_1 ID_2*;
+ID_1: 'a' | 'b' | 'c';
+ID_2: '_' | 'a' | 'b' | 'c';
+INT: '0' | INT_1 DIGIT*;
+INT_1: '1' | '2' | '3';
+DIGIT: DIGIT_1;
+DIGIT_1: '0' | '1' | '2' | '3';
+`,
+			warnings: []string{"WS: skipped token left out, tokens are separated by a space instead"},
+			sample:   "b = 11 ; a_ = 2 * 0 / ( 1 ) * 0 ; ",
+		},
+		{
+			name: "quotes and backslashes",
+			src: `grammar Q;
+s : 'it\'s' | '\\' | '"' Q ;
+Q : '\'' [a-c]* '\'' | '\n' ;
+`,
+			want: `program: s;
+s: 'it\'s ' | '\\ ' | '" ' Q ' ';
+Q: '\'' Q_1* '\'' | '\n';
+Q_1: 'a' | 'b' | 'c';
+`,
+			sample: "it's ",
+		},
+		{
+			name: "actions, predicates and wildcards",
+			src: `grammar Lossy;
+s : {doSomething();} a {p}? b | . c | ~X d ;
+a : 'a' ;
+b : 'b' ;
+c : 'c' ;
+d : 'd' ;
+X : 'x' -> pushMode(M) ;
+NAME : [\p{L}]+ ;
+`,
+			want: `program: s;
+s: a b;
+a: 'a ';
+b: 'b ';
+c: 'c ';
+d: 'd ';
+X: 'x';
+NAME: NAME_1+;
+NAME_1: 'A' | 'B' | 'C' | 'D' | 'E' | 'F' | 'G' | 'H' | 'I' | 'J' | 'K' | 'L' | 'M' | 'N' | 'O' | 'P' | 'Q' | 'R' | 'S' | 'T' | 'U' | 'V' | 'W' | 'X' | 'Y' | 'Z' | 'a' | 'b' | 'c' | 'd' | 'e' | 'f' | 'g' | 'h' | 'i' | 'j' | 'k' | 'l' | 'm' | 'n' | 'o' | 'p' | 'q' | 'r' | 's' | 't' | 'u' | 'v' | 'w' | 'x' | 'y' | 'z';
+`,
+			warnings: []string{"s: action dropped", "s: semantic predicate dropped, alternatives are generated regardless of it", "s: alternatives using the wildcard '.' of tokens dropped", "s: alternatives using the negation '~' of tokens dropped", "X: lexer command pushMode ignored", "NAME: unicode properties in [\\p{L}] generated as ASCII letters"},
+			sample:   "a b ",
+		},
+		{
+			name: "lexer grammar",
+			src: `lexer grammar L;
+import Common;
+NUM : [0-2]+ ;
+PLUS : '+' ;
+fragment F : 'f' ;
+COMMENT : '//' ~[\n]* -> channel(HIDDEN) ;
+`,
+			want: `program: tokens;
+NUM: NUM_1+;
+NUM_1: '0' | '1' | '2';
+PLUS: '+';
+F: 'f';
+tokens: tokens_1+;
+tokens_1: NUM ' ' | PLUS ' ';
+`,
+			warnings: []string{"imported grammars Common are not loaded, convert them together with this one", "COMMENT: skipped token left out, tokens are separated by a space instead"},
+			sample:   "+ ",
+		},
+		{
+			name: "parser grammar",
+			src: `parser grammar P;
+options { tokenVocab=PLexer; }
+program : NUM (PLUS NUM)* ;
+`,
+			want: `program: NUM ' ' program_1*;
+program_1: PLUS ' ' NUM ' ';
+NUM: 'num';
+PLUS: 'plus';
+`,
+			warnings: []string{"tokens come from the lexer grammar PLexer, convert it together with this one", "NUM: not defined, generated as 'num'", "PLUS: not defined, generated as 'plus'"},
+			sample:   "num ",
+		},
+		{
+			name: "skipped token used",
+			src: `grammar S;
+s : 'a' WS 'b' ;
+WS : ' '+ -> skip ;
+`,
+			want: `program: s;
+s: 'a ' 'b ';
+`,
+			warnings: []string{"WS: skipped token left out, tokens are separated by a space instead", "s: uses the skipped token WS, which is left out"},
+			sample:   "a b ",
+		},
+	})
+}
@@ -1,11 +1,17 @@
 package resrap
 
-import "os"
+import (
+	"io/fs"
+	"log/slog"
+)
 
 // Resrap is the main accesspoint for singlethreaded uses
 // Pretty Much Collection of graphs which can be generated using parsing grammar
 type Resrap struct {
 	languageGraph map[string]lang
+	metrics       Metrics
+	logger        *slog.Logger
+	source        SourceFactory
 }
 
 // NewResrap creates and returns a new Resrap instance.
@@ -13,17 +19,38 @@ type Resrap struct {
 func NewResrap() *Resrap {
 	return &Resrap{
 		languageGraph: make(map[string]lang),
+		metrics:       NoopMetrics{},
 	}
 }
 
+// SetMetrics sets where runtime measurements are reported, nil restores the no-op default.
+func (r *Resrap) SetMetrics(m Metrics) {
+	r.metrics = orNoop(m)
+}
+
+// SetLogger enables tracing of every generation on logger at debug level, nil disables it.
+func (r *Resrap) SetLogger(logger *slog.Logger) {
+	r.logger = logger
+}
+
+// SetSourceFactory replaces the random Source behind every generation, nil restores XorShift64.
+// e.g. func(seed uint64) resrap.Source { return rand.NewPCG(seed, 0) } with math/rand/v2
+func (r *Resrap) SetSourceFactory(factory SourceFactory) {
+	r.source = factory
+}
+
 // ParseGrammar parses a grammar string and stores it under the given name.
 // name: a unique identifier for this grammar (e.g., "C"), should be in ABNF format(Check osdc/resrap for more info on that).
 // Returns error generated while parsing
 func (r *Resrap) ParseGrammar(name, grammar string) error {
 	lang := newLang()
 	err := lang.ParserString(grammar)
+	lang.err = err
 	r.languageGraph[name] = lang
 	r.languageGraph[name].graph.Normalize()
+	if err != nil {
+		r.metrics.GenerationError(name)
+	}
 	return err
 }
 
@@ -34,9 +61,14 @@ func (r *Resrap) ParseGrammar(name, grammar string) error {
 func (r *Resrap) ParseGrammarFile(name, location string) error {
 	lang := newLang()
 	err := lang.ParserFile(location)
+	lang.err = err
 	r.languageGraph[name] = lang
-	r.languageGraph[name].graph.Normalize()
-
+	if lang.graph != nil { //Not set when the file can't be read
+		r.languageGraph[name].graph.Normalize()
+	}
+	if err != nil {
+		r.metrics.GenerationError(name)
+	}
 	return err
 }
 
@@ -45,17 +77,36 @@ func (r *Resrap) ParseGrammarFile(name, location string) error {
 // Returns a string containing the generated content.
 // The generation is non-deterministic (random).
 func (r *Resrap) GenerateRandom(name, starting_node string, tokens int) string {
-	prng := newPRNG(0)
-	return r.languageGraph[name].graph.GraphWalk(&prng, starting_node, tokens)
+	code, _ := r.GenerateRandomWithSeed(name, starting_node, tokens)
+	return code
+}
+
+// GenerateRandomWithSeed generates content from the grammar identified by 'name' like GenerateRandom,
+// and also returns the seed that was picked for it.
+// Passing that seed to GenerateWithSeeded replays the exact same content.
+func (r *Resrap) GenerateRandomWithSeed(name, starting_node string, tokens int) (string, uint64) {
+	prng := randomPRNG(r.source)
+	code, _, _ := observedWalk(r.languageGraph, r.metrics, r.logger, codeGenReq{name: name, startnode: starting_node, tokens: tokens, seed: prng.seed}, &prng)
+	return code, prng.seed
 }
 
 // GenerateWithSeeded generates content from the grammar identified by 'name'.
 // starting_node: the starting symbol in the grammar for generation.
-// seed: a numeric seed to make generation deterministic.
+// seed: a numeric seed to make generation deterministic, 0 included.
 // Returns a string containing the generated content.
 func (r *Resrap) GenerateWithSeeded(name, starting_node string, seed uint64, tokens int) string {
-	prng := newPRNG(seed)
-	return r.languageGraph[name].graph.GraphWalk(&prng, starting_node, tokens)
+	prng := newPRNG(r.source, seed)
+	code, _, _ := observedWalk(r.languageGraph, r.metrics, r.logger, codeGenReq{name: name, startnode: starting_node, tokens: tokens, seed: seed}, &prng)
+	return code
+}
+
+// GenerateWithSource generates content from the grammar identified by 'name', drawing randomness from src.
+// starting_node: the starting symbol in the grammar for generation.
+// Returns a string containing the generated content.
+func (r *Resrap) GenerateWithSource(name, starting_node string, src Source, tokens int) string {
+	prng := prng{src: src}
+	code, _, _ := observedWalk(r.languageGraph, r.metrics, r.logger, codeGenReq{name: name, startnode: starting_node, tokens: tokens}, &prng)
+	return code
 }
 
 // GenerateCodebase takes a config like one below  and generates a complete codebase
@@ -67,15 +118,29 @@ func (r *Resrap) GenerateWithSeeded(name, starting_node string, seed uint64, tok
 //	    c[5x1000 *.c]
 //	more/
 //	  sql[10x100 *.sql]
-func (r *Resrap) GenerateCodebase(config_loc, target string) error {
-	config, err := os.ReadFile(config_loc)
+//
+// The config can also be a JSON, YAML or TOML specification, picked by its extension (see ParseSpec).
+// Returns a summary of what was written, and the first file system or grammar error encountered.
+func (r *Resrap) GenerateCodebase(config_loc, target string, opts ...CodebaseOption) (CodebaseSummary, error) {
+	parent, err := ParseSpecFile(config_loc)
 	if err != nil {
-		return err
+		return CodebaseSummary{}, err
 	}
-	parent, err := ParseDSL(string(config))
+	return parent.GenerateStructure(r, target, opts...)
+}
+
+// ValidateDSL checks a tree returned by ParseDSL against the grammars loaded in r,
+// reporting every unknown or broken grammar, start rule and file name rule with its DSL line.
+func (r *Resrap) ValidateDSL(tree *Node) error {
+	return tree.validate(r.languageGraph)
+}
+
+// GenerateCodebaseFS generates the codebase described in config_loc in memory and returns it as an fs.FS
+func (r *Resrap) GenerateCodebaseFS(config_loc string, opts ...CodebaseOption) (fs.FS, CodebaseSummary, error) {
+	sink := NewMemorySink()
+	summary, err := r.GenerateCodebase(config_loc, "", append(opts, WithSink(sink))...)
 	if err != nil {
-		return err
+		return nil, summary, err
 	}
-	parent.generate_node(r, target)
-	return nil
+	return sink.FS(), summary, nil
 }
@@ -1,26 +1,57 @@
 package resrap
 
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 type codeGenReq struct {
 	name      string
 	startnode string
 	seed      uint64
+	random    bool //Pick a random seed, ignoring seed
 	tokens    int
 	id        string
+	queued    time.Time
+	reply     chan<- CodeGenRes //Where to send the result, CodeChannel when nil
+	hooks     *walkHooks        //Codebase symbols the walk shares, nil outside codebase generation
+	ctx       context.Context   //Stops the walk when done, nil for walks that run to their end
 }
 
 // CodeGenRes contains the process id along with the code generated returned from ResrapMT
+// Seed is the seed the code was generated with, random jobs included,
+// so any result can be replayed with GenerateWithSeeded.
 type CodeGenRes struct {
-	Code string
-	Id   string
+	Code   string
+	Id     string
+	Seed   uint64
+	Tokens int   //Number of tokens in Code
+	err    error //Error of the walk, returned by generate
 }
 
 // ResrapMT is the multithreaded version of ResrapMT
 type ResrapMT struct {
-	languageGraph map[string]lang
+	languageGraph map[string]lang //Replaced, never modified, when a grammar is parsed so jobs can keep reading it
+	graphmu       sync.RWMutex
 	poolsize      int //Number of threads in the pool
 	waitqueuesize int
 	pendingjobs   chan codeGenReq
 	codeChannel   chan CodeGenRes
+	metrics       Metrics
+	logger        *slog.Logger
+	source        SourceFactory
+
+	poolmu   sync.Mutex
+	submitmu sync.RWMutex  //Held by trySubmit from its shutdown check to its job being queued
+	workers  int           //Number of workers the pool is sized to
+	stop     chan struct{} //Each receive retires one worker
+	done     chan struct{} //Closed on shutdown
+	latency  atomic.Int64  //Moving average of queue wait plus generation time, in ns
+	jobsrun  atomic.Int64  //Number of jobs completed, lets the autoscaler tell an idle pool from a stale average
 }
 
 // NewResrapMT creates and returns a new Resrap MultiThreaded instance.
@@ -32,9 +63,30 @@ func NewResrapMT(poolsize, waitqueuesize int) *ResrapMT {
 		poolsize:      poolsize,
 		pendingjobs:   make(chan codeGenReq, waitqueuesize),
 		codeChannel:   make(chan CodeGenRes),
+		metrics:       NoopMetrics{},
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
 	}
 }
 
+// SetMetrics sets where runtime measurements are reported, nil restores the no-op default.
+// Should be called before StartResrap.
+func (r *ResrapMT) SetMetrics(m Metrics) {
+	r.metrics = orNoop(m)
+}
+
+// SetLogger enables tracing of every job on logger at debug level, nil disables it.
+// Should be called before StartResrap.
+func (r *ResrapMT) SetLogger(logger *slog.Logger) {
+	r.logger = logger
+}
+
+// SetSourceFactory replaces the random Source behind every job, nil restores XorShift64.
+// Should be called before StartResrap.
+func (r *ResrapMT) SetSourceFactory(factory SourceFactory) {
+	r.source = factory
+}
+
 // GetCodeChannel is the main endpoint for the user to access the processed tokens
 func (r *ResrapMT) GetCodeChannel() chan CodeGenRes {
 	return r.codeChannel
@@ -46,8 +98,12 @@ func (r *ResrapMT) GetCodeChannel() chan CodeGenRes {
 func (r *ResrapMT) ParseGrammar(name, grammar string) error {
 	lang := newLang()
 	err := lang.ParserString(grammar)
-	r.languageGraph[name] = lang
-	r.languageGraph[name].graph.Normalize()
+	lang.err = err
+	lang.graph.Normalize()
+	r.store(name, lang)
+	if err != nil {
+		r.metrics.GenerationError(name)
+	}
 	return err
 }
 
@@ -57,11 +113,35 @@ func (r *ResrapMT) ParseGrammar(name, grammar string) error {
 func (r *ResrapMT) ParseGrammarFile(name, location string) error {
 	lang := newLang()
 	err := lang.ParserFile(location)
-	r.languageGraph[name] = lang
-	r.languageGraph[name].graph.Normalize()
+	lang.err = err
+	if lang.graph != nil { //Not set when the file can't be read
+		lang.graph.Normalize()
+	}
+	r.store(name, lang)
+	if err != nil {
+		r.metrics.GenerationError(name)
+	}
 	return err
 }
 
+// store adds or replaces grammar 'name', safe while jobs are running:
+// the map is copied so jobs already started keep the grammars they began with
+func (r *ResrapMT) store(name string, l lang) {
+	r.graphmu.Lock()
+	defer r.graphmu.Unlock()
+	graphs := make(map[string]lang, len(r.languageGraph)+1)
+	maps.Copy(graphs, r.languageGraph)
+	graphs[name] = l
+	r.languageGraph = graphs
+}
+
+// graphs returns the loaded grammars, the map must not be modified
+func (r *ResrapMT) graphs() map[string]lang {
+	r.graphmu.RLock()
+	defer r.graphmu.RUnlock()
+	return r.languageGraph
+}
+
 // GenerateRandom schedules a job to generate content from the grammar identified by 'name'.
 // starting_node: the starting symbol in the grammar for generation.
 // id: a user-defined process ID that will be associated with the generated content.
@@ -70,39 +150,120 @@ func (r *ResrapMT) ParseGrammarFile(name, location string) error {
 // asynchronously to the CodeChannel. Users must provide a unique process ID and
 // retrieve the result via the get channel function.
 func (r *ResrapMT) GenerateRandom(id, name, starting_node string, tokens int) {
-	req := codeGenReq{name: name, startnode: starting_node, tokens: tokens, seed: 0, id: id}
-	r.pendingjobs <- req
+	req := codeGenReq{name: name, startnode: starting_node, tokens: tokens, random: true, id: id}
+	r.submit(req)
 }
 
 // GenerateWithSeeded schedules a job to generate content from the grammar identified by 'name'.
 // starting_node: the starting symbol in the grammar for generation.
-// seed: a numeric seed to make generation deterministic.
+// seed: a numeric seed to make generation deterministic, 0 included.
 // id: a user-defined process ID that will be associated with the generated content.
 // tokens: number of tokens to generate.
 // The generated content will be sent asynchronously to the CodeChannel. Users must
 // provide a unique process ID and retrieve the result via the get channel function.
 func (r *ResrapMT) GenerateWithSeeded(id, name, starting_node string, seed uint64, tokens int) {
 	req := codeGenReq{name: name, startnode: starting_node, tokens: tokens, seed: seed, id: id}
+	r.submit(req)
+}
+
+func (r *ResrapMT) submit(req codeGenReq) {
+	req.queued = time.Now()
 	r.pendingjobs <- req
+	r.metrics.QueueDepth(len(r.pendingjobs))
+}
+
+// trySubmit queues req unless ctx is done or the pool is shut down first, reporting whether it was queued
+func (r *ResrapMT) trySubmit(ctx context.Context, req codeGenReq) bool {
+	r.submitmu.RLock()
+	defer r.submitmu.RUnlock()
+	if r.isShutDown() {
+		return false //The queue is closed
+	}
+	req.queued = time.Now()
+	select {
+	case r.pendingjobs <- req:
+		r.metrics.QueueDepth(len(r.pendingjobs))
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// generate runs job on the worker pool and waits for its result, giving up when ctx is done first,
+// which also stops the walk. The job is generated inline when the pool isn't running.
+func (r *ResrapMT) generate(ctx context.Context, job codeGenReq) (CodeGenRes, error) {
+	job.ctx = ctx
+	if r.PoolSize() > 0 {
+		reply := make(chan CodeGenRes, 1) //Buffered so the worker never waits on an abandoned job
+		job.reply = reply
+		if r.trySubmit(ctx, job) {
+			select {
+			case res := <-reply:
+				return res, res.err
+			case <-ctx.Done():
+				return CodeGenRes{}, ctx.Err()
+			}
+		}
+		job.reply = nil //Shut down, or ctx is done
+	}
+	if err := ctx.Err(); err != nil {
+		return CodeGenRes{}, err
+	}
+	prng := newPRNG(r.source, job.seed)
+	if job.random {
+		prng = randomPRNG(r.source)
+	}
+	job.seed = prng.seed
+	code, stats, err := observedWalk(r.graphs(), r.metrics, r.logger, job, &prng)
+	return CodeGenRes{Code: code, Id: job.id, Seed: prng.seed, Tokens: stats.tokens}, err
+}
+
+func (r *ResrapMT) isShutDown() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (r *ResrapMT) mtparser() {
-	for job := range r.pendingjobs {
-		prng := newPRNG(job.seed)
-		code := r.languageGraph[job.name].graph.GraphWalk(&prng, job.startnode, job.tokens)
-		r.codeChannel <- CodeGenRes{Code: code, Id: job.id}
+	for {
+		select {
+		case <-r.stop:
+			return //Retired by Resize, jobs stay in the queue for the others
+		case job, ok := <-r.pendingjobs:
+			if !ok {
+				return
+			}
+			r.metrics.QueueDepth(len(r.pendingjobs))
+			prng := newPRNG(r.source, job.seed)
+			if job.random {
+				prng = randomPRNG(r.source)
+			}
+			job.seed = prng.seed
+			code, stats, err := observedWalk(r.graphs(), r.metrics, r.logger, job, &prng)
+			r.observeLatency(time.Since(job.queued))
+			res := CodeGenRes{Code: code, Id: job.id, Seed: prng.seed, Tokens: stats.tokens, err: err}
+			if job.reply != nil {
+				job.reply <- res
+			} else {
+				r.codeChannel <- res
+			}
+		}
 	}
 }
 
 // ShutDownResrap gracefully ends the server goroutines running
 func (r *ResrapMT) ShutDownResrap() {
+	r.submitmu.Lock() //Waits for trySubmit to be done queueing
+	defer r.submitmu.Unlock()
+	close(r.done)
 	close(r.pendingjobs)
 
 }
 
 // StartResrap boots up goroutines as your specified threadpool
 func (r *ResrapMT) StartResrap() {
-	for i := 0; i < r.poolsize; i++ {
-		go r.mtparser()
-	}
+	r.Resize(r.poolsize)
 }
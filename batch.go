@@ -0,0 +1,135 @@
+package resrap
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strconv"
+)
+
+// GenerateBatch generates count snippets from the grammar identified by 'name'.
+// starting_node: the starting symbol in the grammar for generation.
+// tokens: number of tokens to generate per snippet.
+// masterSeed: seed of the whole batch, item i is generated with BatchSeed(masterSeed, i).
+// Returns the snippets in order, or the context error if ctx is done before the batch completes.
+func (r *Resrap) GenerateBatch(ctx context.Context, name, starting_node string, count, tokens int, masterSeed uint64) ([]string, error) {
+	if err := checkStart(r.languageGraph, name, starting_node); err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		results = append(results, r.GenerateWithSeeded(name, starting_node, BatchSeed(masterSeed, i), tokens))
+	}
+	return results, nil
+}
+
+// GenerateBatch generates count snippets from the grammar identified by 'name', like Resrap.GenerateBatch.
+// The jobs are spread over the worker pool when it is running, and generated inline otherwise.
+// Results are returned in order and are identical to the single threaded ones for the same masterSeed.
+func (r *ResrapMT) GenerateBatch(ctx context.Context, name, starting_node string, count, tokens int, masterSeed uint64) ([]string, error) {
+	if err := checkStart(r.graphs(), name, starting_node); err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, count)
+	for code, err := range r.GenerateBatchIter(ctx, name, starting_node, count, tokens, masterSeed) {
+		if err != nil {
+			return results, err
+		}
+		results = append(results, code)
+	}
+	return results, nil
+}
+
+// GenerateBatchIter is the streaming form of GenerateBatch, yielding the snippets in index order
+// as soon as they are ready. When ctx is done or an item fails (e.g. its grammar was removed midway),
+// the error is yielded once with an empty snippet and iteration stops. It also stops when the caller breaks out of the loop.
+func (r *ResrapMT) GenerateBatchIter(ctx context.Context, name, starting_node string, count, tokens int, masterSeed uint64) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		if r.PoolSize() == 0 || r.isShutDown() {
+			for i := 0; i < count; i++ {
+				if err := ctx.Err(); err != nil {
+					yield("", err)
+					return
+				}
+				job := codeGenReq{name: name, startnode: starting_node, tokens: tokens, seed: BatchSeed(masterSeed, i), id: strconv.Itoa(i)}
+				res, err := r.generate(ctx, job)
+				if err != nil {
+					yield("", batchError(ctx, job.id, err))
+					return
+				}
+				if !yield(res.Code, nil) {
+					return
+				}
+			}
+			return
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		reply := make(chan CodeGenRes, count) //Buffered so workers never wait on an abandoned batch
+		go func() {
+			for i := 0; i < count; i++ {
+				job := codeGenReq{name: name, startnode: starting_node, tokens: tokens,
+					seed: BatchSeed(masterSeed, i), id: strconv.Itoa(i), reply: reply, ctx: ctx}
+				if r.trySubmit(ctx, job) {
+					continue
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				// The pool shut down midway, the rest of the batch is generated here like ResrapMT.generate does
+				res, err := r.generate(ctx, job)
+				res.Id, res.err = job.id, err
+				reply <- res
+			}
+		}()
+
+		// Results arrive in any order, hold them back until their turn
+		pending := make(map[int]string)
+		for next := 0; next < count; {
+			if code, ok := pending[next]; ok {
+				delete(pending, next)
+				if !yield(code, nil) {
+					return
+				}
+				next++
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				yield("", ctx.Err())
+				return
+			case res := <-reply:
+				if res.err != nil {
+					yield("", batchError(ctx, res.Id, res.err))
+					return
+				}
+				i, _ := strconv.Atoi(res.Id)
+				pending[i] = res.Code
+			}
+		}
+	}
+}
+
+// batchError is the error ending a batch at item id, ctx's own when the item was stopped midway
+func batchError(ctx context.Context, id string, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("batch item %s: %w", id, err)
+}
+
+// checkStart reports whether 'name' is a loaded grammar with a rule called starting_node
+func checkStart(graphs map[string]lang, name, starting_node string) error {
+	l, ok := graphs[name]
+	if !ok || l.graph == nil {
+		return fmt.Errorf("grammar '%s' not found", name)
+	}
+	if _, ok := l.graph.namemap[starting_node]; !ok {
+		return fmt.Errorf("starting node '%s' not found in grammar '%s'", starting_node, name)
+	}
+	return nil
+}
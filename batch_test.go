@@ -0,0 +1,137 @@
+package resrap
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBatchSeedIsSplitMix64(t *testing.T) {
+	// First outputs of SplitMix64 seeded with 0
+	for i, want := range []uint64{0xe220a8397b1dcdaf, 0x6e789e6aa1b965f4, 0x06c45d188009454f} {
+		if got := BatchSeed(0, i); got != want {
+			t.Errorf("BatchSeed(0, %d) = %#x, want %#x", i, got, want)
+		}
+	}
+}
+
+func TestBatchDeterministic(t *testing.T) {
+	const count, tokens, master = 40, 50, 7
+	r := NewResrap()
+	if err := r.ParseGrammarFile("c", "example/c.g4"); err != nil {
+		t.Fatal(err)
+	}
+	want, err := r.GenerateBatch(context.Background(), "c", "program", count, tokens, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, code := range want {
+		if single := r.GenerateWithSeeded("c", "program", BatchSeed(master, i), tokens); single != code {
+			t.Fatalf("item %d differs from GenerateWithSeeded with its seed", i)
+		}
+	}
+	again, _ := r.GenerateBatch(context.Background(), "c", "program", count, tokens, master)
+	if !slices.Equal(again, want) {
+		t.Fatal("same master seed, different batch")
+	}
+
+	// Pools of any size, running or not, return the same batch in the same order
+	for _, workers := range []int{0, 1, 3, 8} {
+		mt := NewResrapMT(max(workers, 1), 4)
+		if err := mt.ParseGrammarFile("c", "example/c.g4"); err != nil {
+			t.Fatal(err)
+		}
+		if workers > 0 {
+			mt.StartResrap()
+		}
+		got, err := mt.GenerateBatch(context.Background(), "c", "program", count, tokens, master)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%d workers: batch differs from Resrap's", workers)
+		}
+		next := 0
+		for code, err := range mt.GenerateBatchIter(context.Background(), "c", "program", count, tokens, master) {
+			if err != nil || code != want[next] {
+				t.Fatalf("%d workers: iterator yielded a different item %d (%v)", workers, next, err)
+			}
+			next++
+		}
+		if workers > 0 {
+			mt.ShutDownResrap()
+		}
+	}
+}
+
+func TestBatchCancelled(t *testing.T) {
+	r := NewResrap()
+	if err := r.ParseGrammarFile("c", "example/c.g4"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err := r.GenerateBatch(ctx, "c", "program", 10, 20, 1)
+	if !errors.Is(err, context.Canceled) || len(got) != 0 {
+		t.Fatalf("got %d items and %v, want none and context.Canceled", len(got), err)
+	}
+}
+
+func TestBatchSurvivesShutdown(t *testing.T) {
+	const count, tokens, master = 30, 40, 9
+	mt := NewResrapMT(2, 2)
+	if err := mt.ParseGrammarFile("c", "example/c.g4"); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := mt.GenerateBatch(context.Background(), "c", "program", count, tokens, master)
+	mt.StartResrap()
+	var got []string
+	for code, err := range mt.GenerateBatchIter(context.Background(), "c", "program", count, tokens, master) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == 2 {
+			mt.ShutDownResrap() //The rest of the batch is generated inline
+		}
+		got = append(got, code)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %d items, want the same %d as without the pool", len(got), len(want))
+	}
+}
+
+func TestBatchReportsItemErrors(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		mt := NewResrapMT(max(workers, 1), 4)
+		if err := mt.ParseGrammarFile("c", "example/c.g4"); err != nil {
+			t.Fatal(err)
+		}
+		if workers > 0 {
+			mt.StartResrap()
+		}
+		items := 0
+		var last error
+		for code, err := range mt.GenerateBatchIter(context.Background(), "c", "program", 50, 20, 1) {
+			if err != nil {
+				last = err
+				break
+			}
+			if code == "" {
+				t.Fatalf("%d workers: empty item %d", workers, items)
+			}
+			if items++; items == 3 {
+				if err := mt.ParseGrammar("c", "other : 'x' ;"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if last == nil || !strings.Contains(last.Error(), "starting node 'program' not found") {
+			t.Errorf("%d workers: got %v after %d items, want the missing start rule", workers, last, items)
+		}
+		if workers > 0 {
+			mt.ShutDownResrap()
+		}
+	}
+}
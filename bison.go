@@ -0,0 +1,350 @@
+package resrap
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Yacc and Bison grammars are imported from their rules section, generated with a space after
+// every token. Semantic actions are ignored. Tokens declared with %token carry no text, they
+// are generated as the text given with WithTokenText, their alias ("->") or their name.
+
+type bisonTokenKind uint8
+
+const (
+	yEOF       bisonTokenKind = iota
+	yIdent                    //Symbol
+	yChar                     //'c' literal, text is decoded
+	yString                   //"..." literal or alias, text is decoded
+	yNumber                   //Token number
+	yDirective                //%token, %left..., text holds it
+	ySections                 //%%
+	yPunct                    //: | ; ,
+)
+
+type bisonToken struct {
+	kind bisonTokenKind
+	text string
+	line int
+}
+
+// tokenizeBison splits the declarations and rules of a Bison grammar into tokens, dropping
+// comments, the prologue, actions, type tags and named references
+func tokenizeBison(src string) ([]bisonToken, error) {
+	var toks []bisonToken
+	line, sections := 1, 0
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case strings.HasPrefix(src[i:], "%%"):
+			toks = append(toks, bisonToken{ySections, "%%", line})
+			if sections++; sections == 2 {
+				return append(toks, bisonToken{yEOF, "", line}), nil //The epilogue is code
+			}
+			i += 2
+		case strings.HasPrefix(src[i:], "%{"):
+			end := strings.Index(src[i:], "%}")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated prologue", line)
+			}
+			line += strings.Count(src[i:i+end], "\n")
+			i += end + 2
+		case strings.HasPrefix(src[i:], "%?"):
+			i += 2 //%?{predicate} is skipped like an action
+		case c == '%':
+			j := i + 1
+			for j < len(src) && (isIdentPart(rune(src[j])) || src[j] == '-') {
+				j++
+			}
+			toks = append(toks, bisonToken{yDirective, src[i:j], line})
+			i = j
+		case isIdentStart(rune(c)) || c == '.':
+			j := i
+			for j < len(src) && (isIdentPart(rune(src[j])) || src[j] == '.' || src[j] == '-') {
+				j++
+			}
+			toks = append(toks, bisonToken{yIdent, src[i:j], line})
+			i = j
+		case isDigit(rune(c)):
+			j := i
+			for j < len(src) && isIdentPart(rune(src[j])) {
+				j++
+			}
+			toks = append(toks, bisonToken{yNumber, src[i:j], line})
+			i = j
+		case c == '\'' || c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != c && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != c {
+				return nil, fmt.Errorf("line %d: unterminated literal", line)
+			}
+			kind := yString
+			if c == '\'' {
+				kind = yChar
+			}
+			toks = append(toks, bisonToken{kind, unescapeANTLR(src[i+1 : j]), line})
+			i = j + 1
+		case c == '{':
+			end, err := skipAction(src, i)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			line += strings.Count(src[i:end], "\n")
+			i = end
+		case c == '<' || c == '[':
+			closing := map[byte]byte{'<': '>', '[': ']'}[c]
+			end := strings.IndexByte(src[i:], closing)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated %c", line, c)
+			}
+			i += end + 1
+		case strings.ContainsRune(":|;,=", rune(c)):
+			toks = append(toks, bisonToken{yPunct, string(c), line})
+			i++
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, r)
+		}
+	}
+	return append(toks, bisonToken{yEOF, "", line}), nil
+}
+
+type bisonParser struct {
+	toks    []bisonToken
+	pos     int
+	g       *ggrammar
+	current string            //Rule being read, for warnings
+	tokens  map[string]string //Declared tokens and their alias, "" when they have none
+	aliases map[string]string //Token named by each alias
+}
+
+// importBison converts a Yacc or Bison grammar
+func importBison(src string, c importConfig) (*ggrammar, error) {
+	toks, err := tokenizeBison(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &bisonParser{toks: toks, g: &ggrammar{sep: " "}, tokens: map[string]string{}, aliases: map[string]string{}}
+	if err := p.declarations(); err != nil {
+		return nil, err
+	}
+	if err := p.rules(); err != nil {
+		return nil, err
+	}
+	if len(p.g.rules) == 0 {
+		return nil, fmt.Errorf("grammar has no rules")
+	}
+	if p.g.start == "" {
+		p.g.start = p.g.rules[0].name
+	}
+
+	// Tokens are the symbols without rules, generated as the text they are given
+	defined := map[string]bool{}
+	for _, r := range p.g.rules {
+		defined[r.name] = true
+	}
+	var used []string
+	for _, r := range p.g.rules {
+		r.expr.refs(func(name string) {
+			if !defined[name] {
+				defined[name] = true
+				used = append(used, name)
+			}
+		})
+	}
+	for _, name := range used {
+		text, ok := c.tokens[name]
+		switch {
+		case ok:
+		case p.tokens[name] != "":
+			text = p.tokens[name]
+			p.g.warn(name, "token without text, generated as its alias '%s'", text)
+		default:
+			text = strings.ToLower(name)
+			p.g.warn(name, "token without text, generated as '%s'", text)
+		}
+		p.g.rules = append(p.g.rules, &grule{name: name, expr: gLiteral(text), lexical: true})
+	}
+	return p.g, nil
+}
+
+func (p *bisonParser) peek() bisonToken { return p.toks[p.pos] }
+
+func (p *bisonParser) next() bisonToken {
+	tok := p.toks[p.pos]
+	if tok.kind != yEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *bisonParser) accept(punct string) bool {
+	if tok := p.peek(); tok.kind == yPunct && tok.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *bisonParser) errorf(format string, args ...any) error {
+	tok := p.peek()
+	found := "end of grammar"
+	if tok.kind != yEOF {
+		found = fmt.Sprintf("'%s'", tok.text)
+	}
+	return fmt.Errorf("line %d: %s, found %s", tok.line, fmt.Sprintf(format, args...), found)
+}
+
+// declarations reads the tokens and the start symbol declared before the first %%
+func (p *bisonParser) declarations() error {
+	for {
+		tok := p.next()
+		switch tok.kind {
+		case yEOF:
+			return fmt.Errorf("no %%%% before the rules")
+		case ySections:
+			return nil
+		case yDirective:
+			switch tok.text {
+			case "%start":
+				if p.peek().kind == yIdent {
+					p.g.start = p.next().text
+				}
+			case "%token", "%left", "%right", "%nonassoc", "%precedence":
+				p.tokenList()
+			}
+		}
+	}
+}
+
+// tokenList reads the symbols of a token declaration, each with an optional number and alias
+func (p *bisonParser) tokenList() {
+	for {
+		switch tok := p.peek(); tok.kind {
+		case yIdent:
+			p.pos++
+			if _, ok := p.tokens[tok.text]; !ok {
+				p.tokens[tok.text] = ""
+			}
+			if p.peek().kind == yNumber {
+				p.pos++
+			}
+			if alias := p.peek(); alias.kind == yString {
+				p.pos++
+				p.tokens[tok.text] = alias.text
+				p.aliases[alias.text] = tok.text
+			}
+		case yChar, yString, yNumber:
+			p.pos++
+		case yPunct:
+			if tok.text != "," {
+				return
+			}
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// rules reads the rules up to the second %%, merging the alternatives of rules defined twice
+func (p *bisonParser) rules() error {
+	rules := map[string]*grule{}
+	for k := p.peek().kind; k != yEOF && k != ySections; k = p.peek().kind {
+		if p.peek().kind == yDirective { //Declarations are allowed between rules
+			p.pos++
+			continue
+		}
+		name := p.next()
+		if name.kind != yIdent || !p.accept(":") {
+			p.pos--
+			return p.errorf("expected a rule like 'name: ...'")
+		}
+		p.current = name.text
+		expr, err := p.alternatives()
+		if err != nil {
+			return err
+		}
+		p.accept(";")
+		if r, ok := rules[name.text]; ok {
+			r.expr = gAltOf(r.expr, expr)
+			continue
+		}
+		rules[name.text] = &grule{name: name.text, expr: expr}
+		p.g.rules = append(p.g.rules, rules[name.text])
+	}
+	return nil
+}
+
+// ruleStart reports whether the tokens from the current one start the next rule
+func (p *bisonParser) ruleStart() bool {
+	tok, after := p.peek(), p.toks[min(p.pos+1, len(p.toks)-1)]
+	return tok.kind == yIdent && after.kind == yPunct && after.text == ":"
+}
+
+func (p *bisonParser) alternatives() (*gexpr, error) {
+	var alts []*gexpr
+	for {
+		var items []*gexpr
+		for !p.ruleStart() {
+			tok := p.peek()
+			if tok.kind == yEOF || tok.kind == ySections || tok.kind == yPunct && tok.text != "=" && tok.text != "," {
+				break
+			}
+			p.pos++
+			switch tok.kind {
+			case yIdent:
+				if tok.text == "error" {
+					p.g.warn(p.current, "error recovery alternatives dropped")
+					items = append(items, &gexpr{kind: gNone})
+				} else {
+					items = append(items, gRule(tok.text))
+				}
+			case yChar:
+				items = append(items, gLiteral(tok.text))
+			case yString:
+				if name, ok := p.aliases[tok.text]; ok {
+					items = append(items, gRule(name)) //Generated as the text of the token
+				} else {
+					items = append(items, gLiteral(tok.text))
+				}
+			case yDirective:
+				switch tok.text {
+				case "%prec", "%dprec", "%merge", "%expect", "%expect-rr":
+					if k := p.peek().kind; k == yIdent || k == yChar || k == yString || k == yNumber {
+						p.pos++
+					}
+				case "%empty":
+				default:
+					return nil, fmt.Errorf("line %d: unexpected %s in rule %s", tok.line, tok.text, p.current)
+				}
+			}
+		}
+		alts = append(alts, gSeqOf(items...))
+		if !p.accept("|") {
+			return gAltOf(alts...), nil
+		}
+	}
+}
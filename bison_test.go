@@ -0,0 +1,50 @@
+package resrap
+
+import "testing"
+
+func TestImportBison(t *testing.T) {
+	testImports(t, FormatBison, []importCase{
+		{
+			name: "tokens, aliases and actions",
+			src: `%{
+#include <stdio.h>
+%}
+%token NUM
+%token ARROW "->"
+%token <str> ID
+%start input
+%%
+line  : exp '\n' { printf("%d\n", $1); }
+      ;
+input : %empty
+      | input line
+      | input error '\n' { yyerrok; }
+      ;
+exp   : NUM | ID "->" exp %prec ARROW | exp[left] '+' exp[right] ;
+%%
+int main(void) { return yyparse(); }
+`,
+			want: `program: input;
+line: exp '\n ';
+input: input_1?;
+input_1: input line;
+exp: NUM ' ' | ID ' ' ARROW ' ' exp | exp '+ ' exp;
+NUM: '42';
+ID: 'id';
+ARROW: '->';
+`,
+			warnings: []string{"input: error recovery alternatives dropped", "ID: token without text, generated as 'id'", "ARROW: token without text, generated as its alias '->'"},
+			sample:   "id -> 42 + 42 \n ",
+		},
+		{
+			name: "quotes",
+			src: `%%
+s : '\'' | "\"" | '\\' ;
+`,
+			want: `program: s;
+s: '\' ' | '" ' | '\\ ';
+`,
+			sample: "' ",
+		},
+	}, WithTokenText(map[string]string{"NUM": "42"}))
+}
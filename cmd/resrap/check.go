@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/osdc/resrap"
+)
+
+// checkResult is a diagnostic of check -json
+type checkResult struct {
+	File     string `json:"file"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule,omitempty"`
+	Message  string `json:"message"`
+}
+
+func runCheck(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("check", "grammar...", stderr)
+	start := fs.String("start", "program", "rule generation starts from")
+	strict := fs.Bool("strict", false, "fail on warnings too")
+	asJSON := fs.Bool("json", false, "print the diagnostics as a JSON array")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return usageError("expected at least one grammar file")
+	}
+
+	results := []checkResult{}
+	failed := false
+	for _, arg := range fs.Args() {
+		_, location := grammarArg(arg)
+		r := resrap.NewResrap()
+		name, err := loadGrammar(r, arg)
+		if exit, ok := err.(*exitError); ok && exit.code == exitIO {
+			return err
+		}
+		for _, d := range r.Lint(name, *start) {
+			if d.Severity == resrap.SeverityError || *strict {
+				failed = true
+			}
+			results = append(results, checkResult{File: location, Severity: d.Severity.String(), Rule: d.Rule, Message: d.Message})
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return ioError(err)
+		}
+	} else {
+		for _, res := range results {
+			if res.Rule != "" {
+				fmt.Fprintf(stdout, "%s: %s: rule '%s': %s\n", res.File, res.Severity, res.Rule, res.Message)
+			} else {
+				fmt.Fprintf(stdout, "%s: %s: %s\n", res.File, res.Severity, res.Message)
+			}
+		}
+	}
+	if failed {
+		return problemError(errSilent)
+	}
+	return nil
+}
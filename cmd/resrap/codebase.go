@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/osdc/resrap"
+)
+
+func runCodebase(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("codebase", "spec grammar...", stderr)
+	out := flags.String("o", ".", "directory the codebase is written under, or a .tar, .tar.gz, .tgz or .zip archive")
+	workers := flags.Int("workers", 0, "files generated in parallel (default GOMAXPROCS)")
+	dryRun := flags.Bool("dry-run", false, "plan the codebase and print its manifest without generating anything")
+	manifest := flags.String("manifest", "", "also write the manifest of the codebase to this file")
+	commits := flags.Int("commits", 0, "turn the codebase into a git repository with this many commits")
+	verbose := flags.Bool("v", false, "print every file written to stderr")
+	var seed seedFlag
+	flags.Var(&seed, "seed", "seed of the codebase, random and printed to stderr when not set")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return usageError("expected a specification and at least one grammar file")
+	}
+	if *commits < 0 || *workers < 0 {
+		return usageError("-commits and -workers can't be negative")
+	}
+
+	r := resrap.NewResrap()
+	for _, arg := range flags.Args()[1:] {
+		if _, err := loadGrammar(r, arg); err != nil {
+			return err
+		}
+	}
+	opts := []resrap.CodebaseOption{resrap.WithCodebaseSeed(seed.orRandom(stderr))}
+	if *workers > 0 {
+		opts = append(opts, resrap.WithWorkers(*workers))
+	}
+	if *commits > 0 {
+		opts = append(opts, resrap.WithGitHistory(resrap.GitHistory{Commits: *commits}))
+	}
+	if *verbose {
+		opts = append(opts, resrap.WithProgress(func(path string, tokens, bytes int) {
+			fmt.Fprintf(stderr, "%s (%d tokens, %d bytes)\n", path, tokens, bytes)
+		}))
+	}
+	if *dryRun {
+		opts = append(opts, resrap.WithDryRun())
+	}
+
+	// Archives are written through a sink, a directory is the default disk sink
+	target := *out
+	var finish func() error
+	if archive := archiveKind(*out); archive != "" && !*dryRun {
+		f, err := os.Create(*out)
+		if err != nil {
+			return ioError(err)
+		}
+		var sink interface {
+			resrap.CodebaseSink
+			Close() error
+		}
+		if archive == "zip" {
+			sink = resrap.NewZipSink(f)
+		} else {
+			sink = resrap.NewTarSink(f, archive == "tar.gz")
+		}
+		opts = append(opts, resrap.WithSink(sink))
+		target = ""
+		finish = func() error {
+			if err := sink.Close(); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		}
+	}
+
+	summary, err := r.GenerateCodebase(flags.Arg(0), target, opts...)
+	if finish != nil {
+		if ferr := finish(); err == nil && ferr != nil {
+			return ioError(ferr)
+		}
+	}
+	if err != nil {
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			return ioError(err)
+		}
+		return problemError(err)
+	}
+
+	if *dryRun {
+		if err := resrap.WriteManifestJSON(stdout, summary.Manifest); err != nil {
+			return ioError(err)
+		}
+	}
+	if *manifest != "" {
+		f, err := os.Create(*manifest)
+		if err != nil {
+			return ioError(err)
+		}
+		if err := resrap.WriteManifestJSON(f, summary.Manifest); err != nil {
+			f.Close()
+			return ioError(err)
+		}
+		if err := f.Close(); err != nil {
+			return ioError(err)
+		}
+	}
+	if !*dryRun {
+		fmt.Fprintf(stderr, "%d files in %d directories, %d bytes, %d tokens\n", summary.Files, summary.Dirs, summary.Bytes, summary.Tokens)
+		if summary.Head != "" {
+			fmt.Fprintf(stderr, "HEAD %s\n", summary.Head)
+		}
+	}
+	return nil
+}
+
+// archiveKind returns the archive format of an output path, empty for a directory
+func archiveKind(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".zip"):
+		return "zip"
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(path, ".tar"):
+		return "tar"
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/osdc/resrap"
+)
+
+// tokenFlag collects repeated NAME=text flags, the text Bison tokens are generated as
+type tokenFlag map[string]string
+
+func (t tokenFlag) String() string { return "" }
+
+func (t tokenFlag) Set(v string) error {
+	name, text, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected NAME=text, got '%s'", v)
+	}
+	t[name] = text
+	return nil
+}
+
+func runConvert(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("convert", "grammar...", stderr)
+	from := fs.String("from", "auto", "format of the grammars: auto, antlr4, w3c-ebnf, rfc5234, tree-sitter, bison or peg")
+	out := fs.String("o", "", "output file (default stdout)")
+	tokens := tokenFlag{}
+	fs.Var(tokens, "token", "text a Bison token is generated as, like NUMBER=42 (repeatable)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return usageError("expected at least one grammar file")
+	}
+
+	// Split grammars, like a parser and its lexer, are converted together
+	var src strings.Builder
+	for _, location := range fs.Args() {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return ioError(err)
+		}
+		src.Write(data)
+		src.WriteString("\n")
+	}
+	format := resrap.DetectGrammarFormat(src.String())
+	if *from != "auto" {
+		var err error
+		if format, err = resrap.ParseGrammarFormat(*from); err != nil {
+			return usageError("%v", err)
+		}
+	}
+
+	text, warnings, err := resrap.ConvertGrammar(src.String(), format, resrap.WithTokenText(tokens))
+	if err != nil {
+		return problemError(err)
+	}
+	for _, d := range warnings {
+		fmt.Fprintln(stderr, d)
+	}
+	if err := resrap.NewResrap().ParseGrammar("converted", text); err != nil {
+		return problemError(fmt.Errorf("converted grammar doesn't parse: %w", err))
+	}
+
+	w, closeOut, err := output(*out, stdout)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, text); err != nil {
+		closeOut()
+		return ioError(err)
+	}
+	if err := closeOut(); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/osdc/resrap"
+)
+
+func runEnumerate(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("enumerate", "grammar", stderr)
+	start := fs.String("start", "program", "rule to start from")
+	tokens := fs.Int("tokens", 5, "longest output to list, in tokens")
+	limit := fs.Int("limit", 100, "most outputs to list, 0 for no limit")
+	raw := fs.Bool("raw", false, "print outputs as they are, one per line, instead of quoted")
+	asJSON := fs.Bool("json", false, "print the outputs as a JSON array")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("expected exactly one grammar file")
+	}
+	if *tokens < 0 {
+		return usageError("-tokens can't be negative")
+	}
+
+	r := resrap.NewResrap()
+	name, err := loadGrammar(r, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	outputs, err := r.Enumerate(name, *start, *tokens, *limit)
+	if errors.Is(err, resrap.ErrEnumerationIncomplete) {
+		fmt.Fprintf(stderr, "resrap enumerate: %v, lower -tokens to list them all\n", err)
+	} else if err != nil {
+		return problemError(err)
+	}
+
+	if *asJSON {
+		if outputs == nil {
+			outputs = []string{}
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(outputs); err != nil {
+			return ioError(err)
+		}
+		return nil
+	}
+	for _, code := range outputs {
+		if !*raw {
+			code = strconv.Quote(code)
+		}
+		if _, err := fmt.Fprintln(stdout, code); err != nil {
+			return ioError(err)
+		}
+	}
+	return nil
+}
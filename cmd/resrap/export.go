@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+
+	"github.com/osdc/resrap"
+)
+
+func runExport(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("export", "grammar", stderr)
+	to := fs.String("to", "w3c-ebnf", "notation to export to: w3c-ebnf, antlr4 or svg (railroad diagrams)")
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("expected exactly one grammar file")
+	}
+	format, err := resrap.ParseExportFormat(*to)
+	if err != nil {
+		return usageError("%v", err)
+	}
+
+	r := resrap.NewResrap()
+	name, err := loadGrammar(r, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	text, err := r.ExportGrammar(name, format)
+	if err != nil {
+		return problemError(err)
+	}
+	w, closeOut, err := output(*out, stdout)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, text); err != nil {
+		closeOut()
+		return ioError(err)
+	}
+	if err := closeOut(); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
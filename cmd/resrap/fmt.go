@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/osdc/resrap"
+)
+
+// runFmt formats the grammar files given, or stdin when there are none
+func runFmt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("fmt", "[grammar...]", stderr)
+	write := fs.Bool("w", false, "write the result to the grammar files instead of stdout")
+	list := fs.Bool("l", false, "list the grammars whose formatting differs")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		if *write {
+			return usageError("can't use -w with stdin")
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return ioError(err)
+		}
+		text, err := resrap.FormatGrammar(string(data))
+		if err != nil {
+			return problemError(err)
+		}
+		if *list {
+			if text != string(data) {
+				fmt.Fprintln(stdout, "<stdin>")
+			}
+			return nil
+		}
+		_, err = io.WriteString(stdout, text)
+		return err
+	}
+
+	failed := false
+	for _, location := range fs.Args() {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return ioError(err)
+		}
+		text, err := resrap.FormatGrammar(string(data))
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", location, err)
+			failed = true
+			continue
+		}
+		changed := text != string(data)
+		if *list && changed {
+			fmt.Fprintln(stdout, location)
+		}
+		if *write && changed {
+			if err := os.WriteFile(location, []byte(text), 0644); err != nil {
+				return ioError(err)
+			}
+		}
+		if !*list && !*write {
+			io.WriteString(stdout, text)
+		}
+	}
+	if failed {
+		return problemError(fmt.Errorf("some grammars don't parse"))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/osdc/resrap"
+)
+
+// seedFlag is a uint64 flag that remembers whether it was set
+type seedFlag struct {
+	value uint64
+	set   bool
+}
+
+func (s *seedFlag) String() string {
+	if !s.set {
+		return ""
+	}
+	return strconv.FormatUint(s.value, 10)
+}
+
+func (s *seedFlag) Set(v string) error {
+	n, err := strconv.ParseUint(v, 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid seed '%s'", v)
+	}
+	s.value, s.set = n, true
+	return nil
+}
+
+// orRandom returns the seed, picking and reporting a random one when it wasn't set
+func (s *seedFlag) orRandom(stderr io.Writer) uint64 {
+	if !s.set {
+		s.value, s.set = rand.Uint64(), true
+		fmt.Fprintf(stderr, "seed: %d\n", s.value)
+	}
+	return s.value
+}
+
+func runGenerate(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("generate", "grammar", stderr)
+	start := fs.String("start", "program", "rule to start generating from")
+	tokens := fs.Int("tokens", 100, "number of tokens to generate")
+	count := fs.Int("count", 1, "number of outputs, seeded from -seed as a batch")
+	out := fs.String("o", "", "output file, '%d' is replaced by the output number when -count > 1 (default stdout)")
+	var seed seedFlag
+	fs.Var(&seed, "seed", "seed to generate with, random and printed to stderr when not set")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("expected exactly one grammar file")
+	}
+	if *tokens <= 0 || *count <= 0 {
+		return usageError("-tokens and -count must be positive")
+	}
+
+	r := resrap.NewResrap()
+	name, err := loadGrammar(r, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var outputs []string
+	if *count == 1 {
+		if diags := r.Lint(name, *start); len(diags) > 0 && diags[0].Severity == resrap.SeverityError {
+			return problemError(fmt.Errorf("%s: %v", fs.Arg(0), diags[0]))
+		}
+		outputs = []string{r.GenerateWithSeeded(name, *start, seed.orRandom(stderr), *tokens)}
+	} else {
+		outputs, err = r.GenerateBatch(context.Background(), name, *start, *count, *tokens, seed.orRandom(stderr))
+		if err != nil {
+			return problemError(err)
+		}
+	}
+
+	if *count > 1 && strings.Contains(*out, "%d") {
+		for i, code := range outputs {
+			if err := os.WriteFile(strings.ReplaceAll(*out, "%d", strconv.Itoa(i)), []byte(code), 0644); err != nil {
+				return ioError(err)
+			}
+		}
+		return nil
+	}
+	w, closeOut, err := output(*out, stdout)
+	if err != nil {
+		return err
+	}
+	for i, code := range outputs {
+		if i > 0 {
+			io.WriteString(w, "\n")
+		}
+		if _, err := io.WriteString(w, code); err != nil {
+			closeOut()
+			return ioError(err)
+		}
+	}
+	if err := closeOut(); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
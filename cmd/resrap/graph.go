@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+
+	"github.com/osdc/resrap"
+)
+
+func runGraph(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("graph", "grammar", stderr)
+	format := fs.String("format", "dot", "output format, dot or json")
+	rule := fs.String("rule", "", "limit the graph to the nodes of a rule")
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("expected exactly one grammar file")
+	}
+	export := (*resrap.Resrap).ExportDOT
+	switch *format {
+	case "dot":
+	case "json":
+		export = (*resrap.Resrap).ExportGraphJSON
+	default:
+		return usageError("unknown format '%s', expected dot or json", *format)
+	}
+
+	r := resrap.NewResrap()
+	name, err := loadGrammar(r, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if _, err := r.ExportGraph(name, *rule); err != nil {
+		return problemError(err)
+	}
+	w, closeOut, err := output(*out, stdout)
+	if err != nil {
+		return err
+	}
+	if err := export(r, w, name, *rule); err != nil {
+		closeOut()
+		return ioError(err)
+	}
+	if err := closeOut(); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"io"
+
+	"github.com/osdc/resrap"
+)
+
+func runLSP(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("lsp", "", stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return usageError("lsp takes no arguments, it talks to the editor on stdin and stdout")
+	}
+	if err := resrap.ServeLSP(stdin, stdout); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
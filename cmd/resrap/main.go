@@ -0,0 +1,198 @@
+// Command resrap generates code from grammars, checks grammars and generates whole codebases.
+//
+// Usage:
+//
+//	resrap <command> [flags] [arguments]
+//
+// Run 'resrap help' for the list of commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/osdc/resrap"
+)
+
+// Exit codes, stable so CI scripts can rely on them
+const (
+	exitOK       = 0
+	exitProblems = 1 // Grammar or specification problems, or findings of check
+	exitUsage    = 2 // Invalid command line
+	exitIO       = 3 // Files that can't be read or written
+)
+
+// exitError carries the exit code a command failed with
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func usageError(format string, args ...any) error {
+	return &exitError{exitUsage, fmt.Errorf(format, args...)}
+}
+
+func ioError(err error) error {
+	return &exitError{exitIO, err}
+}
+
+func problemError(err error) error {
+	return &exitError{exitProblems, err}
+}
+
+// command is a subcommand of resrap
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+func commands() []command {
+	return []command{
+		{"generate", "generate code from a grammar", runGenerate},
+		{"check", "parse, validate and lint grammars", runCheck},
+		{"graph", "export the syntax graph of a grammar as DOT or JSON", runGraph},
+		{"codebase", "generate a codebase from a specification", runCodebase},
+		{"fmt", "format grammars, aligning rules and normalizing probabilities", func(args []string, stdout, stderr io.Writer) error {
+			return runFmt(args, os.Stdin, stdout, stderr)
+		}},
+		{"convert", "convert grammars written in other notations to Resrap's", runConvert},
+		{"export", "export a grammar to W3C EBNF, ANTLR4 or railroad diagrams", runExport},
+		{"enumerate", "list every output of a grammar up to a token count", runEnumerate},
+		{"serve", "serve grammars over an HTTP JSON API", runServe},
+		{"lsp", "serve grammar files to editors over the language server protocol", func(args []string, stdout, stderr io.Writer) error {
+			return runLSP(args, os.Stdin, stdout, stderr)
+		}},
+		{"repl", "edit a grammar interactively and watch its output change", func(args []string, stdout, stderr io.Writer) error {
+			return runRepl(args, os.Stdin, stdout, stderr)
+		}},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stderr)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+	for _, cmd := range commands() {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(args[1:], stdout, stderr)
+		if err == nil {
+			return exitOK
+		}
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		var exit *exitError
+		if errors.As(err, &exit) {
+			if exit.err != errSilent {
+				fmt.Fprintf(stderr, "resrap %s: %v\n", cmd.name, exit.err)
+			}
+			return exit.code
+		}
+		fmt.Fprintf(stderr, "resrap %s: %v\n", cmd.name, err)
+		return exitProblems
+	}
+	fmt.Fprintf(stderr, "resrap: unknown command '%s'\n", args[0])
+	printUsage(stderr)
+	return exitUsage
+}
+
+// errSilent marks failures whose details were already printed
+var errSilent = errors.New("")
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: resrap <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'resrap <command> -h' for the flags of a command.")
+	fmt.Fprintln(w, "Exit codes: 0 success, 1 grammar or specification problems, 2 invalid usage, 3 file errors.")
+}
+
+// newFlagSet creates the flag set of a subcommand, printing its usage to stderr
+func newFlagSet(name, args string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: resrap %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args, mapping flag errors to usage errors
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &exitError{exitUsage, errSilent}
+	}
+	return nil
+}
+
+// grammarArg splits a "name=path" grammar argument, the name defaulting to the file name without extension
+func grammarArg(arg string) (name, location string) {
+	if name, location, ok := strings.Cut(arg, "="); ok && name != "" && !strings.ContainsAny(name, `/\`) {
+		return name, location
+	}
+	base := filepath.Base(arg)
+	return strings.TrimSuffix(base, filepath.Ext(base)), arg
+}
+
+// grammarLoader is a Resrap or a ResrapMT, whichever loadGrammar loads into
+type grammarLoader interface {
+	ParseGrammarFile(name, location string) error
+	ParseGrammarAs(name, grammar string, format resrap.GrammarFormat, opts ...resrap.ImportOption) error
+}
+
+// loadGrammar loads a grammar argument into r and returns its name, converting it when it is written in another format
+func loadGrammar(r grammarLoader, arg string) (string, error) {
+	name, location := grammarArg(arg)
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return name, ioError(err)
+	}
+	if format := resrap.DetectGrammarFormat(string(data)); format != resrap.FormatResrap {
+		err = r.ParseGrammarAs(name, string(data), format)
+	} else {
+		err = r.ParseGrammarFile(name, location)
+	}
+	if err != nil {
+		return name, problemError(fmt.Errorf("%s: %w", location, err))
+	}
+	return name, nil
+}
+
+// output opens path for writing, stdout when path is "" or "-"
+func output(path string, stdout io.Writer) (io.Writer, func() error, error) {
+	if path == "" || path == "-" {
+		return stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, ioError(err)
+	}
+	return f, f.Close, nil
+}
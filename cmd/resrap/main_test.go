@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/osdc/resrap"
+)
+
+const wordsGrammar = "program : word (' ' word)* ;\nword : 'a' | 'b' ;\n"
+
+// writeFiles writes files under a temporary directory and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRunExitCodes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"words.g4":   wordsGrammar,
+		"unused.g4":  "program : 'a' ;\nunused : 'b' ;\n",
+		"missing.g4": "program : missing ;\n",
+		"broken.g4":  "program : 'a' \n",
+		"spec.dsl":   "src/\n  words[2x10 *.txt]\n",
+		"bad.dsl":    "src/\n  words[2x10]\n",
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+	seeded := resrap.NewResrap()
+	if err := seeded.ParseGrammar("words", wordsGrammar); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string //Expected in stdout
+		stderr string //Expected in stderr
+	}{
+		{"no command", nil, exitUsage, "", "Usage: resrap <command>"},
+		{"help", []string{"help"}, exitOK, "", "Exit codes: 0 success"},
+		{"unknown command", []string{"frob"}, exitUsage, "", "unknown command 'frob'"},
+		{"command help", []string{"generate", "-h"}, exitOK, "", "Usage: resrap generate"},
+		{"unknown flag", []string{"generate", "-bogus", path("words.g4")}, exitUsage, "", "flag provided but not defined: -bogus"},
+		{"missing argument", []string{"generate"}, exitUsage, "", "expected exactly one grammar file"},
+		{"bad value", []string{"generate", "-tokens", "0", path("words.g4")}, exitUsage, "", "-tokens and -count must be positive"},
+		{"bad seed", []string{"generate", "-seed", "x", path("words.g4")}, exitUsage, "", "invalid seed 'x'"},
+
+		{"generate", []string{"generate", "-seed", "3", "-tokens", "9", path("words.g4")}, exitOK, seeded.GenerateWithSeeded("words", "program", 3, 9), ""},
+		{"generate random seed", []string{"generate", "-tokens", "1", path("words.g4")}, exitOK, "", "seed: "},
+		{"generate unreadable", []string{"generate", path("nope.g4")}, exitIO, "", "nope.g4"},
+		{"generate invalid grammar", []string{"generate", path("missing.g4")}, exitProblems, "", "Definition of 'missing' not found"},
+		{"generate unknown start", []string{"generate", "-start", "nope", path("words.g4")}, exitProblems, "", "nope"},
+
+		{"check", []string{"check", path("words.g4")}, exitOK, "", ""},
+		{"check warning", []string{"check", path("unused.g4")}, exitOK, "warning: rule 'unused': never used from 'program'", ""},
+		{"check strict", []string{"check", "-strict", path("unused.g4")}, exitProblems, "warning: rule 'unused'", ""},
+		{"check error", []string{"check", path("words.g4"), path("missing.g4")}, exitProblems, "missing.g4: error:", ""},
+		{"check unreadable", []string{"check", path("nope.g4")}, exitIO, "", "nope.g4"},
+
+		{"fmt", []string{"fmt", path("words.g4")}, exitOK, "program: word (' ' word)*;\nword   : 'a' | 'b';\n", ""},
+		{"fmt broken", []string{"fmt", path("broken.g4")}, exitProblems, "", "some grammars don't parse"},
+		{"convert", []string{"convert", path("words.g4")}, exitOK, "word : 'a' | 'b' ;", ""},
+		{"convert unknown format", []string{"convert", "-from", "cobol", path("words.g4")}, exitUsage, "", "cobol"},
+		{"export", []string{"export", "-to", "antlr4", path("words.g4")}, exitOK, "grammar words;", ""},
+		{"export svg", []string{"export", "-to", "svg", path("words.g4")}, exitOK, "<svg", ""},
+		{"export unknown format", []string{"export", "-to", "svg2", path("words.g4")}, exitUsage, "", "unknown export format 'svg2'"},
+		{"graph", []string{"graph", "-rule", "word", path("words.g4")}, exitOK, "digraph", ""},
+		{"graph unknown format", []string{"graph", "-format", "png", path("words.g4")}, exitUsage, "", "unknown format 'png'"},
+		{"graph unknown rule", []string{"graph", "-rule", "nope", path("words.g4")}, exitProblems, "", "nope"},
+		{"enumerate", []string{"enumerate", "-tokens", "2", path("words.g4")}, exitOK, "\"a\"\n\"b\"\n", ""},
+		{"lsp arguments", []string{"lsp", "x"}, exitUsage, "", "lsp takes no arguments"},
+		{"repl unreadable", []string{"repl", path("nope.g4")}, exitIO, "", "nope.g4"},
+
+		{"codebase dry run", []string{"codebase", "-dry-run", "-seed", "1", path("spec.dsl"), "words=" + path("words.g4")}, exitOK, "src/", ""},
+		{"codebase bad spec", []string{"codebase", "-dry-run", "-seed", "1", path("bad.dsl"), "words=" + path("words.g4")}, exitProblems, "", ""},
+		{"codebase unknown grammar", []string{"codebase", "-dry-run", "-seed", "1", path("spec.dsl"), path("unused.g4")}, exitProblems, "", "words"},
+		{"codebase unreadable spec", []string{"codebase", path("nope.dsl"), path("words.g4")}, exitIO, "", "nope.dsl"},
+		{"codebase negative", []string{"codebase", "-commits", "-1", path("spec.dsl"), path("words.g4")}, exitUsage, "", "can't be negative"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(c.args, &stdout, &stderr)
+			if code != c.code {
+				t.Errorf("exit code %d, expected %d\nstdout: %s\nstderr: %s", code, c.code, stdout.String(), stderr.String())
+			}
+			if !strings.Contains(stdout.String(), c.stdout) {
+				t.Errorf("stdout doesn't contain %q:\n%s", c.stdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), c.stderr) {
+				t.Errorf("stderr doesn't contain %q:\n%s", c.stderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunOutputs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"words.g4": wordsGrammar, "missing.g4": "program : missing ;\n", "spec.dsl": "src/\n  words[2x10 *.txt]\n"})
+	words := filepath.Join(dir, "words.g4")
+	r := resrap.NewResrap()
+	if err := r.ParseGrammar("words", wordsGrammar); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch is written to numbered files, each the output of the library batch
+	want, err := r.GenerateBatch(t.Context(), "words", "program", 3, 7, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	out := filepath.Join(dir, "out_%d.txt")
+	if code := run([]string{"generate", "-count", "3", "-tokens", "7", "-seed", "11", "-o", out, words}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	for i, code := range want {
+		got, err := os.ReadFile(strings.ReplaceAll(out, "%d", strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != code {
+			t.Errorf("output %d is %q, expected %q", i, got, code)
+		}
+	}
+
+	// check -json reports every diagnostic with its file
+	stdout.Reset()
+	if code := run([]string{"check", "-json", filepath.Join(dir, "missing.g4")}, &stdout, &stderr); code != exitProblems {
+		t.Errorf("check -json exit code %d", code)
+	}
+	var results []checkResult
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatalf("check -json output isn't JSON: %v\n%s", err, stdout.String())
+	}
+	if len(results) != 1 || results[0].Severity != "error" || !strings.Contains(results[0].Message, "'missing'") || results[0].File != filepath.Join(dir, "missing.g4") {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	// A codebase is written under -o
+	stdout.Reset()
+	target := filepath.Join(dir, "codebase")
+	if code := run([]string{"codebase", "-seed", "5", "-o", target, filepath.Join(dir, "spec.dsl"), words}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("codebase exit code %d: %s", code, stderr.String())
+	}
+	files, err := filepath.Glob(filepath.Join(target, "src", "*.txt"))
+	if err != nil || len(files) != 2 {
+		t.Errorf("codebase wrote %v, expected 2 files", files)
+	}
+}
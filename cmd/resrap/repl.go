@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/osdc/resrap"
+)
+
+const replHelp = `Rules:
+  name: body;          define or redefine a rule, then regenerate with the same seed and show the diff
+Commands:
+  :gen                 generate with the current seed
+  :next                generate with a new random seed
+  :seed [N]            show or set the seed
+  :tokens [N]          show or set the number of tokens
+  :start [rule]        show or set the starting rule
+  :trace [depth]       show the rules expanded for the current sample
+  :weights rule        show the choices of a rule and their probabilities
+  :graph rule [dot]    show the syntax graph of a rule, as DOT with 'dot'
+  :rules               list the rules
+  :show rule           print the definition of a rule
+  :undo                revert the last redefinition
+  :reload              read the grammar file again
+  :write [file]        save the grammar, to the file it was loaded from by default
+  :help                show this help
+  :quit                leave`
+
+// ruleSource is the text of a rule definition, body without the closing ';'
+type ruleSource struct {
+	name string
+	body string
+}
+
+// replSession is the state of a REPL: the grammar being edited and the sample it generates
+type replSession struct {
+	file    string
+	name    string
+	rules   []ruleSource
+	history [][]ruleSource //Previous definitions, for :undo
+	r       *resrap.Resrap
+	start   string
+	seed    uint64
+	tokens  int
+	sample  string
+	out     io.Writer
+}
+
+func runRepl(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("repl", "grammar", stderr)
+	start := fs.String("start", "program", "rule to start generating from")
+	tokens := fs.Int("tokens", 50, "number of tokens to generate")
+	var seed seedFlag
+	fs.Var(&seed, "seed", "seed to generate with, random when not set")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("expected exactly one grammar file")
+	}
+	if *tokens <= 0 {
+		return usageError("-tokens must be positive")
+	}
+	if !seed.set {
+		seed.value = rand.Uint64()
+	}
+
+	name, location := grammarArg(fs.Arg(0))
+	s := &replSession{file: location, name: name, start: *start, seed: seed.value, tokens: *tokens, out: stdout}
+	if err := s.reload(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "grammar '%s', %d rules, seed %d. Type :help for commands.\n", name, len(s.rules), s.seed)
+	s.generate(false)
+
+	in := bufio.NewScanner(stdin)
+	var pending strings.Builder //Rule definitions spanning several lines
+	prompt := func() {
+		if pending.Len() > 0 {
+			fmt.Fprint(stdout, "... ")
+		} else {
+			fmt.Fprint(stdout, "> ")
+		}
+	}
+	for prompt(); in.Scan(); prompt() {
+		line := strings.TrimSpace(in.Text())
+		if pending.Len() == 0 && strings.HasPrefix(line, ":") {
+			if quit := s.command(line); quit {
+				return nil
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if pending.Len() > 0 {
+			pending.WriteString(" ")
+		}
+		pending.WriteString(line)
+		defs, rest, err := splitRules(pending.String())
+		if err != nil {
+			fmt.Fprintf(stdout, "error: %v\n", err)
+			pending.Reset()
+			continue
+		}
+		pending.Reset()
+		pending.WriteString(strings.TrimSpace(rest))
+		if len(defs) > 0 {
+			s.redefine(defs)
+		}
+	}
+	fmt.Fprintln(stdout)
+	return nil
+}
+
+// command runs a ':' command, reporting whether the REPL should end
+func (s *replSession) command(line string) bool {
+	fields := strings.Fields(line)
+	arg := func(i int) string {
+		if i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+	switch fields[0] {
+	case ":quit", ":q", ":exit":
+		return true
+	case ":help", ":h":
+		fmt.Fprintln(s.out, replHelp)
+	case ":gen", ":g":
+		s.generate(false)
+	case ":next", ":n":
+		s.seed = rand.Uint64()
+		fmt.Fprintf(s.out, "seed %d\n", s.seed)
+		s.generate(false)
+	case ":seed":
+		if arg(1) != "" {
+			n, err := strconv.ParseUint(arg(1), 0, 64)
+			if err != nil {
+				fmt.Fprintf(s.out, "error: invalid seed '%s'\n", arg(1))
+				break
+			}
+			s.seed = n
+			s.generate(true)
+			break
+		}
+		fmt.Fprintf(s.out, "seed %d\n", s.seed)
+	case ":tokens":
+		if arg(1) != "" {
+			n, err := strconv.Atoi(arg(1))
+			if err != nil || n <= 0 {
+				fmt.Fprintf(s.out, "error: invalid token count '%s'\n", arg(1))
+				break
+			}
+			s.tokens = n
+			s.generate(true)
+			break
+		}
+		fmt.Fprintf(s.out, "tokens %d\n", s.tokens)
+	case ":start":
+		if arg(1) != "" {
+			if s.find(arg(1)) < 0 {
+				fmt.Fprintf(s.out, "error: rule '%s' not found\n", arg(1))
+				break
+			}
+			s.start = arg(1)
+			s.generate(true)
+			break
+		}
+		fmt.Fprintf(s.out, "start %s\n", s.start)
+	case ":trace", ":t":
+		depth := -1
+		if arg(1) != "" {
+			n, err := strconv.Atoi(arg(1))
+			if err != nil || n < 0 {
+				fmt.Fprintf(s.out, "error: invalid depth '%s'\n", arg(1))
+				break
+			}
+			depth = n
+		}
+		s.trace(depth)
+	case ":weights", ":w":
+		s.weights(arg(1))
+	case ":graph":
+		s.graph(arg(1), arg(2) == "dot")
+	case ":rules":
+		for _, rule := range s.rules {
+			fmt.Fprintln(s.out, rule.name)
+		}
+	case ":show":
+		if i := s.find(arg(1)); i >= 0 {
+			fmt.Fprintf(s.out, "%s: %s;\n", s.rules[i].name, s.rules[i].body)
+		} else {
+			fmt.Fprintf(s.out, "error: rule '%s' not found\n", arg(1))
+		}
+	case ":undo":
+		if len(s.history) == 0 {
+			fmt.Fprintln(s.out, "nothing to undo")
+			break
+		}
+		s.rules = s.history[len(s.history)-1]
+		s.history = s.history[:len(s.history)-1]
+		if s.build() {
+			s.generate(true)
+		}
+	case ":reload":
+		if err := s.reload(); err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+			break
+		}
+		s.generate(true)
+	case ":write":
+		file := arg(1)
+		if file == "" {
+			file = s.file
+		}
+		if err := os.WriteFile(file, []byte(s.source()), 0644); err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+			break
+		}
+		fmt.Fprintf(s.out, "wrote %s\n", file)
+	default:
+		fmt.Fprintf(s.out, "unknown command '%s', type :help for the list\n", fields[0])
+	}
+	return false
+}
+
+// reload reads the grammar file again, dropping every change made in the REPL
+func (s *replSession) reload() error {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return ioError(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			lines = append(lines, line)
+		}
+	}
+	rules, rest, err := splitRules(strings.Join(lines, "\n"))
+	if err == nil && strings.TrimSpace(rest) != "" {
+		err = fmt.Errorf("missing ';' after '%s'", strings.TrimSpace(rest))
+	}
+	if err != nil {
+		return problemError(fmt.Errorf("%s: %w", s.file, err))
+	}
+	s.rules, s.history = rules, nil
+	if !s.build() {
+		return problemError(fmt.Errorf("%s: grammar has errors", s.file))
+	}
+	return nil
+}
+
+// source renders the rules back to grammar text
+func (s *replSession) source() string {
+	var b strings.Builder
+	for _, rule := range s.rules {
+		fmt.Fprintf(&b, "%s: %s;\n", rule.name, rule.body)
+	}
+	return b.String()
+}
+
+func (s *replSession) find(rule string) int {
+	return slices.IndexFunc(s.rules, func(r ruleSource) bool { return r.name == rule })
+}
+
+// build parses the rules, printing the errors and warnings found. Reports whether the grammar is usable.
+func (s *replSession) build() bool {
+	r := resrap.NewResrap()
+	r.ParseGrammar(s.name, s.source())
+	ok := true
+	for _, d := range r.Lint(s.name, s.start) {
+		if d.Severity == resrap.SeverityError {
+			ok = false
+		}
+		fmt.Fprintln(s.out, d)
+	}
+	if ok {
+		s.r = r
+	}
+	return ok
+}
+
+// redefine replaces or adds rules, keeping the previous grammar when the new one has errors
+func (s *replSession) redefine(defs []ruleSource) {
+	previous := slices.Clone(s.rules)
+	for _, def := range defs {
+		if i := s.find(def.name); i >= 0 {
+			s.rules[i] = def
+			fmt.Fprintf(s.out, "redefined %s\n", def.name)
+		} else {
+			s.rules = append(s.rules, def)
+			fmt.Fprintf(s.out, "defined %s\n", def.name)
+		}
+	}
+	if !s.build() {
+		s.rules = previous
+		fmt.Fprintln(s.out, "kept the previous definition")
+		return
+	}
+	s.history = append(s.history, previous)
+	s.generate(true)
+}
+
+// generate prints a sample with the current settings, as a diff against the previous one when asked to
+func (s *replSession) generate(diff bool) {
+	sample := s.r.GenerateWithSeeded(s.name, s.start, s.seed, s.tokens)
+	if diff && s.sample != "" {
+		if sample == s.sample {
+			fmt.Fprintln(s.out, "(sample unchanged)")
+		} else {
+			writeDiff(s.out, s.sample, sample)
+		}
+	} else {
+		fmt.Fprintln(s.out, sample)
+	}
+	s.sample = sample
+}
+
+// trace prints the rules expanded for the current sample as a tree, down to depth when it isn't negative
+func (s *replSession) trace(depth int) {
+	code, expansions := s.r.GenerateTraced(s.name, s.start, s.seed, s.tokens)
+	for _, e := range expansions {
+		if depth >= 0 && e.Depth > depth {
+			continue
+		}
+		text := code[e.Start:e.End]
+		if len(text) > 40 {
+			text = text[:37] + "..."
+		}
+		cut := ""
+		if !e.Complete {
+			cut = "  (cut by the token limit)"
+		}
+		fmt.Fprintf(s.out, "%s%s %s%s\n", strings.Repeat("  ", e.Depth), e.Rule, strconv.Quote(text), cut)
+	}
+}
+
+// weights prints every point of a rule where a walk chooses between several next steps
+func (s *replSession) weights(rule string) {
+	graph, err := s.r.ExportGraph(s.name, rule)
+	if err != nil || rule == "" {
+		fmt.Fprintf(s.out, "error: rule '%s' not found\n", rule)
+		return
+	}
+	nodes := make(map[uint32]resrap.GraphNode, len(graph.Nodes))
+	after := make(map[uint32]string) //Jump node -> element it follows
+	for _, n := range graph.Nodes {
+		nodes[n.ID] = n
+	}
+	for _, n := range graph.Nodes {
+		if n.Type == "literal" || n.Type == "regex" || n.Type == "call" {
+			for _, e := range n.Next {
+				after[e.To] = describeNode(nodes, n.ID, 0)
+			}
+		}
+	}
+	choices := 0
+	for _, n := range graph.Nodes {
+		if len(n.Next) < 2 {
+			continue
+		}
+		choices++
+		switch {
+		case n.Type == "rule":
+			fmt.Fprintf(s.out, "at the start of %s:\n", rule)
+		case after[n.ID] != "":
+			fmt.Fprintf(s.out, "after %s:\n", after[n.ID])
+		default:
+			fmt.Fprintf(s.out, "at n%d:\n", n.ID)
+		}
+		for _, e := range n.Next {
+			fmt.Fprintf(s.out, "  %5.1f%%  %s\n", e.Probability*100, describeNode(nodes, e.To, 0))
+		}
+	}
+	if choices == 0 {
+		fmt.Fprintf(s.out, "%s has no choices\n", rule)
+	}
+}
+
+// describeNode names what a walk does at a node, following single edges through jump nodes
+func describeNode(nodes map[uint32]resrap.GraphNode, id uint32, depth int) string {
+	n, ok := nodes[id]
+	if !ok {
+		return fmt.Sprintf("n%d", id)
+	}
+	switch n.Type {
+	case "literal":
+		return "'" + n.Text + "'"
+	case "regex":
+		return "[" + n.Text + "]"
+	case "call":
+		return n.Text
+	case "rule":
+		return "back to the start of " + n.Text
+	case "end":
+		return "end"
+	}
+	if len(n.Next) == 1 && depth < 8 {
+		return describeNode(nodes, n.Next[0].To, depth+1)
+	}
+	return fmt.Sprintf("choice at n%d", id)
+}
+
+// graph prints the syntax graph of a rule, one node per line with its edges. Nodes are named by
+// the rule they start or by their position in the order a walk reaches them, not by their ids.
+func (s *replSession) graph(rule string, dot bool) {
+	if rule == "" {
+		fmt.Fprintln(s.out, "error: usage :graph rule [dot]")
+		return
+	}
+	if dot {
+		if err := s.r.ExportDOT(s.out, s.name, rule); err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+		return
+	}
+	graph, err := s.r.ExportGraph(s.name, rule)
+	if err != nil {
+		fmt.Fprintf(s.out, "error: %v\n", err)
+		return
+	}
+	nodes := make(map[uint32]resrap.GraphNode, len(graph.Nodes))
+	var queue []uint32
+	for _, n := range graph.Nodes {
+		nodes[n.ID] = n
+		if n.Type == "rule" {
+			queue = append(queue, n.ID)
+		}
+	}
+	names := make(map[uint32]string, len(graph.Nodes))
+	var listed []uint32
+	visit := func() {
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			n, ok := nodes[id]
+			if _, seen := names[id]; seen || !ok {
+				continue
+			}
+			if n.Type == "rule" {
+				names[id] = n.Text
+			} else {
+				names[id] = "#" + strconv.Itoa(len(listed))
+			}
+			listed = append(listed, id)
+			for _, e := range n.Next {
+				queue = append(queue, e.To)
+			}
+		}
+	}
+	visit()
+	for _, n := range graph.Nodes { //Nodes no walk from the rule reaches come last
+		queue = append(queue, n.ID)
+		visit()
+	}
+	label := func(id uint32) string {
+		n, ok := nodes[id]
+		if !ok {
+			return "?"
+		}
+		if n.Type == "rule" {
+			return n.Text
+		}
+		if n.Text != "" {
+			return names[id] + " " + n.Type + " " + strconv.Quote(n.Text)
+		}
+		return names[id] + " " + n.Type
+	}
+	for _, id := range listed {
+		fmt.Fprintln(s.out, label(id))
+		for _, e := range nodes[id].Next {
+			fmt.Fprintf(s.out, "  -> %s %.2f\n", label(e.To), e.Probability)
+		}
+	}
+}
+
+// splitRules splits grammar text into rule definitions, returning the text after the last complete one.
+// ';' and ':' inside literals, regexes and probabilities don't count, '//' comments are dropped.
+func splitRules(text string) ([]ruleSource, string, error) {
+	var rules []ruleSource
+	begin := 0
+	var closing byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if closing != 0 {
+			if c == '\\' && closing == '\'' {
+				i++ //Escaped character of a literal
+			} else if c == closing {
+				closing = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'':
+			closing = '\''
+		case '[':
+			closing = ']'
+		case '<':
+			closing = '>'
+		case '/':
+			if strings.HasPrefix(text[i:], "//") {
+				end := strings.IndexByte(text[i:], '\n')
+				if end < 0 {
+					end = len(text) - i
+				}
+				text = text[:i] + text[i+end:]
+				i--
+			}
+		case ';':
+			def := strings.TrimSpace(text[begin:i])
+			name, body, ok := strings.Cut(def, ":")
+			name = strings.TrimSpace(name)
+			if !ok || !isRuleName(name) {
+				return rules, "", fmt.Errorf("expected 'name: body;', got '%s;'", def)
+			}
+			rules = append(rules, ruleSource{name: name, body: strings.TrimSpace(body)})
+			begin = i + 1
+		}
+	}
+	return rules, text[begin:], nil
+}
+
+// writeDiff prints a line diff between two samples, '-' for removed lines and '+' for added ones
+func writeDiff(w io.Writer, before, after string) {
+	a, b := strings.Split(before, "\n"), strings.Split(after, "\n")
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(w, "  %s\n", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(w, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(w, "+ %s\n", b[j])
+			j++
+		}
+	}
+}
+
+func isRuleName(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9')
+	}) == -1
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// replTranscript runs a REPL session on wordsGrammar reading script, and returns what it printed
+// and the directory of the grammar
+func replTranscript(t *testing.T, script string, args ...string) (string, string) {
+	t.Helper()
+	dir := writeFiles(t, map[string]string{"words.g4": wordsGrammar})
+	var stdout, stderr bytes.Buffer
+	args = append(args, filepath.Join(dir, "words.g4"))
+	if err := runRepl(args, strings.NewReader(script), &stdout, &stderr); err != nil {
+		t.Fatalf("repl: %v\n%s", err, stderr.String())
+	}
+	return stdout.String(), dir
+}
+
+func TestReplSession(t *testing.T) {
+	script := `:trace
+word: 'c'
+  | 'd';
+:show word
+:undo
+:undo
+word: missing;
+:trace 0
+:start nope
+:seed x
+:bogus
+:quit
+`
+	got, _ := replTranscript(t, script, "-seed", "3", "-tokens", "5")
+	want := `grammar 'words', 2 rules, seed 3. Type :help for commands.
+a a a
+> program "a a a"  (cut by the token limit)
+  word "a"
+  word "a"
+  word "a"  (cut by the token limit)
+> ... redefined word
+- a a a
++ c c c
+> word: 'c' | 'd';
+> - c c c
++ a a a
+> nothing to undo
+> redefined word
+error: ERROR Validating >>> Definition of 'missing' not found
+kept the previous definition
+> program "a a a"  (cut by the token limit)
+> error: rule 'nope' not found
+> error: invalid seed 'x'
+> unknown command ':bogus', type :help for the list
+> `
+	if got != want {
+		t.Errorf("transcript:\n%s\nexpected:\n%s", got, want)
+	}
+}
+
+func TestReplGraph(t *testing.T) {
+	got, _ := replTranscript(t, ":graph word\n:graph\n", "-seed", "1")
+	want := `> word
+  -> #1 literal "a" 0.50
+  -> #2 literal "b" 0.50
+#1 literal "a"
+  -> #3 jump 1.00
+#2 literal "b"
+  -> #4 jump 1.00
+#3 jump
+  -> #5 end 1.00
+#4 jump
+  -> #5 end 1.00
+#5 end
+> error: usage :graph rule [dot]
+`
+	if !strings.Contains(got, want) {
+		t.Errorf(":graph word:\n%s\nexpected:\n%s", got, want)
+	}
+}
+
+func TestReplWrite(t *testing.T) {
+	got, dir := replTranscript(t, "extra: 'x';\n:undo\nword: 'c';\n:write\n", "-seed", "1")
+	if !strings.Contains(got, "defined extra\n") || !strings.Contains(got, "wrote ") {
+		t.Errorf("unexpected transcript:\n%s", got)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "words.g4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "program: word (' ' word)*;\nword: 'c';\n"; string(data) != want {
+		t.Errorf("wrote %q, expected %q", data, want)
+	}
+}
+
+func TestSplitRules(t *testing.T) {
+	rules, rest, err := splitRules(`a: ';' b; // c: d;
+b: '\'' [;] <0.5>; c:`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ruleSource{{"a", `';' b`}, {"b", `'\'' [;] <0.5>`}}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules %+v, expected %+v", rules, want)
+	}
+	if strings.TrimSpace(rest) != "c:" {
+		t.Errorf("rest %q", rest)
+	}
+	if _, _, err := splitRules("1 2 : x;"); err == nil {
+		t.Error("invalid rule name accepted")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/osdc/resrap"
+)
+
+func runServe(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("serve", "[grammar...]", stderr)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "size of the worker pool")
+	queue := fs.Int("queue", 1024, "jobs waiting for a worker before requests block")
+	timeout := fs.Duration("timeout", 10*time.Second, "time a request may take on the worker pool")
+	maxBody := fs.Int64("max-body", 1<<20, "largest request body, in bytes")
+	maxTokens := fs.Int("max-tokens", 100000, "largest number of tokens per output")
+	maxBatch := fs.Int("max-batch", 1000, "largest batch")
+	readOnly := fs.Bool("read-only", false, "reject grammar uploads")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *workers <= 0 || *queue <= 0 {
+		return usageError("-workers and -queue must be positive")
+	}
+
+	pool := resrap.NewResrapMT(*workers, *queue)
+	for _, arg := range fs.Args() {
+		if _, err := loadGrammar(pool, arg); err != nil {
+			return err
+		}
+	}
+	pool.StartResrap()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return ioError(err)
+	}
+	srv := &http.Server{
+		Handler: resrap.NewServer(pool, resrap.ServerConfig{
+			MaxBodyBytes: *maxBody,
+			MaxTokens:    *maxTokens,
+			MaxBatch:     *maxBatch,
+			Timeout:      *timeout,
+			ReadOnly:     *readOnly,
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	drained := make(chan struct{}) //Closed once the requests in flight are done
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	fmt.Fprintf(stderr, "listening on http://%s\n", ln.Addr())
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		pool.ShutDownResrap()
+		return ioError(err)
+	}
+	// Serve returns as soon as Shutdown starts, the pool is only shut down once the handlers are done with it
+	<-drained
+	pool.ShutDownResrap()
+	return nil
+}
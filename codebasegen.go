@@ -1,50 +1,115 @@
 package resrap
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"hash/fnv"
+	"log/slog"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 )
 
-type file_type int
+// NodeType tells directories and file entries apart
+type NodeType int
 
 const (
-	DIR file_type = iota
+	DIR NodeType = iota
 	FILE
 )
 
-// node describes either a directory or file entry.
-type node struct {
+// Node describes either a directory or file entry of a codebase specification.
+type Node struct {
 	Name       string
-	Type       file_type
+	Type       NodeType
 	Pattern    string // E.g., "code_*.c"
 	Count      int    // Number of files (e.g., 10)
-	TokenCount int    // Tokens per file (e.g., 20)
+	TokenCount int    // Tokens per file (e.g., 20), the lower bound when MaxTokens is set
+	MaxTokens  int    // Upper bound of a token range (e.g., 800 in 200..800), 0 for a fixed TokenCount
 	FileType   string // Language/type identifier (e.g., "C", "sql")
-	Children   []*node
+	StartRule  string // Rule generation starts from, "program" when empty
+	Seed       uint64 // Base seed of the entry, used when HasSeed is set
+	HasSeed    bool
+	Exports    []string // "rule:pool" links, the output of rule is added to pool for later entries
+	Imports    []string // "rule:pool" links, rule is replaced by a symbol of pool from earlier entries
+	NamePool   string   // Pool the generated file names are added to
+	Line       int      // Line of the entry in the DSL, 0 when not parsed from one
+	Children   []*Node
+}
+
+// location names the entry in error messages, by DSL line when it came from one
+func (n *Node) location() string {
+	if n.Line > 0 {
+		return fmt.Sprintf("line %d", n.Line)
+	}
+	if n.Type == DIR {
+		return fmt.Sprintf("directory '%s'", n.Name)
+	}
+	return fmt.Sprintf("entry '%s[%s]'", n.FileType, n.Pattern)
+}
+
+// defaultStartRule is used by file entries that don't set start=
+const defaultStartRule = "program"
+
+// stripDSLComment drops a '#' comment from a DSL line, unless the '#' sits inside a [...] file spec
+func stripDSLComment(line string) string {
+	depth := 0
+	for i, r := range line {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '#':
+			if depth <= 0 {
+				return line[:i]
+			}
+		}
+	}
+	return line
 }
 
 // ParseDSL parses the directory DSL into a tree.
-func ParseDSL(input string) (*node, error) {
+// Lines are indented with either spaces or tabs, and '#' starts a comment.
+func ParseDSL(input string) (*Node, error) {
 	lines := strings.Split(input, "\n")
-	var root = &node{Name: "", Type: DIR}
-	stack := []*node{root}
+	var root = &Node{Name: "", Type: DIR}
+	stack := []*Node{root}
 	indentStack := []int{-1}
 
+	indentChar := byte(0) // ' ' or '\t', whichever the first indented line uses
+
 	for lineNum, line := range lines {
+		line = stripDSLComment(strings.TrimRight(line, "\r"))
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
-		indent := len(line) - len(strings.TrimLeft(line, " "))
 		name := strings.TrimSpace(line)
+		lead := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		indent := len(lead)
+		if indent > 0 {
+			if indentChar == 0 {
+				indentChar = lead[0]
+			}
+			if strings.Trim(lead, string(indentChar)) != "" {
+				return nil, fmt.Errorf("line %d: inconsistent indentation, tabs and spaces are mixed", lineNum+1)
+			}
+		}
 
-		var n node
+		n := Node{Line: lineNum + 1}
 
 		// Check if it's a file specification: name[count x tokens pattern]
-		if i := strings.Index(name, "["); i != -1 && strings.HasSuffix(name, "]") {
+		if i := strings.Index(name, "["); i != -1 {
+			if !strings.HasSuffix(name, "]") {
+				return nil, fmt.Errorf("line %d: unterminated file spec '%s', expected 'type[countXtokens pattern key=value...]'", lineNum+1, name)
+			}
+			if i == 0 || strings.IndexFunc(name[:i], func(r rune) bool { return !isIdentPart(r) }) != -1 {
+				return nil, fmt.Errorf("line %d: invalid grammar name '%s' in file spec", lineNum+1, name[:i])
+			}
 			n.Type = FILE
 			n.FileType = name[:i] // e.g., "C", "sql"
 
@@ -52,13 +117,13 @@ func ParseDSL(input string) (*node, error) {
 			parts := strings.Fields(rest)
 
 			if len(parts) < 2 {
-				return nil, fmt.Errorf("line %d: invalid file spec format, expected 'type[countXtokens pattern]'", lineNum+1)
+				return nil, fmt.Errorf("line %d: invalid file spec format, expected 'type[countXtokens pattern key=value...]'", lineNum+1)
 			}
 
-			// Parse "10x20" format
+			// Parse "10x20" or "10x200..800" format
 			xparts := strings.Split(parts[0], "x")
 			if len(xparts) != 2 {
-				return nil, fmt.Errorf("line %d: invalid count format '%s', expected 'NUMxNUM'", lineNum+1, parts[0])
+				return nil, fmt.Errorf("line %d: invalid count format '%s', expected 'NUMxNUM' or 'NUMxNUM..NUM'", lineNum+1, parts[0])
 			}
 
 			var err error
@@ -67,9 +132,19 @@ func ParseDSL(input string) (*node, error) {
 				return nil, fmt.Errorf("line %d: invalid file count '%s': %w", lineNum+1, xparts[0], err)
 			}
 
-			n.TokenCount, err = strconv.Atoi(xparts[1])
+			minTokens, maxTokens, isRange := strings.Cut(xparts[1], "..")
+			n.TokenCount, err = strconv.Atoi(minTokens)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: invalid token count '%s': %w", lineNum+1, xparts[1], err)
+				return nil, fmt.Errorf("line %d: invalid token count '%s': %w", lineNum+1, minTokens, err)
+			}
+			if isRange {
+				n.MaxTokens, err = strconv.Atoi(maxTokens)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid token count '%s': %w", lineNum+1, maxTokens, err)
+				}
+				if n.MaxTokens < n.TokenCount {
+					return nil, fmt.Errorf("line %d: invalid token range '%s', upper bound is below lower bound", lineNum+1, xparts[1])
+				}
 			}
 
 			n.Pattern = parts[1] // e.g., "code_*.c"
@@ -77,10 +152,47 @@ func ParseDSL(input string) (*node, error) {
 			if n.Count <= 0 || n.TokenCount <= 0 {
 				return nil, fmt.Errorf("line %d: count and token count must be positive numbers", lineNum+1)
 			}
+
+			// Optional key=value settings, e.g. start=translation_unit seed=42
+			for _, opt := range parts[2:] {
+				key, value, ok := strings.Cut(opt, "=")
+				if !ok || value == "" {
+					return nil, fmt.Errorf("line %d: invalid option '%s', expected 'key=value'", lineNum+1, opt)
+				}
+				switch key {
+				case "start":
+					n.StartRule = value
+				case "seed":
+					n.Seed, err = strconv.ParseUint(value, 10, 64)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: invalid seed '%s': %w", lineNum+1, value, err)
+					}
+					n.HasSeed = true
+				case "export", "import":
+					if _, _, err := parseSymbolLink(value); err != nil {
+						return nil, fmt.Errorf("line %d: %w", lineNum+1, err)
+					}
+					if key == "export" {
+						n.Exports = append(n.Exports, value)
+					} else {
+						n.Imports = append(n.Imports, value)
+					}
+				case "name":
+					if !isIdentifier(value) {
+						return nil, fmt.Errorf("line %d: invalid pool name '%s'", lineNum+1, value)
+					}
+					n.NamePool = value
+				default:
+					return nil, fmt.Errorf("line %d: unknown option '%s'", lineNum+1, key)
+				}
+			}
 		} else {
-			// It's a directory
+			// It's a directory, the trailing '/' is optional
 			n.Type = DIR
-			n.Name = name
+			n.Name = strings.TrimSuffix(name, "/")
+			if n.Name == "" || n.Name == "." || n.Name == ".." || strings.ContainsAny(n.Name, "/\\") {
+				return nil, fmt.Errorf("line %d: invalid directory name '%s'", lineNum+1, name)
+			}
 		}
 
 		// Pop from stack until correct indentation level
@@ -107,57 +219,480 @@ func ParseDSL(input string) (*node, error) {
 	return root, nil
 }
 
-// GenerateStructure creates the directory structure and files
-func (n *node) GenerateStructure(r *Resrap, root string) error {
-	return n.generate_node(r, root)
+// CodebaseSummary describes everything a codebase generation produced
+type CodebaseSummary struct {
+	Dirs     int      // Directories created
+	Files    int      // Files written
+	Bytes    int64    // Total size of the files written
+	Tokens   int      // Total tokens generated across all files
+	Manifest Manifest // Every directory and file of the codebase, enough to regenerate it
+	Head     string   // Hash of the last commit with WithGitHistory, where Manifest describes the first one
 }
 
-// Recursive function to create directories and generate files
-func (n *node) generate_node(r *Resrap, root string) error {
-	loc := root
-	if n.Name != "" {
-		loc = filepath.Join(root, n.Name)
+// ProgressFunc is called after every file written during codebase generation
+type ProgressFunc func(path string, tokens, bytes int)
+
+// CodebaseOption tunes codebase generation
+type CodebaseOption func(*codebaseConfig)
+
+type codebaseConfig struct {
+	progress ProgressFunc
+	logger   *slog.Logger
+	seed     uint64
+	sink     CodebaseSink
+	workers  int
+	pool     *ResrapMT
+	dryRun   bool
+	git      *GitHistory
+}
+
+// WithProgress calls fn after every file written
+func WithProgress(fn ProgressFunc) CodebaseOption {
+	return func(c *codebaseConfig) {
+		c.progress = fn
 	}
+}
 
-	if n.Type == DIR {
-		// Create directory and parents if needed
-		if err := os.MkdirAll(loc, 0755); err != nil {
-			return fmt.Errorf("failed to create directory '%s': %w", loc, err)
+// WithCodebaseLogger logs every directory and file created on logger at info level
+func WithCodebaseLogger(logger *slog.Logger) CodebaseOption {
+	return func(c *codebaseConfig) {
+		c.logger = logger
+	}
+}
+
+// WithCodebaseSeed mixes seed into every file entry that doesn't set its own seed=,
+// generating a different but still reproducible codebase from the same DSL
+func WithCodebaseSeed(seed uint64) CodebaseOption {
+	return func(c *codebaseConfig) {
+		c.seed = seed
+	}
+}
+
+// WithSink sends the generated codebase to sink instead of the disk.
+// The root passed to generation becomes a slash separated directory inside the sink, use "" for none.
+func WithSink(sink CodebaseSink) CodebaseOption {
+	return func(c *codebaseConfig) {
+		c.sink = sink
+	}
+}
+
+// WithWorkers generates up to n files at the same time, defaults to GOMAXPROCS
+func WithWorkers(n int) CodebaseOption {
+	return func(c *codebaseConfig) {
+		c.workers = n
+	}
+}
+
+// WithPool generates the files on a ResrapMT worker pool instead of local goroutines,
+// or without it when the pool is not started or shut down. File names are drawn from the
+// pool's SourceFactory too.
+// The pool must have the grammars used by the DSL loaded, under the same names.
+func WithPool(pool *ResrapMT) CodebaseOption {
+	return func(c *codebaseConfig) {
+		c.pool = pool
+	}
+}
+
+// WithDryRun plans the codebase without writing anything, the summary's Manifest lists what would be generated.
+// Dirs and Files count what would be created, Bytes and Tokens stay 0.
+func WithDryRun() CodebaseOption {
+	return func(c *codebaseConfig) {
+		c.dryRun = true
+	}
+}
+
+// codebaseGen carries the state of a single codebase generation through the tree
+type codebaseGen struct {
+	r       *Resrap
+	cfg     codebaseConfig
+	sink    CodebaseSink
+	prefix  string //Directory inside the sink everything is generated in
+	dirs    []string
+	files   []ManifestEntry
+	names   map[string]map[string]bool //File names taken in each directory
+	stage   int                        //Stage of the next planned file entry
+	exposed map[string]bool            //Pools exported by the entries planned so far
+	namerx  regexer                    //Samples {[class]} file name generators
+	pools   *symbolPools               //Symbols of every generated file, for the files of a git history
+	summary CodebaseSummary
+	out     CodebaseSink //Real sink while files are captured in memory to build a git history
+	capture *MemorySink
+}
+
+func newCodebaseGen(r *Resrap, root string, opts []CodebaseOption) *codebaseGen {
+	g := &codebaseGen{
+		r:       r,
+		cfg:     codebaseConfig{workers: runtime.GOMAXPROCS(0)},
+		names:   make(map[string]map[string]bool),
+		exposed: make(map[string]bool),
+		namerx:  newRegexer(),
+	}
+	for _, opt := range opts {
+		opt(&g.cfg)
+	}
+	if g.cfg.sink == nil {
+		g.sink = NewDiskSink(root)
+	} else {
+		g.sink = g.cfg.sink
+		g.prefix = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(root)), "/")
+	}
+	if g.cfg.git != nil && !g.cfg.dryRun {
+		g.out, g.capture = g.sink, NewMemorySink()
+		g.sink = g.capture
+	}
+	return g
+}
+
+// sinkPath maps a path relative to the codebase root to its path in the sink
+func (g *codebaseGen) sinkPath(rel string) string {
+	p := path.Join(g.prefix, rel)
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// graphs are the grammars files are generated from
+func (g *codebaseGen) graphs() map[string]lang {
+	if g.cfg.pool != nil {
+		return g.cfg.pool.graphs()
+	}
+	return g.r.languageGraph
+}
+
+// source is the SourceFactory files are generated with, the one file names are drawn from too
+func (g *codebaseGen) source() SourceFactory {
+	if g.cfg.pool != nil {
+		return g.cfg.pool.source
+	}
+	return g.r.source
+}
+
+// validate checks every file entry of the tree against the loaded grammars:
+// the grammar exists and parsed cleanly, and the start rule and file name rules are defined in it.
+// Every problem is reported, prefixed with the DSL line it comes from.
+func (n *Node) validate(graphs map[string]lang) error {
+	var errs []error
+	report := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{n.location()}, args...)...))
+	}
+	if n.Type == FILE {
+		l, ok := graphs[n.FileType]
+		switch {
+		case !ok || l.graph == nil:
+			report("grammar '%s' is not loaded", n.FileType)
+		case l.err != nil:
+			report("grammar '%s' failed to parse", n.FileType)
+		default:
+			if _, ok := l.graph.namemap[n.startRule()]; !ok {
+				report("start rule '%s' not found in grammar '%s'", n.startRule(), n.FileType)
+			}
+			for _, link := range append(append([]string(nil), n.Exports...), n.Imports...) {
+				if rule, _, err := parseSymbolLink(link); err != nil {
+					report("%v", err)
+				} else if _, ok := l.graph.namemap[rule]; !ok {
+					report("symbol rule '%s' not found in grammar '%s'", rule, n.FileType)
+				}
+			}
+		}
+		pattern, err := parseNamePattern(n.Pattern)
+		if err != nil {
+			report("%v", err)
+		} else if ok && l.graph != nil {
+			for _, rule := range pattern.rules() {
+				if _, found := l.graph.namemap[rule]; !found {
+					report("file name rule '%s' not found in grammar '%s'", rule, n.FileType)
+				}
+			}
 		}
+	}
+	for _, child := range n.Children {
+		if err := child.validate(graphs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GenerateStructure creates the directory structure and files under root
+func (n *Node) GenerateStructure(r *Resrap, root string, opts ...CodebaseOption) (CodebaseSummary, error) {
+	g := newCodebaseGen(r, root, opts)
+	if err := n.validate(g.graphs()); err != nil {
+		return g.summary, err
+	}
+	if err := n.plan_node(g, "", 0); err != nil {
+		return g.summary, err
+	}
+	if err := g.generate(); err != nil {
+		return g.summary, err
+	}
+	if g.capture != nil {
+		return g.summary, g.writeHistory()
+	}
+	return g.summary, nil
+}
+
+// entrySeed is the base seed of a file entry: its own seed= when set, otherwise derived
+// from its place in the tree so the same DSL always generates the same files.
+// dir is the slash separated directory of the entry relative to the codebase root and index
+// its place in that directory, so identical entries of a directory get different seeds.
+func (n *Node) entrySeed(dir string, index int, codebaseSeed uint64) uint64 {
+	if n.HasSeed {
+		return n.Seed
+	}
+	h := fnv.New64a()
+	h.Write([]byte(dir + "/" + strconv.Itoa(index) + ":" + n.FileType + "[" + n.Pattern + "]"))
+	return h.Sum64() ^ codebaseSeed
+}
+
+// fileTokens picks the token count of a file from its seed, uniformly within the entry's range
+func (n *Node) fileTokens(seed uint64) int {
+	if n.MaxTokens == 0 {
+		return n.TokenCount
+	}
+	return n.TokenCount + int(BatchSeed(seed, 0)%uint64(n.MaxTokens-n.TokenCount+1))
+}
+
+// startRule is the rule files of this entry are generated from
+func (n *Node) startRule() string {
+	if n.StartRule == "" {
+		return defaultStartRule
+	}
+	return n.StartRule
+}
 
-		// Recurse for children
-		for _, child := range n.Children {
-			if err := child.generate_node(r, loc); err != nil {
+// Recursive function to lay out the directories and files of the tree, in DSL order
+// rel is the slash separated path of the parent directory relative to the codebase root,
+// index the place of n among the children of that directory
+func (n *Node) plan_node(g *codebaseGen, rel string, index int) error {
+	if n.Name != "" {
+		rel = path.Join(rel, n.Name)
+	}
+	loc := rel
+	if loc == "" {
+		loc = "."
+	}
+
+	if n.Type == DIR {
+		g.dirs = append(g.dirs, loc)
+		for i, child := range n.Children {
+			if err := child.plan_node(g, rel, i); err != nil {
 				return err
 			}
 		}
-	} else {
-		// FILE type - generate multiple files based on count
-		if n.Count <= 0 {
-			return fmt.Errorf("invalid file count %d for pattern '%s'", n.Count, n.Pattern)
+		return nil
+	}
+
+	// FILE type - plan multiple files based on count
+	if n.Count <= 0 {
+		return fmt.Errorf("%s: invalid file count %d for pattern '%s'", n.location(), n.Count, n.Pattern)
+	}
+	if err := checkStart(g.graphs(), n.FileType, n.startRule()); err != nil {
+		return fmt.Errorf("%s: invalid file entry '%s[%s]': %w", n.location(), n.FileType, n.Pattern, err)
+	}
+	pattern, err := parseNamePattern(n.Pattern)
+	if err != nil {
+		return fmt.Errorf("%s: %w", n.location(), err)
+	}
+	graph := g.graphs()[n.FileType].graph
+	for _, rule := range pattern.rules() {
+		if _, ok := graph.namemap[rule]; !ok {
+			return fmt.Errorf("%s: file name rule '%s' not found in grammar '%s'", n.location(), rule, n.FileType)
+		}
+	}
+	for _, link := range n.Imports {
+		if _, pool, _ := parseSymbolLink(link); !g.exposed[pool] {
+			return fmt.Errorf("%s: symbol pool '%s' is not exported by an earlier entry", n.location(), pool)
 		}
+	}
+	taken := g.names[loc]
+	if taken == nil {
+		taken = make(map[string]bool)
+		g.names[loc] = taken
+	}
+	base := n.entrySeed(rel, index, g.cfg.seed)
+	for i := 1; i <= n.Count; i++ {
+		// Replace * with the file number and expand the {...} generators
+		seed := BatchSeed(base, i-1)
+		fileName, err := pattern.uniqueName(i, seed, graph, &g.namerx, g.source(), taken)
+		if err != nil {
+			return fmt.Errorf("%s: file %d of '%s' in '%s': %w", n.location(), i, n.Pattern, loc, err)
+		}
+		g.files = append(g.files, ManifestEntry{
+			Path:      path.Join(rel, fileName),
+			Grammar:   n.FileType,
+			StartRule: n.startRule(),
+			Seed:      seed,
+			Tokens:    n.fileTokens(seed),
+			Stage:     g.stage,
+			Exports:   n.Exports,
+			Imports:   n.Imports,
+			NamePool:  n.NamePool,
+		})
+	}
+	// Later entries see the symbols of this one, files of the same entry don't see each other's
+	g.stage++
+	for _, link := range n.Exports {
+		_, pool, _ := parseSymbolLink(link)
+		g.exposed[pool] = true
+	}
+	if n.NamePool != "" {
+		g.exposed[n.NamePool] = true
+	}
+	return nil
+}
 
-		// Ensure parent directory exists
-		if err := os.MkdirAll(root, 0755); err != nil {
-			return fmt.Errorf("failed to create parent directory '%s': %w", root, err)
+// generatedFile is the outcome of generating a ManifestEntry
+type generatedFile struct {
+	content string
+	tokens  int
+	symbols map[string][]string //Symbols exported by the file, by pool
+	err     error
+}
+
+// generate creates the planned directories, then generates the planned files with
+// up to cfg.workers at a time (or on the pool) and writes them to the sink in plan order.
+// Contents only depend on each file's seed and on the symbols of earlier stages, never on scheduling:
+// a file importing symbols starts once every file of the earlier stages is written.
+func (g *codebaseGen) generate() error {
+	defer func() {
+		g.summary.Manifest = Manifest{Stream: DefaultStreamVersion, Dirs: g.dirs, Files: g.files}
+	}()
+	for _, dir := range g.dirs {
+		loc := g.sinkPath(dir)
+		if !g.cfg.dryRun {
+			if err := g.sink.MkdirAll(loc); err != nil {
+				return fmt.Errorf("failed to create directory '%s': %w", loc, err)
+			}
+		}
+		if dir != "." {
+			g.summary.Dirs++
+			if g.cfg.logger != nil && !g.cfg.dryRun {
+				g.cfg.logger.Info("created directory", slog.String("path", loc))
+			}
 		}
+	}
+	if g.cfg.dryRun {
+		g.summary.Files = len(g.files)
+		return nil
+	}
+
+	workers := max(g.cfg.workers, 1)
+	results := make([]chan generatedFile, len(g.files))
+	for i := range results {
+		results[i] = make(chan generatedFile, 1)
+	}
+	inflight := make(chan struct{}, workers*2) //Bounds generated but not yet written files
+	done := make(chan struct{})
+	defer close(done)
 
-		for i := 1; i <= n.Count; i++ {
-			// Replace * with the file number
-			fileName := strings.ReplaceAll(n.Pattern, "*", strconv.Itoa(i))
-			fullPath := filepath.Join(root, fileName)
+	// Pools as seen by each stage, published when the writer reaches the stage's first file
+	type stageView struct {
+		ready     chan struct{}
+		pools     map[string][]string
+		published bool
+	}
+	g.pools = newSymbolPools()
+	pools := g.pools
+	stages := make(map[int]*stageView)
+	for _, f := range g.files {
+		if stages[f.Stage] == nil {
+			stages[f.Stage] = &stageView{ready: make(chan struct{})}
+		}
+	}
 
-			// Generate file content using the FileType (e.g., "C", "sql") and TokenCount
-			content := r.GenerateRandom(n.FileType, "program", n.TokenCount)
+	go func() {
+		running := make(chan struct{}, workers)
+		for i, f := range g.files {
+			select {
+			case inflight <- struct{}{}:
+			case <-done:
+				return
+			}
+			var visible map[string][]string
+			if len(f.Imports) > 0 {
+				select {
+				case <-stages[f.Stage].ready:
+					visible = stages[f.Stage].pools
+				case <-done:
+					return
+				}
+			}
+			var hooks *walkHooks
+			if l := g.graphs()[f.Grammar]; l.graph != nil {
+				hooks = newWalkHooks(l.graph, f, visible)
+			}
+			if g.cfg.pool != nil {
+				go g.generateOnPool(f, hooks, results[i])
+				continue
+			}
+			running <- struct{}{}
+			go func() {
+				results[i] <- g.generateFile(f, hooks)
+				<-running
+			}()
+		}
+	}()
 
-			// Write to file
-			if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
-				return fmt.Errorf("failed to write file '%s': %w", fullPath, err)
+	for i, f := range g.files {
+		if view := stages[f.Stage]; !view.published {
+			view.pools, view.published = pools.snapshot(), true
+			close(view.ready)
+		}
+		res := <-results[i]
+		<-inflight
+		loc := g.sinkPath(f.Path)
+		if res.err != nil {
+			return fmt.Errorf("failed to generate file '%s': %w", loc, res.err)
+		}
+		if err := g.sink.WriteFile(loc, []byte(res.content)); err != nil {
+			return fmt.Errorf("failed to write file '%s': %w", loc, err)
+		}
+		g.files[i].SHA256 = contentHash(res.content)
+		for _, link := range f.Exports {
+			_, pool, _ := parseSymbolLink(link)
+			for _, symbol := range res.symbols[pool] {
+				pools.add(pool, symbol)
 			}
+		}
+		if f.NamePool != "" {
+			pools.add(f.NamePool, path.Base(f.Path))
+		}
 
-			fmt.Printf("Generated: %s (%d tokens)\n", fullPath, n.TokenCount)
+		g.summary.Files++
+		g.summary.Bytes += int64(len(res.content))
+		g.summary.Tokens += res.tokens
+		if g.cfg.progress != nil {
+			g.cfg.progress(loc, res.tokens, len(res.content))
+		}
+		if g.cfg.logger != nil {
+			g.cfg.logger.Info("generated file", slog.String("path", loc),
+				slog.Int("tokens", res.tokens), slog.Int("bytes", len(res.content)))
 		}
 	}
-
 	return nil
 }
+
+// generateFile generates a planned file on the calling goroutine, sharing symbols through hooks when not nil
+func (g *codebaseGen) generateFile(f ManifestEntry, hooks *walkHooks) generatedFile {
+	prng := newPRNG(g.r.source, f.Seed)
+	job := codeGenReq{name: f.Grammar, startnode: f.StartRule, tokens: f.Tokens, seed: f.Seed, id: g.sinkPath(f.Path), hooks: hooks}
+	content, stats, err := observedWalk(g.r.languageGraph, g.r.metrics, g.r.logger, job, &prng)
+	res := generatedFile{content: content, tokens: stats.tokens, err: err}
+	if hooks != nil {
+		res.symbols = hooks.found
+	}
+	return res
+}
+
+// generateOnPool generates a planned file on the ResrapMT worker pool and delivers it to out.
+// The file is generated on the calling goroutine when the pool isn't running.
+func (g *codebaseGen) generateOnPool(f ManifestEntry, hooks *walkHooks, out chan<- generatedFile) {
+	job := codeGenReq{name: f.Grammar, startnode: f.StartRule, tokens: f.Tokens, seed: f.Seed, id: g.sinkPath(f.Path), hooks: hooks}
+	res, err := g.cfg.pool.generate(context.Background(), job)
+	file := generatedFile{content: res.Code, tokens: res.Tokens, err: err}
+	if hooks != nil {
+		file.symbols = hooks.found
+	}
+	out <- file
+}
@@ -0,0 +1,437 @@
+package resrap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const testDSL = `src/
+  c[6x20..80 *.c seed=3]
+  core/
+    c[4x50 {[a-z]}.c start=function]
+  sql[3x30 q_*.sql]
+`
+
+func codebaseResrap(t *testing.T) *Resrap {
+	t.Helper()
+	r := NewResrap()
+	for name, file := range map[string]string{"c": "example/c.g4", "sql": "example/sql.g4"} {
+		if err := r.ParseGrammarFile(name, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+// generateTree generates testDSL in memory
+func generateTree(t *testing.T, r *Resrap, opts ...CodebaseOption) (fstest.MapFS, CodebaseSummary) {
+	t.Helper()
+	tree, err := ParseDSL(testDSL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewMemorySink()
+	summary, err := tree.GenerateStructure(r, "", append(opts, WithSink(sink))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink.FS(), summary
+}
+
+func sameTree(a, b fstest.MapFS) bool {
+	return maps.EqualFunc(a, b, func(x, y *fstest.MapFile) bool {
+		return x.Mode == y.Mode && string(x.Data) == string(y.Data)
+	})
+}
+
+func TestCodebaseSameSeedSameTree(t *testing.T) {
+	r := codebaseResrap(t)
+	want, summary := generateTree(t, r, WithCodebaseSeed(11))
+	if summary.Files != 13 {
+		t.Fatalf("generated %d files, want 13", summary.Files)
+	}
+	for _, workers := range []int{1, 4, 16} {
+		got, _ := generateTree(t, r, WithCodebaseSeed(11), WithWorkers(workers))
+		if !sameTree(got, want) {
+			t.Errorf("%d workers: tree differs from the first generation", workers)
+		}
+	}
+	pool := NewResrapMT(3, 8)
+	for name, file := range map[string]string{"c": "example/c.g4", "sql": "example/sql.g4"} {
+		if err := pool.ParseGrammarFile(name, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.StartResrap()
+	defer pool.ShutDownResrap()
+	if got, _ := generateTree(t, r, WithCodebaseSeed(11), WithPool(pool)); !sameTree(got, want) {
+		t.Error("worker pool: tree differs from the first generation")
+	}
+
+	other, _ := generateTree(t, r, WithCodebaseSeed(12))
+	if sameTree(other, want) {
+		t.Error("another codebase seed generated the same tree")
+	}
+}
+
+func TestCodebaseWorkersKeepOrder(t *testing.T) {
+	r := codebaseResrap(t)
+	order := func(opts ...CodebaseOption) []string {
+		var paths []string
+		generateTree(t, r, append(opts, WithProgress(func(path string, _, _ int) {
+			paths = append(paths, path)
+		}))...)
+		return paths
+	}
+	want := order(WithWorkers(1))
+	if got := order(WithWorkers(16)); !slices.Equal(got, want) {
+		t.Errorf("16 workers wrote %v, one worker %v", got, want)
+	}
+
+	// A pool that isn't running generates inline, before it starts and after it shuts down
+	pool := NewResrapMT(4, 2)
+	for name, file := range map[string]string{"c": "example/c.g4", "sql": "example/sql.g4"} {
+		if err := pool.ParseGrammarFile(name, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, _ := generateTree(t, r, WithWorkers(1))
+	if got, _ := generateTree(t, r, WithPool(pool)); !sameTree(got, tree) {
+		t.Error("pool not started: tree differs")
+	}
+	pool.StartResrap()
+	if got := order(WithPool(pool)); !slices.Equal(got, want) {
+		t.Errorf("pool wrote %v, one worker %v", got, want)
+	}
+	pool.ShutDownResrap()
+	if got, _ := generateTree(t, r, WithPool(pool)); !sameTree(got, tree) {
+		t.Error("pool shut down: tree differs")
+	}
+}
+
+func TestCodebaseEntrySettings(t *testing.T) {
+	r := codebaseResrap(t)
+	fsys, summary := generateTree(t, r, WithCodebaseSeed(1))
+	seeds := map[uint64]bool{}
+	for _, f := range summary.Manifest.Files {
+		if seeds[f.Seed] {
+			t.Errorf("%s: seed %d used twice", f.Path, f.Seed)
+		}
+		seeds[f.Seed] = true
+		switch f.Grammar {
+		case "c":
+			if f.StartRule == "function" {
+				if f.Tokens != 50 {
+					t.Errorf("%s: %d tokens, want 50", f.Path, f.Tokens)
+				}
+			} else if f.StartRule != "program" || f.Tokens < 20 || f.Tokens > 80 {
+				t.Errorf("%s: start %s with %d tokens, want program with 20 to 80", f.Path, f.StartRule, f.Tokens)
+			}
+		case "sql":
+			if f.Tokens != 30 {
+				t.Errorf("%s: %d tokens, want 30", f.Path, f.Tokens)
+			}
+		}
+		data, err := fs.ReadFile(fsys, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := r.GenerateWithSeeded(f.Grammar, f.StartRule, f.Seed, f.Tokens); string(data) != code {
+			t.Errorf("%s: content differs from GenerateWithSeeded with its manifest entry", f.Path)
+		}
+	}
+	if err := r.VerifyManifest(summary.Manifest, fsys); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCodebaseIdenticalEntries(t *testing.T) {
+	r := codebaseResrap(t)
+	tree, err := ParseDSL("src/\n  c[2x30 {[a-z]}_*.c]\n  c[2x30 {[a-z]}_*.c]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewMemorySink()
+	summary, err := tree.GenerateStructure(r, "", WithSink(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := summary.Manifest.Files
+	if len(files) != 4 {
+		t.Fatalf("planned %d files, want 4", len(files))
+	}
+	fsys := sink.FS()
+	for i := 0; i < 2; i++ {
+		a, b := files[i], files[i+2]
+		if a.Seed == b.Seed {
+			t.Errorf("%s and %s share seed %d", a.Path, b.Path, a.Seed)
+		}
+		if string(fsys[a.Path].Data) == string(fsys[b.Path].Data) {
+			t.Errorf("%s and %s have the same content", a.Path, b.Path)
+		}
+	}
+}
+
+func TestCodebasePoolSource(t *testing.T) {
+	pcg := func(seed uint64) Source { return rand.NewPCG(seed, 7) }
+	pool := NewResrapMT(2, 8)
+	pool.SetSourceFactory(pcg)
+	for name, file := range map[string]string{"c": "example/c.g4", "sql": "example/sql.g4"} {
+		if err := pool.ParseGrammarFile(name, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.StartResrap()
+	defer pool.ShutDownResrap()
+
+	// The Resrap keeps the default source, names and contents both come from the pool's
+	r := codebaseResrap(t)
+	got, _ := generateTree(t, r, WithCodebaseSeed(5), WithPool(pool))
+	r.SetSourceFactory(pcg)
+	want, _ := generateTree(t, r, WithCodebaseSeed(5))
+	if !sameTree(got, want) {
+		t.Errorf("pool tree differs from the tree of its source:\n%v\n%v", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(want)))
+	}
+}
+
+func TestGenerateCodebaseSummary(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "spec.dsl")
+	if err := os.WriteFile(config, []byte(testDSL), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "out")
+
+	// Nothing may be printed, the callers own stdout
+	stdout := os.Stdout
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = write
+	printed := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(read)
+		printed <- data
+	}()
+
+	var log bytes.Buffer
+	var calls, tokens, size int
+	summary, err := codebaseResrap(t).GenerateCodebase(config, target,
+		WithCodebaseLogger(slog.New(slog.NewTextHandler(&log, nil))),
+		WithProgress(func(path string, n, written int) {
+			if _, err := os.Stat(filepath.Join(target, path)); err != nil {
+				t.Errorf("progress on %s: %v", path, err)
+			}
+			calls++
+			tokens += n
+			size += written
+		}))
+	write.Close()
+	os.Stdout = stdout
+	if out := <-printed; len(out) > 0 {
+		t.Errorf("printed to stdout:\n%s", out)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Dirs != 2 || summary.Files != 13 {
+		t.Errorf("%d directories and %d files, want 2 and 13", summary.Dirs, summary.Files)
+	}
+	if calls != summary.Files || tokens != summary.Tokens || int64(size) != summary.Bytes {
+		t.Errorf("progress saw %d files, %d tokens and %d bytes, summary says %d, %d and %d",
+			calls, tokens, size, summary.Files, summary.Tokens, summary.Bytes)
+	}
+	var files int
+	var onDisk int64
+	err = filepath.WalkDir(target, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		files++
+		onDisk += info.Size()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != summary.Files || onDisk != summary.Bytes {
+		t.Errorf("%d files of %d bytes on disk, summary says %d and %d", files, onDisk, summary.Files, summary.Bytes)
+	}
+	if n := strings.Count(log.String(), "msg=\"generated file\""); n != 13 {
+		t.Errorf("logged %d generated files, want 13:\n%s", n, log.String())
+	}
+	if n := strings.Count(log.String(), "msg=\"created directory\""); n != 2 {
+		t.Errorf("logged %d created directories, want 2", n)
+	}
+}
+
+// failingSink fails every write
+type failingSink struct{ *MemorySink }
+
+var errDiskFull = errors.New("disk full")
+
+func (*failingSink) WriteFile(string, []byte) error { return errDiskFull }
+
+func TestGenerateCodebaseErrors(t *testing.T) {
+	r := codebaseResrap(t)
+	if err := r.ParseGrammar("bad", "program: missing;"); err == nil {
+		t.Fatal("grammar with an undefined rule parsed")
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	good := write("good.dsl", testDSL)
+	blocker := write("blocker", "")
+
+	tests := []struct {
+		name   string
+		config string
+		target string
+		opts   []CodebaseOption
+		want   string
+	}{
+		{"missing config", filepath.Join(dir, "nope.dsl"), dir, nil, "nope.dsl"},
+		{"unknown grammar", write("go.dsl", "src/\n  go[2x10 *.go]\n"), dir, nil, "line 2: grammar 'go' is not loaded"},
+		{"broken grammar", write("bad.dsl", "src/\n  bad[2x10 *.b]\n"), dir, nil, "line 2: grammar 'bad' failed to parse"},
+		{"unknown start rule", write("start.dsl", "c[1x10 *.c start=nope]\n"), dir, nil, "line 1: start rule 'nope' not found"},
+		{"target under a file", good, filepath.Join(blocker, "out"), nil, "failed to create directory"},
+		{"failing sink", good, "", []CodebaseOption{WithSink(&failingSink{NewMemorySink()})}, "failed to write file 'src/"},
+	}
+	for _, tt := range tests {
+		summary, err := r.GenerateCodebase(tt.config, tt.target, tt.opts...)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		}
+		if summary.Files != 0 {
+			t.Errorf("%s: summary counts %d files", tt.name, summary.Files)
+		}
+	}
+	if _, err := r.GenerateCodebase(good, "", WithSink(&failingSink{NewMemorySink()})); !errors.Is(err, errDiskFull) {
+		t.Errorf("sink error %v not wrapped", err)
+	}
+}
+
+// withoutLines clears the DSL lines of a tree, so trees written differently compare equal
+func withoutLines(n *Node) *Node {
+	n.Line = 0
+	for _, child := range n.Children {
+		withoutLines(child)
+	}
+	return n
+}
+
+func TestParseDSLLayout(t *testing.T) {
+	want, err := ParseDSL(testDSL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutLines(want)
+	for name, input := range map[string]string{
+		"tabs":     "src/\n\tc[6x20..80 *.c seed=3]\n\tcore/\n\t\tc[4x50 {[a-z]}.c start=function]\n\tsql[3x30 q_*.sql]\n",
+		"no slash": strings.Replace(testDSL, "core/", "core", 1),
+		"comments": "# The sources\n" + strings.Replace(testDSL, "core/\n", "core/ # the core\n\n    # indented comment\n", 1) + "\r\n",
+	} {
+		got, err := ParseDSL(input)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(withoutLines(got), want) {
+			t.Errorf("%s: tree differs", name)
+		}
+	}
+
+	// '#' is only a comment outside of a file spec
+	tree, err := ParseDSL("c[2x10 #_*.c] # two files\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := tree.Children[0]; f.Pattern != "#_*.c" || f.Line != 1 {
+		t.Errorf("pattern %q on line %d, want #_*.c on line 1", f.Pattern, f.Line)
+	}
+}
+
+func TestParseDSLErrors(t *testing.T) {
+	for input, want := range map[string]string{
+		"src/\n  c[2x10 *.c]\n\tsql[1x5 *.sql]\n": "line 3: inconsistent indentation",
+		"src/\n \tc[2x10 *.c]\n":                  "line 2: inconsistent indentation",
+		"c[2x10 *.c\n":                            "line 1: unterminated file spec",
+		"[2x10 *.c]\n":                            "line 1: invalid grammar name ''",
+		"c-1[2x10 *.c]\n":                         "line 1: invalid grammar name 'c-1'",
+		"c[2x10]\n":                               "line 1: invalid file spec format",
+		"c[2 *.c]\n":                              "line 1: invalid count format '2'",
+		"c[ax10 *.c]\n":                           "line 1: invalid file count 'a'",
+		"c[2xb *.c]\n":                            "line 1: invalid token count 'b'",
+		"c[2x10..c *.c]\n":                        "line 1: invalid token count 'c'",
+		"c[2x10..5 *.c]\n":                        "line 1: invalid token range '10..5'",
+		"c[0x10 *.c]\n":                           "line 1: count and token count must be positive",
+		"c[2x10 *.c start]\n":                     "line 1: invalid option 'start'",
+		"c[2x10 *.c seed=-1]\n":                   "line 1: invalid seed '-1'",
+		"c[2x10 *.c name=a-b]\n":                  "line 1: invalid pool name 'a-b'",
+		"c[2x10 *.c color=red]\n":                 "line 1: unknown option 'color'",
+		"src/\n  ../\n":                           "line 2: invalid directory name '../'",
+		"a\\b/\n":                                 "line 1: invalid directory name 'a\\b/'",
+	} {
+		if _, err := ParseDSL(input); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want one containing %q", input, err, want)
+		}
+	}
+}
+
+func TestValidateDSL(t *testing.T) {
+	r := codebaseResrap(t)
+	if err := r.ParseGrammar("bad", "program: missing;"); err == nil {
+		t.Fatal("grammar with an undefined rule parsed")
+	}
+	tree, err := ParseDSL(`src/
+  c[2x10 *.c start=nope]
+  go[1x10 *.go]
+  bad[1x10 *.b]
+  sql[1x10 {nope}_*.sql]
+  sql[1x10 {x.sql]
+  c[1x10 *.c export=nope:pool]
+  ok/
+    c[1x10 *.c]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.ValidateDSL(tree)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	want := []string{
+		"line 2: start rule 'nope' not found in grammar 'c'",
+		"line 3: grammar 'go' is not loaded",
+		"line 4: grammar 'bad' failed to parse",
+		"line 5: file name rule 'nope' not found in grammar 'sql'",
+		"line 6: unterminated '{' in file name pattern '{x.sql'",
+		"line 7: symbol rule 'nope' not found in grammar 'c'",
+	}
+	if got := strings.Split(err.Error(), "\n"); !slices.Equal(got, want) {
+		t.Errorf("errors:\n%s\nwant:\n%s", err, strings.Join(want, "\n"))
+	}
+	if err := r.ValidateDSL(tree.Children[0].Children[6]); err != nil {
+		t.Errorf("valid directory: %v", err)
+	}
+}
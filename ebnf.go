@@ -0,0 +1,339 @@
+package resrap
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// W3C EBNF is the notation of the XML specification and most W3C specifications:
+//
+//	[1] document ::= prolog element Misc*
+//	Char ::= #x9 | #xA | #xD | [#x20-#xD7FF]
+//
+// Grammars in it work on characters, so every rule is imported as a lexical rule.
+
+type ebnfTokenKind uint8
+
+const (
+	eEOF    ebnfTokenKind = iota
+	eName                 //Symbol
+	eString               //"..." or '...', text is the content
+	eChar                 //#xN, text is the character
+	eClass                //[...], text is the raw content
+	ePunct                //::= ( ) | ? * + -
+)
+
+type ebnfToken struct {
+	kind ebnfTokenKind
+	text string
+	line int
+	bol  bool //First token of its line
+}
+
+// tokenizeEBNF splits a W3C EBNF grammar into tokens, dropping comments
+func tokenizeEBNF(src string) ([]ebnfToken, error) {
+	var toks []ebnfToken
+	line, bol := 1, true
+	add := func(kind ebnfTokenKind, text string) {
+		toks = append(toks, ebnfToken{kind, text, line, bol})
+		bol = false
+	}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line, bol = line+1, true
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case isIdentStart(rune(c)):
+			j := i
+			for j < len(src) && (isIdentPart(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			add(eName, src[i:j])
+			i = j
+		case c == '"' || c == '\'':
+			end := strings.IndexAny(src[i+1:], string(c)+"\n")
+			if end < 0 || src[i+1+end] != c {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			add(eString, src[i+1:i+1+end])
+			i += end + 2
+		case strings.HasPrefix(src[i:], "#x"):
+			r, size, err := ebnfHexChar(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			add(eChar, string(r))
+			i += size
+		case c == '[':
+			end := strings.IndexByte(src[i+1:], ']')
+			if end == 0 { // []...] starts with a ']' member
+				if end = strings.IndexByte(src[i+2:], ']'); end >= 0 {
+					end++
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated character class", line)
+			}
+			add(eClass, src[i+1:i+1+end])
+			line += strings.Count(src[i:i+1+end], "\n")
+			i += end + 2
+		case strings.HasPrefix(src[i:], "::="):
+			add(ePunct, "::=")
+			i += 3
+		case strings.ContainsRune("()|?*+-", rune(c)):
+			add(ePunct, string(c))
+			i++
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, r)
+		}
+	}
+	return append(toks, ebnfToken{eEOF, "", line, bol}), nil
+}
+
+// ebnfHexChar reads a #xN character reference, returning it and the bytes it took
+func ebnfHexChar(s string) (rune, int, error) {
+	j := 2
+	for j < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[j]) >= 0 {
+		j++
+	}
+	n, err := strconv.ParseUint(s[2:j], 16, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid character reference '%s'", s[:j])
+	}
+	return rune(n), j, nil
+}
+
+// parseEBNFClass reads the body of a character class like ^a-z#x20
+func parseEBNFClass(body string) (charSet, error) {
+	negated := strings.HasPrefix(body, "^")
+	body = strings.TrimPrefix(body, "^")
+	next := func() (rune, error) {
+		if strings.HasPrefix(body, "#x") {
+			r, size, err := ebnfHexChar(body)
+			body = body[size:]
+			return r, err
+		}
+		r, size := utf8.DecodeRuneInString(body)
+		body = body[size:]
+		return r, nil
+	}
+	var set charSet
+	for body != "" {
+		lo, err := next()
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if len(body) > 1 && body[0] == '-' {
+			body = body[1:]
+			if hi, err = next(); err != nil {
+				return nil, err
+			}
+		}
+		if lo <= hi {
+			set = set.union(charSet{{lo, hi}})
+		}
+	}
+	if negated {
+		return anyChar.minus(set), nil
+	}
+	return set, nil
+}
+
+var (
+	productionNumber = regexp.MustCompile(`^\s*\d+[a-z]?\s*$`)
+	constraintNote   = regexp.MustCompile(`(?i)^\s*(wfc|vc)\s*:`)
+)
+
+type ebnfParser struct {
+	toks    []ebnfToken
+	pos     int
+	g       *ggrammar
+	current string //Rule being read, for warnings
+}
+
+// importEBNF converts a W3C EBNF grammar
+func importEBNF(src string, _ importConfig) (*ggrammar, error) {
+	toks, err := tokenizeEBNF(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &ebnfParser{toks: toks, g: &ggrammar{}}
+	for p.peek().kind != eEOF {
+		p.skipNotes()
+		name := p.next()
+		if name.kind != eName || !p.accept("::=") {
+			p.pos--
+			return nil, p.errorf("expected a rule like 'name ::= ...'")
+		}
+		p.current = name.text
+		expr, err := p.alternatives()
+		if err != nil {
+			return nil, err
+		}
+		p.g.rules = append(p.g.rules, &grule{name: name.text, expr: expr, lexical: true})
+		if p.g.start == "" {
+			p.g.start = name.text
+		}
+		p.skipNotes()
+	}
+	if p.g.start == "" {
+		return nil, fmt.Errorf("grammar has no rules")
+	}
+	return p.g, nil
+}
+
+func (p *ebnfParser) peek() ebnfToken { return p.toks[p.pos] }
+
+func (p *ebnfParser) next() ebnfToken {
+	tok := p.toks[p.pos]
+	if tok.kind != eEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *ebnfParser) accept(punct string) bool {
+	if tok := p.peek(); tok.kind == ePunct && tok.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ebnfParser) errorf(format string, args ...any) error {
+	tok := p.peek()
+	found := "end of grammar"
+	if tok.kind != eEOF {
+		found = fmt.Sprintf("'%s'", tok.text)
+	}
+	return fmt.Errorf("line %d: %s, found %s", tok.line, fmt.Sprintf(format, args...), found)
+}
+
+// note reports whether tok is a production number like [12] starting a line, or a constraint like [ WFC: Unique Att Spec ]
+func (tok ebnfToken) note() bool {
+	return tok.kind == eClass && (constraintNote.MatchString(tok.text) || tok.bol && productionNumber.MatchString(tok.text))
+}
+
+// skipNotes skips production numbers and constraints
+func (p *ebnfParser) skipNotes() {
+	for tok := p.peek(); tok.note(); tok = p.peek() {
+		if constraintNote.MatchString(tok.text) {
+			p.g.warn(p.current, "constraint [%s] not enforced", strings.TrimSpace(tok.text))
+		}
+		p.pos++
+	}
+}
+
+// ruleStart reports whether the tokens from the current one start the next rule
+func (p *ebnfParser) ruleStart() bool {
+	i := p.pos
+	for p.toks[i].note() {
+		i++
+	}
+	if p.toks[i].kind == eEOF {
+		return true
+	}
+	return p.toks[i].kind == eName && p.toks[i+1].kind == ePunct && p.toks[i+1].text == "::="
+}
+
+func (p *ebnfParser) alternatives() (*gexpr, error) {
+	var alts []*gexpr
+	for {
+		var items []*gexpr
+		for !p.ruleStart() {
+			tok := p.peek()
+			if tok.kind == ePunct && (tok.text == "|" || tok.text == ")") {
+				break
+			}
+			if tok.kind == eClass && constraintNote.MatchString(tok.text) {
+				p.skipNotes()
+				continue
+			}
+			item, err := p.difference()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		alts = append(alts, gSeqOf(items...))
+		if !p.accept("|") {
+			return gAltOf(alts...), nil
+		}
+	}
+}
+
+// difference reads an item, and what it excludes with '-'
+func (p *ebnfParser) difference() (*gexpr, error) {
+	e, err := p.postfix()
+	if err != nil || !p.accept("-") {
+		return e, err
+	}
+	except, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	return gMinus(e, except), nil
+}
+
+func (p *ebnfParser) postfix() (*gexpr, error) {
+	e, err := p.atom()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.accept("?"):
+		return gRepeat(gOpt, e), nil
+	case p.accept("*"):
+		return gRepeat(gStar, e), nil
+	case p.accept("+"):
+		return gRepeat(gPlus, e), nil
+	}
+	return e, nil
+}
+
+func (p *ebnfParser) atom() (*gexpr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case eName:
+		return gRule(tok.text), nil
+	case eString:
+		return gLiteral(tok.text), nil
+	case eChar:
+		r, _ := utf8.DecodeRuneInString(tok.text)
+		return gChars(charSet{{r, r}}), nil
+	case eClass:
+		set, err := parseEBNFClass(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", tok.line, err)
+		}
+		return gChars(set), nil
+	case ePunct:
+		if tok.text == "(" {
+			e, err := p.alternatives()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.errorf("expected ')'")
+			}
+			return e, nil
+		}
+	}
+	p.pos--
+	return nil, p.errorf("expected an expression")
+}
@@ -0,0 +1,52 @@
+package resrap
+
+import "testing"
+
+func TestImportEBNF(t *testing.T) {
+	testImports(t, FormatW3CEBNF, []importCase{
+		{
+			name: "literals, sets and operators",
+			src: `/* A tiny document */
+[1] doc    ::= item (',' item)* ';'?
+[2] item   ::= "x" | #x41 | [a-c] | [#x30-#x32]+
+`,
+			want: `program: doc;
+doc: item doc_1* ';'?;
+doc_1: ',' item;
+item: 'x' | 'A' | item_1 | item_2+;
+item_1: 'a' | 'b' | 'c';
+item_2: '0' | '1' | '2';
+`,
+			sample: "c;",
+		},
+		{
+			name: "negated sets and exclusions",
+			src: `name  ::= [^ -z] char*
+char  ::= [a-d] - [bc]
+text  ::= word - 'ab'
+word  ::= [a-b]+
+`,
+			want: `program: name;
+name: name_1 char*;
+name_1: '\t' | '{' | '|' | '}' | '~';
+char: char_1;
+char_1: 'a' | 'd';
+text: word;
+word: word_1+;
+word_1: 'a' | 'b';
+`,
+			warnings: []string{"text: exclusion not enforced, everything it excludes from can be generated"},
+			sample:   "}",
+		},
+		{
+			name: "constraints and quotes",
+			src: `Attr ::= "it's" '"' [ WFC: No < in Attribute Values ]
+`,
+			want: `program: Attr;
+Attr: 'it\'s"';
+`,
+			warnings: []string{"Attr: constraint [WFC: No < in Attribute Values] not enforced"},
+			sample:   "it's\"",
+		},
+	})
+}
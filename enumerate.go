@@ -0,0 +1,112 @@
+package resrap
+
+import (
+	"errors"
+)
+
+// ErrEnumerationIncomplete is returned by Enumerate along with what it found when the search budget ran out
+var ErrEnumerationIncomplete = errors.New("enumeration stopped before exploring every derivation")
+
+// enumerateBudget bounds the number of partial derivations Enumerate explores
+const enumerateBudget = 1 << 20
+
+// enumFrame is an immutable jump stack, shared between derivations
+type enumFrame struct {
+	ret  uint32
+	next *enumFrame
+}
+
+// enumState is a partial derivation
+type enumState struct {
+	node   *syntaxNode
+	stack  *enumFrame
+	out    string
+	tokens int
+	steps  int
+}
+
+// Enumerate lists the distinct outputs of grammar 'name' that complete within maxTokens tokens,
+// fewest tokens first and at most limit of them (no limit when limit <= 0).
+// Every derivation is explored rather than sampled; regex terms are filled with a fixed sample.
+// Unbounded grammars may have too many derivations to explore, Enumerate then returns
+// what it found with ErrEnumerationIncomplete.
+func (r *Resrap) Enumerate(name, starting_node string, maxTokens, limit int) ([]string, error) {
+	if err := checkStart(r.languageGraph, name, starting_node); err != nil {
+		return nil, err
+	}
+	s := r.languageGraph[name].graph
+	samples := make(map[uint32]string)
+	sample := func(id uint32) string {
+		if text, ok := samples[id]; ok {
+			return text
+		}
+		prng := newPRNG(nil, uint64(id))
+		samples[id] = s.regexhandler.GenerateString(s.charmap[id], &prng)
+		return samples[id]
+	}
+	maxSteps := 64 * (maxTokens + 1) //Bounds derivations looping without printing tokens
+
+	var results []string
+	found := make(map[string]bool)
+	buckets := make([][]enumState, maxTokens+1) //Partial derivations by tokens printed
+	buckets[0] = append(buckets[0], enumState{node: s.nodeRef[s.namemap[starting_node]]})
+	explored := 0
+	for tokens := 0; tokens <= maxTokens; tokens++ {
+		for len(buckets[tokens]) > 0 {
+			st := buckets[tokens][0]
+			buckets[tokens] = buckets[tokens][1:]
+			if explored++; explored > enumerateBudget {
+				return results, ErrEnumerationIncomplete
+			}
+			if st.steps > maxSteps {
+				continue
+			}
+			st.steps++
+
+			// Same steps as syntaxGraph.walk, branching instead of picking
+			node := st.node
+			switch node.typ {
+			case ch:
+				st.out += unescapeString(s.charmap[node.id])
+				st.tokens++
+			case rx:
+				st.out += sample(node.id)
+			case pointer:
+				st.stack = &enumFrame{ret: node.next[0].node.id, next: st.stack}
+				st.node = s.nodeRef[node.pointer]
+				buckets[st.tokens] = append(buckets[st.tokens], st)
+				continue
+			case end:
+				if st.stack != nil {
+					st.node = s.nodeRef[st.stack.ret]
+					st.stack = st.stack.next
+					buckets[st.tokens] = append(buckets[st.tokens], st)
+					continue
+				}
+			}
+			if st.tokens > maxTokens {
+				continue
+			}
+			if len(node.next) == 0 {
+				if !found[st.out] {
+					found[st.out] = true
+					results = append(results, st.out)
+					if limit > 0 && len(results) >= limit {
+						return results, nil
+					}
+				}
+				continue
+			}
+			p := node.probabilities()
+			for i, next := range node.next {
+				if p[i] <= 0 {
+					continue
+				}
+				branch := st
+				branch.node = next.node
+				buckets[st.tokens] = append(buckets[st.tokens], branch)
+			}
+		}
+	}
+	return results, nil
+}
@@ -4,7 +4,7 @@ program
     : statement^;
 
 statement
-    : selectstmt ;'\n'
+    : selectstmt ';\n'
     | createtablestmt ';\n'
     | insertstmt ';\n' ;
 
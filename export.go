@@ -0,0 +1,414 @@
+package resrap
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Grammars are exported from the rules as written, the way FormatGrammar reads them. The other
+// notations have no probabilities, they are kept in comments in Resrap's notation, like
+// 'a' /* <0.3> */. A regex generates a few characters of its class, it is exported as one or
+// more of them, and '^' as '+', so the exported grammar accepts everything Resrap generates.
+
+// ExportFormat is a notation grammars can be exported to
+type ExportFormat int
+
+const (
+	ExportW3CEBNF  ExportFormat = iota // The EBNF of W3C specifications
+	ExportANTLR4                       // An ANTLR4 combined grammar
+	ExportRailroad                     // Railroad diagrams of the rules, as SVG
+)
+
+// exportFormats lists the export formats with their names and exporters
+var exportFormats = []struct {
+	format ExportFormat
+	name   string
+	export func(name string, rules []*fmtRule) string
+}{
+	{ExportW3CEBNF, "w3c-ebnf", exportEBNF},
+	{ExportANTLR4, "antlr4", exportANTLR},
+	{ExportRailroad, "svg", exportRailroad},
+}
+
+func (f ExportFormat) String() string {
+	for _, ef := range exportFormats {
+		if ef.format == f {
+			return ef.name
+		}
+	}
+	return fmt.Sprintf("ExportFormat(%d)", int(f))
+}
+
+// ParseExportFormat returns the export format with the given name, like "antlr4"
+func ParseExportFormat(name string) (ExportFormat, error) {
+	var names []string
+	for _, ef := range exportFormats {
+		if strings.EqualFold(ef.name, name) {
+			return ef.format, nil
+		}
+		names = append(names, ef.name)
+	}
+	return 0, fmt.Errorf("unknown export format '%s', expected one of %s", name, strings.Join(names, ", "))
+}
+
+// ExportGrammar writes a grammar in Resrap's notation in another one. The name titles
+// the result, like the 'grammar Name;' of ANTLR4.
+func ExportGrammar(name, src string, format ExportFormat) (string, error) {
+	f, _, err := readGrammarTree(src)
+	if err != nil {
+		return "", err
+	}
+	for _, ef := range exportFormats {
+		if ef.format == format {
+			return ef.export(name, f.rules), nil
+		}
+	}
+	return "", fmt.Errorf("unknown export format %v", format)
+}
+
+// ExportGrammar writes the grammar stored under name in another notation
+func (r *Resrap) ExportGrammar(name string, format ExportFormat) (string, error) {
+	l, ok := r.languageGraph[name]
+	return exportLang(name, l, ok, format)
+}
+
+// ExportGrammar writes the grammar stored under name in another notation
+func (r *ResrapMT) ExportGrammar(name string, format ExportFormat) (string, error) {
+	l, ok := r.graphs()[name]
+	return exportLang(name, l, ok, format)
+}
+
+func exportLang(name string, l lang, found bool, format ExportFormat) (string, error) {
+	if !found {
+		return "", fmt.Errorf("grammar '%s' not found", name)
+	}
+	if l.err != nil {
+		return "", l.err
+	}
+	return ExportGrammar(name, l.source, format)
+}
+
+// grammarExporter writes rules in EBNF or ANTLR4, their notations being close
+type grammarExporter struct {
+	antlr   bool
+	names   map[string]string //ANTLR4 names of the rules
+	classes map[string]string //ANTLR4 lexer rule made for each regex
+	lexer   []string          //Those lexer rules
+	current string            //Rule being written, lexer rules are named after it
+}
+
+func exportEBNF(_ string, rules []*fmtRule) string {
+	e := &grammarExporter{}
+	var b strings.Builder
+	b.WriteString("/* Exported from Resrap, probabilities are kept in comments */\n\n")
+	for _, r := range rules {
+		b.WriteString(e.rule(r.name, r.alts, " ::= ", "") + "\n")
+	}
+	return b.String()
+}
+
+func exportANTLR(name string, rules []*fmtRule) string {
+	e := &grammarExporter{antlr: true, names: map[string]string{}, classes: map[string]string{}}
+	taken := map[string]bool{}
+	for _, r := range rules {
+		e.names[r.name] = uniqueName(antlrParserName(r.name), taken)
+	}
+	var b strings.Builder
+	b.WriteString("// Exported from Resrap, probabilities are kept in comments\n")
+	fmt.Fprintf(&b, "grammar %s;\n\n", antlrGrammarName(name))
+	for _, r := range rules {
+		e.current = r.name
+		b.WriteString(e.rule(e.names[r.name], r.alts, " : ", " ;") + "\n")
+	}
+	if len(e.lexer) > 0 {
+		b.WriteString("\n")
+	}
+	for _, rule := range e.lexer {
+		b.WriteString(rule + "\n")
+	}
+	return b.String()
+}
+
+// rule writes a rule on one line, or one alternative per line when it is too long
+func (e *grammarExporter) rule(name string, alts []*fmtAlt, define, end string) string {
+	texts := e.alts(alts)
+	if !e.antlr && slices.Contains(texts, "") {
+		return name + define + e.optionalAlts(texts) + end //EBNF has no empty alternatives
+	}
+	line := strings.TrimRight(name+define+strings.TrimSpace(strings.Join(texts, " | ")), " ") + end //Trimmed around empty alternatives
+	if len(alts) == 1 || utf8.RuneCountInString(line) <= maxRuleWidth {
+		return line
+	}
+	if e.antlr {
+		for i, text := range texts {
+			if text != "" {
+				texts[i] = " " + text //Empty alternatives keep no space after the '|'
+			}
+		}
+	}
+	if e.antlr {
+		return name + "\n    :" + strings.Join(texts, "\n    |") + "\n    ;"
+	}
+	indent := strings.Repeat(" ", utf8.RuneCountInString(name)+1)
+	return name + define + strings.Join(texts, "\n"+indent+"|   ")
+}
+
+// optionalAlts writes alternatives some of which are empty as an optional group of the others
+func (e *grammarExporter) optionalAlts(texts []string) string {
+	var kept []string
+	for _, text := range texts {
+		if text != "" {
+			kept = append(kept, text)
+		}
+	}
+	if len(kept) == 0 {
+		return "''"
+	}
+	return "(" + strings.Join(kept, " | ") + ")?"
+}
+
+// alts writes alternatives, the probability of a '|' in a comment before it
+func (e *grammarExporter) alts(alts []*fmtAlt) []string {
+	texts := make([]string, len(alts))
+	for i, alt := range alts {
+		texts[i] = e.alt(alt)
+		if i < len(alts)-1 && alt.prob != "" {
+			texts[i] = strings.TrimSpace(texts[i] + " /* |" + probText(alt.prob) + " */")
+		}
+	}
+	return texts
+}
+
+func (e *grammarExporter) alt(alt *fmtAlt) string {
+	parts := make([]string, 0, len(alt.items))
+	for _, it := range alt.items {
+		if text := e.item(it); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// item writes an element with its operators, its probabilities in a comment after it
+func (e *grammarExporter) item(it *fmtItem) string {
+	var text string
+	atomic := true //Whether an operator can follow text without parentheses
+	switch it.tok.typ {
+	case identifier:
+		text = it.tok.text
+		if e.antlr {
+			text = e.names[text]
+			if text == "" {
+				text = antlrParserName(it.tok.text) //Not defined, ANTLR4 reports it
+			}
+		}
+	case character:
+		text = e.literal(unescapeString(it.tok.text))
+	case regex:
+		text = e.class(it.tok.text)
+		atomic = e.antlr //A lexer rule, the class is followed by '+'
+	case bracopen:
+		texts := e.alts(it.alts)
+		if !e.antlr && slices.Contains(texts, "") {
+			text, atomic = e.optionalAlts(texts), false
+		} else {
+			text = "(" + strings.Join(texts, " | ") + ")"
+		}
+	}
+
+	probs := probText(it.prob)
+	for _, p := range it.post {
+		op := map[tokenType]string{maybe: "?", oneormore: "+", anyno: "*", infinite: "^"}[p.op]
+		if p.prob != "" || p.op == infinite {
+			probs += " " + op + probText(p.prob)
+		}
+		if text == "" {
+			continue //Nothing to repeat
+		}
+		if p.op == infinite {
+			op = "+"
+		}
+		if !atomic {
+			text = "(" + text + ")"
+		}
+		text, atomic = text+op, false //ANTLR4 would read a second operator as non greedy
+	}
+	if probs = strings.TrimSpace(probs); probs != "" {
+		text = strings.TrimSpace(text + " /* " + probs + " */")
+	}
+	return text
+}
+
+// literal writes text as a string, split around the characters EBNF strings can't hold
+func (e *grammarExporter) literal(text string) string {
+	if e.antlr {
+		if text == "" {
+			return ""
+		}
+		var b strings.Builder
+		for _, r := range text {
+			b.WriteString(antlrEscape(r, "'"))
+		}
+		return "'" + b.String() + "'"
+	}
+
+	var parts []string
+	run := ""
+	flush := func() {
+		if run == "" {
+			return
+		}
+		quote := "'"
+		if strings.Contains(run, "'") {
+			quote = `"`
+		}
+		parts = append(parts, quote+run+quote)
+		run = ""
+	}
+	for _, r := range text {
+		switch {
+		case !unicode.IsPrint(r) || r == '"' && strings.Contains(run, "'") || r == '\'' && strings.Contains(run, `"`):
+			flush()
+			if unicode.IsPrint(r) {
+				run = string(r)
+			} else {
+				parts = append(parts, fmt.Sprintf("#x%X", r))
+			}
+		default:
+			run += string(r)
+		}
+	}
+	flush()
+	switch len(parts) {
+	case 0:
+		return "''"
+	case 1:
+		return parts[0]
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// class writes a regex as one or more characters of its class, a lexer rule for ANTLR4
+func (e *grammarExporter) class(class string) string {
+	var b strings.Builder
+	b.WriteString("[")
+	for _, rg := range classRanges(class) {
+		for i, r := range []rune{rg[0], rg[1]} {
+			if i == 1 {
+				if rg[1] == rg[0] {
+					break
+				}
+				b.WriteString("-")
+			}
+			switch {
+			case e.antlr:
+				b.WriteString(antlrEscape(r, `]-\`))
+			case !unicode.IsPrint(r) || unicode.IsSpace(r) || strings.ContainsRune(`[]^-#`, r):
+				fmt.Fprintf(&b, "#x%X", r)
+			default:
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteString("]+")
+	if !e.antlr {
+		return b.String()
+	}
+
+	if name, ok := e.classes[class]; ok {
+		return name
+	}
+	taken := map[string]bool{}
+	for _, name := range e.names {
+		taken[name] = true
+	}
+	for _, name := range e.classes {
+		taken[name] = true
+	}
+	name := uniqueName(antlrLexerName(e.current), taken)
+	e.classes[class] = name
+	e.lexer = append(e.lexer, name+" : "+b.String()+" ;")
+	return name
+}
+
+// classRanges lists the characters a regex generates as sorted ranges
+func classRanges(class string) [][2]rune {
+	var rx regexer
+	chars := rx.ExpandClass(class)
+	slices.Sort(chars)
+	var ranges [][2]rune
+	for _, c := range slices.Compact(chars) {
+		if n := len(ranges); n > 0 && ranges[n-1][1]+1 == c {
+			ranges[n-1][1] = c
+		} else {
+			ranges = append(ranges, [2]rune{c, c})
+		}
+	}
+	return ranges
+}
+
+// antlrEscape writes a character of an ANTLR4 string or set, escaping the special ones
+func antlrEscape(r rune, special string) string {
+	switch {
+	case r == '\n':
+		return `\n`
+	case r == '\r':
+		return `\r`
+	case r == '\t':
+		return `\t`
+	case r == '\\' || strings.ContainsRune(special, r):
+		return `\` + string(r)
+	case !unicode.IsPrint(r):
+		return fmt.Sprintf(`\u%04X`, r)
+	}
+	return string(r)
+}
+
+var antlrReserved = map[string]bool{
+	"grammar": true, "lexer": true, "parser": true, "fragment": true, "import": true, "options": true,
+	"tokens": true, "channels": true, "mode": true, "returns": true, "locals": true, "throws": true,
+	"catch": true, "finally": true,
+}
+
+// antlrParserName turns a rule name into an ANTLR4 parser rule name, which starts in lower case
+func antlrParserName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	name = string(unicode.ToLower(r)) + name[size:]
+	if !unicode.IsLower(r) && !unicode.IsUpper(r) || antlrReserved[name] {
+		name = "r_" + name
+	}
+	return name
+}
+
+// antlrLexerName names a lexer rule made from a regex of the rule name
+func antlrLexerName(name string) string {
+	name = strings.ToUpper(strings.TrimLeft(name, "_"))
+	if name == "" || !unicode.IsUpper(rune(name[0])) {
+		name = "T_" + name
+	}
+	return name
+}
+
+var nonIdent = regexp.MustCompile(`\W`)
+
+// antlrGrammarName turns a grammar name into an identifier for 'grammar Name;'
+func antlrGrammarName(name string) string {
+	name = nonIdent.ReplaceAllString(name, "_")
+	if r, _ := utf8.DecodeRuneInString(name); name == "" || !unicode.IsLetter(r) {
+		name = "G" + name
+	}
+	return name
+}
+
+// uniqueName appends '_' to name until it is not taken, and takes it
+func uniqueName(name string, taken map[string]bool) string {
+	for taken[name] {
+		name += "_"
+	}
+	taken[name] = true
+	return name
+}
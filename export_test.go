@@ -0,0 +1,175 @@
+package resrap
+
+import (
+	"encoding/xml"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const exportSrc = `program: stmt+;
+stmt: 'let ' Name '=' [0-9]^ ';' <0.7> | 'print ' Name? ';';
+Name: ([a-z] | '_')* ;
+`
+
+// exportEdgeSrc has rules whose ANTLR4 names collide, quotes, control characters and empty alternatives
+const exportEdgeSrc = `program: a A 'it\'s "q"' '\n' (a <0.2> | A)? <0.5>;
+a: 'x' | ;
+A: [a-c&] 'a<b' ;
+`
+
+func TestExportGrammar(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		format ExportFormat
+		want   string
+	}{
+		{"calc", exportSrc, ExportW3CEBNF, `/* Exported from Resrap, probabilities are kept in comments */
+
+program ::= stmt+
+stmt ::= 'let ' Name '=' ([0-9]+)+ /* ^ */ ';' /* <0.7> */ | 'print ' Name? ';'
+Name ::= ([a-z]+ | '_')*
+`},
+		{"calc", exportSrc, ExportANTLR4, `// Exported from Resrap, probabilities are kept in comments
+grammar calc;
+
+program : stmt+ ;
+stmt : 'let ' name '=' STMT+ /* ^ */ ';' /* <0.7> */ | 'print ' name? ';' ;
+name : (NAME | '_')* ;
+
+STMT : [0-9]+ ;
+NAME : [a-z]+ ;
+`},
+		{"edge", exportEdgeSrc, ExportW3CEBNF, `/* Exported from Resrap, probabilities are kept in comments */
+
+program ::= a A ("it's " '"q"') #xA (a /* <0.2> */ | A)? /* ?<0.5> */
+a ::= ('x')?
+A ::= [&a-c]+ 'a<b'
+`},
+		{"my-lang 2", exportEdgeSrc, ExportANTLR4, `// Exported from Resrap, probabilities are kept in comments
+grammar my_lang_2;
+
+program : a a_ 'it\'s "q"' '\n' (a /* <0.2> */ | a_)? /* ?<0.5> */ ;
+a : 'x' | ;
+a_ : A 'a<b' ;
+
+A : [&a-c]+ ;
+`},
+	}
+	for _, tt := range tests {
+		got, err := ExportGrammar(tt.name, tt.src, tt.format)
+		if err != nil {
+			t.Errorf("%s as %v: %v", tt.name, tt.format, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s as %v:\n%s\nwant:\n%s", tt.name, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestExportImportsBack(t *testing.T) {
+	for _, src := range []string{exportSrc, exportEdgeSrc} {
+		for format, back := range map[ExportFormat]GrammarFormat{ExportW3CEBNF: FormatW3CEBNF, ExportANTLR4: FormatANTLR4} {
+			exported, err := ExportGrammar("g", src, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewResrap()
+			if err := r.ParseGrammarAs("g", exported, back); err != nil {
+				t.Errorf("%v export doesn't import back: %v\n%s", format, err, exported)
+				continue
+			}
+			if code := r.GenerateWithSeeded("g", "program", 1, 40); code == "" {
+				t.Errorf("%v export generates nothing once imported", format)
+			}
+		}
+	}
+}
+
+func TestExportRailroad(t *testing.T) {
+	svg, err := ExportGrammar("a<b", exportEdgeSrc, ExportRailroad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The SVG is well formed, with a group per rule
+	var groups, texts []string
+	d := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid SVG: %v\n%s", err, svg)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Local == "g" {
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "id" {
+						groups = append(groups, attr.Value)
+					}
+				}
+			}
+		case xml.CharData:
+			texts = append(texts, string(tok))
+		}
+	}
+	if strings.Join(groups, " ") != "program a A" {
+		t.Errorf("groups %v, want program a A", groups)
+	}
+	// Titles, literals, regexes and probabilities are written as text
+	for _, want := range []string{"a<b", `'it\'s "q"'`, `'\n'`, "[a-c&]", "'a<b'", "<0.2>", "?<0.5>"} {
+		if !slices.Contains(texts, want) {
+			t.Errorf("no text %q in the SVG", want)
+		}
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	for _, f := range []ExportFormat{ExportW3CEBNF, ExportANTLR4, ExportRailroad} {
+		parsed, err := ParseExportFormat(strings.ToUpper(f.String()))
+		if err != nil || parsed != f {
+			t.Errorf("%v: parsed back as %v, %v", f, parsed, err)
+		}
+	}
+	if _, err := ParseExportFormat("yacc"); err == nil || err.Error() != "unknown export format 'yacc', expected one of w3c-ebnf, antlr4, svg" {
+		t.Errorf("error %v", err)
+	}
+	if s := ExportFormat(9).String(); s != "ExportFormat(9)" {
+		t.Errorf("unknown format named %q", s)
+	}
+	if _, err := ExportGrammar("g", exportSrc, ExportFormat(9)); err == nil {
+		t.Error("exported to an unknown format")
+	}
+	if _, err := ExportGrammar("g", "program: 'a'", ExportW3CEBNF); err == nil {
+		t.Error("exported a grammar that doesn't parse")
+	}
+}
+
+func TestResrapExportGrammar(t *testing.T) {
+	r := NewResrap()
+	mt := NewResrapMT(1, 1)
+	want, _ := ExportGrammar("calc", exportSrc, ExportANTLR4)
+	for kind, g := range map[string]interface {
+		ParseGrammar(name, grammar string) error
+		ExportGrammar(name string, format ExportFormat) (string, error)
+	}{"Resrap": r, "ResrapMT": mt} {
+		if err := g.ParseGrammar("calc", exportSrc); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := g.ExportGrammar("calc", ExportANTLR4); err != nil || got != want {
+			t.Errorf("%s: exported %q, %v", kind, got, err)
+		}
+		if _, err := g.ExportGrammar("nope", ExportANTLR4); err == nil || err.Error() != "grammar 'nope' not found" {
+			t.Errorf("%s: unknown grammar: %v", kind, err)
+		}
+		g.ParseGrammar("bad", "program: missing;")
+		if _, err := g.ExportGrammar("bad", ExportANTLR4); err == nil {
+			t.Errorf("%s: exported a grammar that failed to parse", kind)
+		}
+	}
+}
@@ -1,53 +1,15 @@
 package resrap
 
 import (
-	"bufio"
 	"os"
-	"strings"
 )
 
-// ParseFile reads a file and returns statements split by lines ending with ';',
-// skipping lines starting with "//".
-func parseFile(filename string) ([]string, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var statements []string
-	var current strings.Builder
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// skip comment or empty lines
-		if strings.HasPrefix(line, "//") || line == "" {
-			continue
-		}
-
-		// accumulate this line
-		current.WriteString(line + " ")
-
-		// if line ends with ';', finalize statement
-		if strings.HasSuffix(line, ";") {
-			stmt := strings.TrimSpace(current.String())
-			statements = append(statements, stmt)
-			current.Reset()
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return statements, nil
-}
-
 type lang struct {
-	graph *syntaxGraph
-	nodes int
+	graph    *syntaxGraph
+	nodes    int
+	err      error        //Error the grammar was parsed with, if any
+	warnings []Diagnostic //Problems found converting the grammar from another format
+	source   string       //Grammar in Resrap's notation, for the exporters
 }
 
 func newLang() lang {
@@ -56,21 +18,21 @@ func newLang() lang {
 func (l *lang) GetGraph() *syntaxGraph {
 	return l.graph
 }
+
+// ParserFile parses a grammar file. Rules may span lines and '//' comments may go anywhere,
+// the scanner drops them.
 func (l *lang) ParserFile(filename string) error {
-	lines, err := parseFile(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	gb := newGraphBuilder()
-	content := strings.Join(lines, "")
-	err = gb.start_generation(content)
-	l.graph = &gb.pars.graph
-	return err
+	return l.ParserString(string(data))
 }
 
 func (l *lang) ParserString(data string) error {
 	gb := newGraphBuilder()
 	err := gb.start_generation(data)
 	l.graph = &gb.pars.graph
+	l.source = data
 	return err
 }
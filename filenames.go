@@ -0,0 +1,143 @@
+package resrap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// nameTokens bounds how many tokens a {rule} placeholder may expand to
+const nameTokens = 16
+
+// nameAttempts is how many different names a file gets before giving up on a duplicate
+const nameAttempts = 100
+
+type nameSegmentType int8
+
+const (
+	nameLiteral nameSegmentType = iota // Copied as is
+	nameIndex                          // *, the file number
+	nameRule                           // {rule}, expanded from the grammar
+	nameClass                          // {[a-z]}, sampled by the regexer
+)
+
+type nameSegment struct {
+	typ  nameSegmentType
+	text string
+}
+
+// namePattern is a parsed file name pattern such as "code_*.c" or "{ident}_{[a-z]}.c"
+type namePattern []nameSegment
+
+// parseNamePattern splits pattern into literals, the * index and {...} generators
+func parseNamePattern(pattern string) (namePattern, error) {
+	var segs namePattern
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			segs = append(segs, nameSegment{nameLiteral, lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			flush()
+			segs = append(segs, nameSegment{nameIndex, ""})
+		case '{':
+			end := strings.IndexByte(pattern[i:], '}')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated '{' in file name pattern '%s'", pattern)
+			}
+			inner := pattern[i+1 : i+end]
+			flush()
+			switch {
+			case strings.HasPrefix(inner, "[") && strings.HasSuffix(inner, "]") && len(inner) > 2:
+				segs = append(segs, nameSegment{nameClass, inner[1 : len(inner)-1]})
+			case inner != "" && isIdentStart(rune(inner[0])) && strings.IndexFunc(inner, func(r rune) bool { return !isIdentPart(r) }) == -1:
+				segs = append(segs, nameSegment{nameRule, inner})
+			default:
+				return nil, fmt.Errorf("invalid generator '{%s}' in file name pattern '%s', expected a rule name or a [class]", inner, pattern)
+			}
+			i += end
+		case '/', '\\':
+			return nil, fmt.Errorf("file name pattern '%s' must not contain path separators", pattern)
+		default:
+			lit.WriteByte(pattern[i])
+		}
+	}
+	flush()
+	return segs, nil
+}
+
+// generated reports whether names from the pattern vary beyond the file number
+func (p namePattern) generated() bool {
+	for _, seg := range p {
+		if seg.typ == nameRule || seg.typ == nameClass {
+			return true
+		}
+	}
+	return false
+}
+
+// rules returns the grammar rules the pattern expands
+func (p namePattern) rules() []string {
+	var rules []string
+	for _, seg := range p {
+		if seg.typ == nameRule {
+			rules = append(rules, seg.text)
+		}
+	}
+	return rules
+}
+
+// expand builds the name of file number i, drawing generated parts from prng
+func (p namePattern) expand(i int, graph *syntaxGraph, rx *regexer, prng *prng) string {
+	var sb strings.Builder
+	for _, seg := range p {
+		switch seg.typ {
+		case nameLiteral:
+			sb.WriteString(seg.text)
+		case nameIndex:
+			sb.WriteString(strconv.Itoa(i))
+		case nameRule:
+			sb.WriteString(sanitizeName(graph.GraphWalk(prng, seg.text, nameTokens)))
+		case nameClass:
+			if _, ok := rx.cached_rex[seg.text]; !ok {
+				rx.CacheRegex(seg.text)
+			}
+			sb.WriteString(sanitizeName(rx.GenerateString(seg.text, prng)))
+		}
+	}
+	return sb.String()
+}
+
+// sanitizeName keeps generated text usable inside a file name
+func sanitizeName(s string) string {
+	s = strings.TrimSpace(s)
+	return strings.Map(func(r rune) rune {
+		if isIdentPart(r) || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// uniqueName returns the name of file number i, retrying generated names until one is free in taken.
+// The attempts are seeded from the file seed, so names are as reproducible as contents.
+func (p namePattern) uniqueName(i int, seed uint64, graph *syntaxGraph, rx *regexer, source SourceFactory, taken map[string]bool) (string, error) {
+	attempts := 1
+	if p.generated() {
+		attempts = nameAttempts
+	}
+	for k := 0; k < attempts; k++ {
+		prng := newPRNG(source, BatchSeed(seed, k+1))
+		name := p.expand(i, graph, rx, &prng)
+		if name == "" || name == "." || name == ".." || taken[name] {
+			continue
+		}
+		taken[name] = true
+		return name, nil
+	}
+	return "", fmt.Errorf("could not generate a unique file name")
+}
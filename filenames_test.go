@@ -0,0 +1,131 @@
+package resrap
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseNamePattern(t *testing.T) {
+	got, err := parseNamePattern("{ident}_*-{[a-z0-9]}.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := namePattern{
+		{nameRule, "ident"}, {nameLiteral, "_"}, {nameIndex, ""}, {nameLiteral, "-"}, {nameClass, "a-z0-9"}, {nameLiteral, ".c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("segments %v, want %v", got, want)
+	}
+	if !got.generated() || !reflect.DeepEqual(got.rules(), []string{"ident"}) {
+		t.Errorf("generated %v, rules %v", got.generated(), got.rules())
+	}
+	if plain, _ := parseNamePattern("code_*.c"); plain.generated() {
+		t.Error("code_*.c reported as generated")
+	}
+
+	for pattern, want := range map[string]string{
+		"{ident.c":  "unterminated '{'",
+		"{}.c":      "invalid generator '{}'",
+		"{[]}.c":    "invalid generator '{[]}'",
+		"{1abc}.c":  "invalid generator '{1abc}'",
+		"{a-b}.c":   "invalid generator '{a-b}'",
+		"src/*.c":   "must not contain path separators",
+		`src\*.c`:   "must not contain path separators",
+		"{x}/{y}.c": "must not contain path separators",
+	} {
+		if _, err := parseNamePattern(pattern); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want one containing %q", pattern, err, want)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	for in, want := range map[string]string{
+		"  foo bar\n": "foo_bar",
+		"a/b\\c":      "a_b_c",
+		"x-1.y":       "x-1.y",
+		"é!":          "__",
+	} {
+		if got := sanitizeName(in); got != want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	l := newLang()
+	if err := l.ParserString("program: ident;\nident: 'foo' | 'bar baz';\n"); err != nil {
+		t.Fatal(err)
+	}
+	l.graph.Normalize()
+	rx := newRegexer()
+	pattern, _ := parseNamePattern("{ident}_*.c")
+
+	taken := map[string]bool{}
+	first, err := pattern.uniqueName(1, 7, l.graph, &rx, nil, taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != "foo_1.c" && first != "bar_baz_1.c" {
+		t.Errorf("name %q, want foo_1.c or bar_baz_1.c", first)
+	}
+	if again, _ := pattern.uniqueName(1, 7, l.graph, &rx, nil, map[string]bool{}); again != first {
+		t.Errorf("same seed named %q then %q", first, again)
+	}
+	// Only two names exist for file 1, the third one runs out of attempts
+	second, err := pattern.uniqueName(1, 7, l.graph, &rx, nil, taken)
+	if err != nil || second == first {
+		t.Fatalf("second name %q (%v) after %q", second, err, first)
+	}
+	if _, err := pattern.uniqueName(1, 7, l.graph, &rx, nil, taken); err == nil {
+		t.Error("a third unique name out of two")
+	}
+
+	// Names made of the file number alone get a single attempt
+	plain, _ := parseNamePattern("*.c")
+	if _, err := plain.uniqueName(1, 7, l.graph, &rx, nil, map[string]bool{"1.c": true}); err == nil {
+		t.Error("1.c named twice")
+	}
+}
+
+func TestCodebaseGeneratedNames(t *testing.T) {
+	r := NewResrap()
+	if err := r.ParseGrammar("words", "program: ident;\nident: 'alpha' | 'beta' | 'gamma';\n"); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ParseDSL("src/\n  words[3x5 {ident}.txt]\n  words[20x5 {[a-z]}{[0-9]}_*.txt]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := tree.GenerateStructure(r, "", WithDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	numbered := regexp.MustCompile(`^src/[a-z]{3}[0-9]{3}_[0-9]+\.txt$`)
+	for i, f := range summary.Manifest.Files {
+		if names[f.Path] {
+			t.Errorf("%s named twice", f.Path)
+		}
+		names[f.Path] = true
+		if i < 3 {
+			if !regexp.MustCompile(`^src/(alpha|beta|gamma)\.txt$`).MatchString(f.Path) {
+				t.Errorf("rule name %s", f.Path)
+			}
+		} else if !numbered.MatchString(f.Path) {
+			t.Errorf("class name %s", f.Path)
+		}
+	}
+
+	// A fourth name out of three rule outputs can't be made unique
+	tree, err = ParseDSL("src/\n  words[4x5 {ident}.txt]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tree.GenerateStructure(r, "", WithDryRun())
+	if want := "line 2: file 4 of '{ident}.txt' in 'src': could not generate a unique file name"; err == nil || err.Error() != want {
+		t.Errorf("error %v, want %q", err, want)
+	}
+}
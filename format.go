@@ -0,0 +1,188 @@
+package resrap
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// GrammarFormat is a notation grammars can be written in
+type GrammarFormat int
+
+const (
+	FormatResrap     GrammarFormat = iota // Resrap's own notation
+	FormatANTLR4                          // ANTLR4 parser and lexer grammars (.g4)
+	FormatW3CEBNF                         // The EBNF of W3C specifications, like XML's
+	FormatRFC5234                         // The ABNF of IETF specifications (RFC 5234), not Resrap's own ABNF
+	FormatTreeSitter                      // The grammar.json tree-sitter generates
+	FormatBison                           // Yacc and Bison grammars (.y)
+	FormatPEG                             // PEG grammars: classic PEG, peg.js/Peggy and pest
+)
+
+// grammarFormats lists the formats with their names and importers, Resrap needing none
+var grammarFormats = []struct {
+	format GrammarFormat
+	name   string
+	load   func(src string, c importConfig) (*ggrammar, error)
+}{
+	{FormatResrap, "resrap", nil},
+	{FormatANTLR4, "antlr4", importANTLR},
+	{FormatW3CEBNF, "w3c-ebnf", importEBNF},
+	{FormatRFC5234, "rfc5234", importABNF},
+	{FormatTreeSitter, "tree-sitter", importTreeSitter},
+	{FormatBison, "bison", importBison},
+	{FormatPEG, "peg", importPEG},
+}
+
+func (f GrammarFormat) String() string {
+	for _, gf := range grammarFormats {
+		if gf.format == f {
+			return gf.name
+		}
+	}
+	return fmt.Sprintf("GrammarFormat(%d)", int(f))
+}
+
+// ParseGrammarFormat returns the format with the given name, like "antlr4".
+// "abnf" and "ebnf" are rejected as ambiguous, "yacc" is read as "bison".
+func ParseGrammarFormat(name string) (GrammarFormat, error) {
+	switch strings.ToLower(name) {
+	case "abnf":
+		return 0, fmt.Errorf("ambiguous grammar format 'abnf', use 'resrap' for Resrap's Awesome BNF or 'rfc5234' for IETF ABNF")
+	case "ebnf":
+		return 0, fmt.Errorf("ambiguous grammar format 'ebnf', use 'w3c-ebnf' for the EBNF of W3C specifications")
+	case "yacc":
+		return FormatBison, nil
+	}
+	var names []string
+	for _, gf := range grammarFormats {
+		if strings.EqualFold(gf.name, name) {
+			return gf.format, nil
+		}
+		names = append(names, gf.name)
+	}
+	return 0, fmt.Errorf("unknown grammar format '%s', expected one of %s", name, strings.Join(names, ", "))
+}
+
+var (
+	treeSitterJSON = regexp.MustCompile(`^\s*\{[\s\S]*"rules"\s*:\s*\{`)
+	antlrHeader    = regexp.MustCompile(`(?m)^\s*((lexer|parser)\s+)?grammar\s+\w+\s*;`)
+	bisonSections  = regexp.MustCompile(`(?m)^%%`)
+	pegRule        = regexp.MustCompile(`(?m)^\s*[A-Za-z_]\w*\s*(<-|=\s*[_@$!]?\s*\{)`)
+	peggyRule      = regexp.MustCompile(`(?m)^\s*[A-Za-z_]\w*\s*("[^"\n]*"\s*)?=`)
+	pegSuffix      = regexp.MustCompile(`(?m)[\w)\]"'][*+?]([ \t)]|$)|\{`)
+	ebnfRule       = regexp.MustCompile(`(?m)^\s*(\[\d+[a-z]?\]\s*)?[A-Za-z_][\w.]*\s*::=`)
+	abnfRule       = regexp.MustCompile(`(?m)^\s*[A-Za-z][A-Za-z0-9-]*\s*=/?\s*\S`)
+)
+
+// DetectGrammarFormat guesses the format of a grammar from its text, FormatResrap when nothing else matches
+func DetectGrammarFormat(src string) GrammarFormat {
+	switch {
+	case treeSitterJSON.MatchString(src):
+		return FormatTreeSitter
+	case antlrHeader.MatchString(src):
+		return FormatANTLR4
+	case bisonSections.MatchString(src):
+		return FormatBison
+	case pegRule.MatchString(src), peggyRule.MatchString(src) && pegSuffix.MatchString(src):
+		return FormatPEG //Postfix operators and actions tell peg.js grammars from RFC 5234 ones
+	case ebnfRule.MatchString(src):
+		return FormatW3CEBNF
+	case abnfRule.MatchString(src):
+		return FormatRFC5234
+	}
+	return FormatResrap
+}
+
+// ImportOption tunes the conversion of grammars written in other formats
+type ImportOption func(*importConfig)
+
+type importConfig struct {
+	tokens map[string]string
+}
+
+// WithTokenText gives the text tokens are generated as, for the formats that declare tokens
+// without their text like Bison's %token NUMBER
+func WithTokenText(tokens map[string]string) ImportOption {
+	return func(c *importConfig) {
+		c.tokens = tokens
+	}
+}
+
+// ConvertGrammar translates a grammar written in format to Resrap's notation.
+// The warnings report what the conversion had to drop or approximate.
+func ConvertGrammar(src string, format GrammarFormat, opts ...ImportOption) (string, []Diagnostic, error) {
+	var c importConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	for _, gf := range grammarFormats {
+		if gf.format != format {
+			continue
+		}
+		if gf.load == nil {
+			return src, nil, nil
+		}
+		g, err := gf.load(src, c)
+		if err != nil {
+			return "", nil, fmt.Errorf("%v grammar: %w", format, err)
+		}
+		text := g.emit()
+		return text, g.warnings, nil
+	}
+	return "", nil, fmt.Errorf("unknown grammar format %v", format)
+}
+
+// importLang converts and parses a grammar, keeping the conversion warnings for Lint
+func importLang(src string, format GrammarFormat, opts ...ImportOption) lang {
+	l := newLang()
+	text, warnings, err := ConvertGrammar(src, format, opts...)
+	if err != nil {
+		l.err = err
+		return l
+	}
+	l.err = l.ParserString(text)
+	l.warnings = warnings
+	l.graph.Normalize()
+	return l
+}
+
+// ParseGrammarAs parses a grammar written in format and stores it under the given name.
+// Lint reports what the conversion dropped along with the usual diagnostics.
+func (r *Resrap) ParseGrammarAs(name, grammar string, format GrammarFormat, opts ...ImportOption) error {
+	l := importLang(grammar, format, opts...)
+	r.languageGraph[name] = l
+	if l.err != nil {
+		r.metrics.GenerationError(name)
+	}
+	return l.err
+}
+
+// ParseGrammarFileAs parses a grammar file written in format and stores it under the given name.
+func (r *Resrap) ParseGrammarFileAs(name, location string, format GrammarFormat, opts ...ImportOption) error {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return err
+	}
+	return r.ParseGrammarAs(name, string(data), format, opts...)
+}
+
+// ParseGrammarAs parses a grammar written in format and stores it under the given name.
+func (r *ResrapMT) ParseGrammarAs(name, grammar string, format GrammarFormat, opts ...ImportOption) error {
+	l := importLang(grammar, format, opts...)
+	r.store(name, l)
+	if l.err != nil {
+		r.metrics.GenerationError(name)
+	}
+	return l.err
+}
+
+// ParseGrammarFileAs parses a grammar file written in format and stores it under the given name.
+func (r *ResrapMT) ParseGrammarFileAs(name, location string, format GrammarFormat, opts ...ImportOption) error {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return err
+	}
+	return r.ParseGrammarAs(name, string(data), format, opts...)
+}
@@ -0,0 +1,58 @@
+package resrap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectGrammarFormat(t *testing.T) {
+	cases := map[string]GrammarFormat{
+		"program: 'a';\n":                        FormatResrap,
+		"grammar Expr;\nexpr: INT;\n":            FormatANTLR4,
+		"lexer grammar L;\nINT: [0-9]+;\n":       FormatANTLR4,
+		"parser grammar P;\nprog: INT;\n":        FormatANTLR4,
+		"[1] document ::= prolog element\n":      FormatW3CEBNF,
+		"URI = scheme \":\" hier-part\n":         FormatRFC5234,
+		`{"name": "x", "rules": {"a": {}}}`:      FormatTreeSitter,
+		"%token NUM\n%%\nexp: NUM;\n%%\n":        FormatBison,
+		"Sum <- Num ('+' Num)*\n":                FormatPEG,
+		"sum = { num ~ (\"+\" ~ num)* }\n":       FormatPEG,
+		"sum = num (\"+\" num)*\nnum = [0-9]+\n": FormatPEG,
+	}
+	for src, want := range cases {
+		if got := DetectGrammarFormat(src); got != want {
+			t.Errorf("%q detected as %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestParseGrammarFormat(t *testing.T) {
+	for _, gf := range grammarFormats {
+		if got, err := ParseGrammarFormat(strings.ToUpper(gf.name)); err != nil || got != gf.format {
+			t.Errorf("%s: got %v, %v", gf.name, got, err)
+		}
+	}
+	if got, _ := ParseGrammarFormat("yacc"); got != FormatBison {
+		t.Errorf("yacc read as %v", got)
+	}
+	for _, name := range []string{"abnf", "ebnf", "bnf"} {
+		if _, err := ParseGrammarFormat(name); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestImportedGrammarLint(t *testing.T) {
+	src := "grammar G;\ns : {act();} 'a' ;\n"
+	r := NewResrap()
+	if err := r.ParseGrammarAs("g", src, FormatANTLR4); err != nil {
+		t.Fatal(err)
+	}
+	diags := r.Lint("g", "program")
+	if len(diags) == 0 || diags[0].Message != "action dropped" || diags[0].Rule != "s" {
+		t.Errorf("got %v, want the conversion warning first", diags)
+	}
+	if err := r.ParseGrammarAs("bad", "grammar B;\ns : 'a' \n", FormatANTLR4); err == nil || !strings.Contains(err.Error(), "antlr4 grammar") {
+		t.Errorf("got %v, want an antlr4 grammar error", err)
+	}
+}
@@ -0,0 +1,528 @@
+package resrap
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Grammars written in other notations are imported by translating them to a tree of gexpr,
+// which is emitted as Resrap grammar text and parsed like any other grammar.
+
+type gexprKind uint8
+
+const (
+	gEmpty gexprKind = iota //The empty string
+	gLit                    //Literal text
+	gSet                    //A single character of set
+	gRef                    //Reference to the rule named text
+	gSeq                    //items in order
+	gAlt                    //One of items
+	gOpt                    //items[0] or nothing
+	gStar                   //items[0] any number of times
+	gPlus                   //items[0] at least once
+	gNone                   //Nothing, the alternatives using it are dropped
+	gDiff                   //A character of items[0] that items[1] doesn't match
+)
+
+type gexpr struct {
+	kind  gexprKind
+	text  string
+	set   charSet
+	items []*gexpr
+}
+
+func gLiteral(text string) *gexpr   { return &gexpr{kind: gLit, text: text} }
+func gRule(name string) *gexpr      { return &gexpr{kind: gRef, text: name} }
+func gChars(set charSet) *gexpr     { return &gexpr{kind: gSet, set: set} }
+func gSeqOf(items ...*gexpr) *gexpr { return &gexpr{kind: gSeq, items: items} }
+func gAltOf(items ...*gexpr) *gexpr { return &gexpr{kind: gAlt, items: items} }
+func gRepeat(kind gexprKind, e *gexpr) *gexpr {
+	return &gexpr{kind: kind, items: []*gexpr{e}}
+}
+func gMinus(e, except *gexpr) *gexpr { return &gexpr{kind: gDiff, items: []*gexpr{e, except}} }
+
+// maxCopies bounds the copies a counted repetition is written out as
+const maxCopies = 16
+
+// gCount matches e from least to most times, most < 0 meaning no upper bound.
+// Counts above maxCopies are approximated, reported by the false result.
+func gCount(e *gexpr, least, most int) (*gexpr, bool) {
+	exact := true
+	if least > maxCopies {
+		least, most, exact = maxCopies, -1, false
+	}
+	if most >= 0 && most-least > maxCopies {
+		most, exact = -1, false
+	}
+	var items []*gexpr
+	for range least {
+		items = append(items, e)
+	}
+	switch {
+	case most < 0 && least == 0:
+		items = append(items, gRepeat(gStar, e))
+	case most < 0:
+		items[len(items)-1] = gRepeat(gPlus, e)
+	default:
+		for range most - least {
+			items = append(items, gRepeat(gOpt, e))
+		}
+	}
+	return gSeqOf(items...), exact
+}
+
+// simplify flattens nested sequences and alternatives, drops empty parts and
+// alternatives that can't be generated, and turns alternatives with an empty member into options
+func (e *gexpr) simplify() *gexpr {
+	switch e.kind {
+	case gSeq:
+		var items []*gexpr
+		for _, item := range e.items {
+			item = item.simplify()
+			switch item.kind {
+			case gNone:
+				return item
+			case gEmpty:
+			case gSeq:
+				items = append(items, item.items...)
+			default:
+				items = append(items, item)
+			}
+		}
+		switch len(items) {
+		case 0:
+			return &gexpr{kind: gEmpty}
+		case 1:
+			return items[0]
+		}
+		return gSeqOf(items...)
+	case gAlt:
+		var items []*gexpr
+		empty := false
+		for _, item := range e.items {
+			item = item.simplify()
+			switch item.kind {
+			case gNone:
+			case gEmpty:
+				empty = true
+			case gAlt:
+				items = append(items, item.items...)
+			case gOpt:
+				empty = true
+				items = append(items, item.items[0])
+			default:
+				items = append(items, item)
+			}
+		}
+		var out *gexpr
+		switch {
+		case len(items) == 0 && empty:
+			return &gexpr{kind: gEmpty}
+		case len(items) == 0:
+			return &gexpr{kind: gNone}
+		case len(items) == 1:
+			out = items[0]
+		default:
+			out = gAltOf(items...)
+		}
+		if empty {
+			return gRepeat(gOpt, out).simplify()
+		}
+		return out
+	case gDiff:
+		return gMinus(e.items[0].simplify(), e.items[1].simplify())
+	case gOpt, gStar, gPlus:
+		inner := e.items[0].simplify()
+		if inner.kind == gNone && e.kind == gPlus {
+			return inner
+		}
+		if inner.kind == gEmpty || inner.kind == gNone {
+			return &gexpr{kind: gEmpty}
+		}
+		kind := e.kind
+		switch {
+		case inner.kind == gStar || (inner.kind == gOpt || inner.kind == gPlus) && kind != inner.kind:
+			kind, inner = gStar, inner.items[0] //Any mix of ? * and + matches any number of times
+		case inner.kind == kind:
+			inner = inner.items[0]
+		}
+		return gRepeat(kind, inner)
+	}
+	return e
+}
+
+// refs calls fn with every rule e references
+func (e *gexpr) refs(fn func(name string)) {
+	if e.kind == gRef {
+		fn(e.text)
+	}
+	for _, item := range e.items {
+		item.refs(fn)
+	}
+}
+
+// grule is a rule of an imported grammar
+type grule struct {
+	name    string
+	expr    *gexpr
+	lexical bool //The characters of a single token, generated without separators
+}
+
+// ggrammar is an imported grammar, ready to be emitted as Resrap grammar text
+type ggrammar struct {
+	rules    []*grule
+	start    string //Rule generation starts from, aliased as program
+	sep      string //Written after every token of the non lexical rules
+	warnings []Diagnostic
+}
+
+// warn records a problem of the import, once per rule and message
+func (g *ggrammar) warn(rule, format string, args ...any) {
+	d := Diagnostic{Severity: SeverityWarning, Rule: rule, Message: fmt.Sprintf(format, args...)}
+	if !slices.Contains(g.warnings, d) {
+		g.warnings = append(g.warnings, d)
+	}
+}
+
+// maxSetLiterals bounds the literals a character set is emitted as, larger sets are sampled
+const maxSetLiterals = 100
+
+// emit writes the grammar as Resrap grammar text. Rules referenced but never defined
+// are generated as their name, with a warning.
+func (g *ggrammar) emit() string {
+	rules := make(map[string]*grule, len(g.rules))
+	for _, r := range g.rules {
+		rules[r.name] = r
+	}
+	var missing []string
+	for _, r := range g.rules {
+		r.expr.refs(func(name string) {
+			if rules[name] == nil && !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+		})
+	}
+	all := g.rules
+	for _, name := range missing {
+		g.warn(name, "not defined, generated as '%s'", strings.ToLower(name))
+		all = append(all, &grule{name: name, expr: gLiteral(strings.ToLower(name)), lexical: true})
+		rules[name] = all[len(all)-1]
+	}
+
+	names := map[string]string{}
+	taken := map[string]bool{}
+	for _, r := range all {
+		name := resrapName(r.name)
+		for taken[name] {
+			name += "_"
+		}
+		names[r.name], taken[name] = name, true
+	}
+
+	for _, r := range all {
+		r.expr = g.resolve(r.name, r.expr, rules)
+	}
+
+	var b strings.Builder
+	if g.start != "" && names[g.start] != "program" && !taken["program"] {
+		fmt.Fprintf(&b, "program: %s;\n", names[g.start])
+	}
+	for _, r := range all {
+		var groups []string
+		em := emitter{g: g, rule: r.name, rules: rules, names: names, taken: taken, groups: &groups, lexical: r.lexical, sep: g.sep}
+		expr := r.expr.simplify()
+		if expr.kind == gNone {
+			g.warn(r.name, "none of its alternatives could be converted, generated as nothing")
+		}
+		body, _ := em.expr(expr)
+		fmt.Fprintf(&b, "%s: %s;\n", names[r.name], body)
+		for _, group := range groups {
+			b.WriteString(group)
+		}
+	}
+	return b.String()
+}
+
+// resolve replaces the differences of e by the characters they match,
+// or by what they exclude from when that isn't a set of characters
+func (g *ggrammar) resolve(rule string, e *gexpr, rules map[string]*grule) *gexpr {
+	for i, item := range e.items {
+		e.items[i] = g.resolve(rule, item, rules)
+	}
+	if e.kind != gDiff {
+		return e
+	}
+	from, ok := chars(e.items[0], rules, map[string]bool{})
+	except, ok2 := chars(e.items[1], rules, map[string]bool{})
+	if ok && ok2 {
+		return gChars(from.minus(except))
+	}
+	g.warn(rule, "exclusion not enforced, everything it excludes from can be generated")
+	return e.items[0]
+}
+
+// chars returns the characters e matches when it matches a single one, following rules
+func chars(e *gexpr, rules map[string]*grule, seen map[string]bool) (charSet, bool) {
+	switch e.kind {
+	case gSet:
+		return e.set, true
+	case gLit:
+		if r, size := utf8.DecodeRuneInString(e.text); size > 0 && size == len(e.text) {
+			return charSet{{r, r}}, true
+		}
+	case gRef:
+		if r := rules[e.text]; r != nil && !seen[e.text] {
+			seen[e.text] = true
+			defer delete(seen, e.text)
+			return chars(r.expr, rules, seen)
+		}
+	case gDiff:
+		from, ok := chars(e.items[0], rules, seen)
+		except, ok2 := chars(e.items[1], rules, seen)
+		return from.minus(except), ok && ok2
+	case gAlt:
+		var all charSet
+		for _, item := range e.items {
+			set, ok := chars(item, rules, seen)
+			if !ok {
+				return nil, false
+			}
+			all = all.union(set)
+		}
+		return all, true
+	case gSeq:
+		if len(e.items) == 1 {
+			return chars(e.items[0], rules, seen)
+		}
+	}
+	return nil, false
+}
+
+// resrapName turns a rule name into a Resrap identifier, replacing what it can't contain by '_'
+func resrapName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !isIdentPart(rune(c)) {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || !isIdentStart(rune(b[0])) {
+		b = append([]byte("r_"), b...)
+	}
+	return string(b)
+}
+
+// emitter writes the expressions of one rule
+type emitter struct {
+	g       *ggrammar
+	rule    string //Rule being written, for warnings
+	rules   map[string]*grule
+	names   map[string]string //Resrap names of the rules
+	taken   map[string]bool   //Resrap names in use, groups included
+	groups  *[]string         //Rules the groups of the rule are written as
+	lexical bool
+	sep     string
+}
+
+// group writes text as a rule of its own named after the rule it is in, returning its name.
+// Groups aren't written in brackets: the end of a bracket returns from the rule it is in,
+// so what follows a bracket in a rule called from another one would never be generated.
+func (em emitter) group(text string) string {
+	base := em.names[em.rule]
+	name := base + "_1"
+	for n := 2; em.taken[name]; n++ {
+		name = fmt.Sprintf("%s_%d", base, n)
+	}
+	em.taken[name] = true
+	*em.groups = append(*em.groups, fmt.Sprintf("%s: %s;\n", name, text))
+	return name
+}
+
+// expr returns the Resrap text of e, and whether it is a single element a suffix can apply to
+func (em emitter) expr(e *gexpr) (string, bool) {
+	switch e.kind {
+	case gEmpty, gNone:
+		return "''", true
+	case gLit:
+		if !em.lexical {
+			return em.literal(e.text + em.sep), true
+		}
+		return em.literal(e.text), true
+	case gRef:
+		if !em.lexical && em.sep != "" && em.rules[e.text] != nil && em.rules[e.text].lexical {
+			return em.names[e.text] + " " + em.literal(em.sep), false
+		}
+		return em.names[e.text], true
+	case gSet:
+		members := e.set.members(maxSetLiterals)
+		if len(members) == 0 {
+			return "''", true
+		}
+		lits := make([]string, len(members))
+		for i, r := range members {
+			lits[i] = em.literal(string(r))
+		}
+		text := strings.Join(lits, " | ")
+		if len(lits) > 1 {
+			text = em.group(text)
+		}
+		if !em.lexical && em.sep != "" {
+			return text + " " + em.literal(em.sep), false
+		}
+		return text, true
+	case gSeq:
+		parts := make([]string, 0, len(e.items))
+		if em.lexical {
+			e = mergeLiterals(e)
+		}
+		for _, item := range e.items {
+			text, _ := em.expr(item)
+			if item.kind == gAlt {
+				text = em.group(text)
+			}
+			parts = append(parts, text)
+		}
+		return strings.Join(parts, " "), false
+	case gAlt:
+		parts := make([]string, len(e.items))
+		for i, item := range e.items {
+			parts[i], _ = em.expr(item)
+		}
+		return strings.Join(parts, " | "), false
+	case gOpt, gStar, gPlus:
+		suffix := map[gexprKind]string{gOpt: "?", gStar: "*", gPlus: "+"}[e.kind]
+		text, atom := em.expr(e.items[0])
+		if !atom {
+			text = em.group(text)
+		}
+		return text + suffix, true
+	}
+	return "''", true
+}
+
+// mergeLiterals joins the adjacent literals of a sequence, they are generated as one token
+func mergeLiterals(e *gexpr) *gexpr {
+	var items []*gexpr
+	for _, item := range e.items {
+		if n := len(items); n > 0 && item.kind == gLit && items[n-1].kind == gLit {
+			items[n-1] = gLiteral(items[n-1].text + item.text)
+			continue
+		}
+		items = append(items, item)
+	}
+	return gSeqOf(items...)
+}
+
+// literal writes text as a Resrap literal, escaping what the scanner or unescapeString would read differently
+func (em emitter) literal(text string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range text {
+		switch r {
+		case '\'':
+			b.WriteString(`\'`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// charSet is a set of characters, as sorted ranges that neither overlap nor touch
+type charSet []runeRange
+
+type runeRange struct {
+	lo, hi rune
+}
+
+var (
+	anyChar = charSet{{'\t', '\t'}, {' ', '~'}} //What negated sets and wildcards pick from
+)
+
+// union returns the characters in s or o
+func (s charSet) union(o charSet) charSet {
+	all := append(slices.Clone(s), o...)
+	slices.SortFunc(all, func(a, b runeRange) int { return int(a.lo - b.lo) })
+	var out charSet
+	for _, r := range all {
+		if n := len(out); n > 0 && r.lo <= out[n-1].hi+1 {
+			out[n-1].hi = max(out[n-1].hi, r.hi)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// minus returns the characters in s but not in o
+func (s charSet) minus(o charSet) charSet {
+	var out charSet
+	for _, r := range s {
+		lo := r.lo
+		for _, cut := range o {
+			if cut.hi < lo || cut.lo > r.hi {
+				continue
+			}
+			if cut.lo > lo {
+				out = append(out, runeRange{lo, cut.lo - 1})
+			}
+			lo = cut.hi + 1
+		}
+		if lo <= r.hi {
+			out = append(out, runeRange{lo, r.hi})
+		}
+	}
+	return out
+}
+
+// intersect returns the characters in both s and o
+func (s charSet) intersect(o charSet) charSet {
+	return s.minus(s.minus(o))
+}
+
+func (s charSet) size() int {
+	n := 0
+	for _, r := range s {
+		n += int(r.hi-r.lo) + 1
+	}
+	return n
+}
+
+// at returns the i-th character of s
+func (s charSet) at(i int) rune {
+	for _, r := range s {
+		if n := int(r.hi-r.lo) + 1; i >= n {
+			i -= n
+			continue
+		}
+		return r.lo + rune(i)
+	}
+	return -1
+}
+
+// members lists the characters of s, evenly sampled down to limit of them.
+// Large sets keep their printable ASCII characters when they have some.
+func (s charSet) members(limit int) []rune {
+	if s.size() > limit {
+		if ascii := s.intersect(anyChar); ascii.size() > 0 {
+			s = ascii
+		}
+	}
+	n := s.size()
+	out := make([]rune, 0, min(n, limit))
+	for i := 0; i < min(n, limit); i++ {
+		out = append(out, s.at(i*n/min(n, limit)))
+	}
+	return out
+}
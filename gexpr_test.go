@@ -0,0 +1,120 @@
+package resrap
+
+import (
+	"slices"
+	"testing"
+)
+
+// importCase is a grammar written in another format, with the Resrap text and warnings it converts to
+type importCase struct {
+	name     string
+	src      string
+	want     string
+	warnings []string // "rule: message", or the message alone when it isn't about a rule
+	sample   string   // What the converted grammar generates from program with seed 1 and 40 tokens
+}
+
+// testImports converts every case, then loads it and generates its sample
+func testImports(t *testing.T, format GrammarFormat, cases []importCase, opts ...ImportOption) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, diags, err := ConvertGrammar(c.src, format, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("converted to\n%s\nwant\n%s", got, c.want)
+			}
+			var warnings []string
+			for _, d := range diags {
+				if d.Severity != SeverityWarning {
+					t.Errorf("%v is not a warning", d)
+				}
+				if d.Rule != "" {
+					warnings = append(warnings, d.Rule+": "+d.Message)
+				} else {
+					warnings = append(warnings, d.Message)
+				}
+			}
+			if !slices.Equal(warnings, c.warnings) {
+				t.Errorf("warnings %q, want %q", warnings, c.warnings)
+			}
+
+			r := NewResrap()
+			if err := r.ParseGrammarAs("g", c.src, format, opts...); err != nil {
+				t.Fatalf("converted grammar doesn't load: %v", err)
+			}
+			for _, d := range r.Lint("g", "program") {
+				if d.Severity == SeverityError {
+					t.Errorf("converted grammar: %v", d)
+				}
+			}
+			if sample := r.GenerateWithSeeded("g", "program", 1, 40); sample != c.sample {
+				t.Errorf("generated %q, want %q", sample, c.sample)
+			}
+		})
+	}
+}
+
+func TestGCount(t *testing.T) {
+	a := gLiteral("a")
+	cases := []struct {
+		least, most int
+		want        string
+		exact       bool
+	}{
+		{0, -1, "'a'*", true},
+		{1, -1, "'a'+", true},
+		{2, -1, "'a' 'a'+", true},
+		{2, 3, "'aa' 'a'?", true},
+		{0, 1, "'a'?", true},
+		{20, -1, "'aaaaaaaaaaaaaaa' 'a'+", false},
+	}
+	for _, c := range cases {
+		e, exact := gCount(a, c.least, c.most)
+		em := emitter{g: &ggrammar{}, lexical: true, groups: new([]string), names: map[string]string{}, taken: map[string]bool{}}
+		if got, _ := em.expr(e.simplify()); got != c.want || exact != c.exact {
+			t.Errorf("%d..%d: got %s (exact %v), want %s (exact %v)", c.least, c.most, got, exact, c.want, c.exact)
+		}
+	}
+}
+
+func TestCharSet(t *testing.T) {
+	digits := charSet{{'0', '9'}}
+	letters := charSet{{'a', 'z'}}
+	all := digits.union(letters).union(charSet{{':', '@'}})
+	if want := (charSet{{'0', '@'}, {'a', 'z'}}); !slices.Equal(all, want) {
+		t.Errorf("union %v, want %v", all, want)
+	}
+	if got, want := all.minus(charSet{{'5', 'b'}}), (charSet{{'0', '4'}, {'c', 'z'}}); !slices.Equal(got, want) {
+		t.Errorf("minus %v, want %v", got, want)
+	}
+	if got := all.intersect(letters); !slices.Equal(got, letters) {
+		t.Errorf("intersect %v, want %v", got, letters)
+	}
+	if got := string(digits.members(5)); got != "02468" {
+		t.Errorf("members sampled as %q, want \"02468\"", got)
+	}
+	// Large sets keep their printable ASCII characters
+	if got := (charSet{{0, 0x10ffff}}).members(maxSetLiterals); got[0] != '\t' || got[len(got)-1] > '~' {
+		t.Errorf("large set sampled as %q", string(got))
+	}
+}
+
+func TestEmitterLiteral(t *testing.T) {
+	em := emitter{g: &ggrammar{}}
+	for text, want := range map[string]string{
+		`it's`:  `'it\'s'`,
+		`a\b`:   `'a\\b'`,
+		"x\n\t": `'x\n\t'`,
+		`"`:     `'"'`,
+	} {
+		if got := em.literal(text); got != want {
+			t.Errorf("literal(%q) = %s, want %s", text, got, want)
+		}
+		if got := unescapeString(want[1 : len(want)-1]); got != text {
+			t.Errorf("%s generates %q, want %q", want, got, text)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package resrap
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// gitRepo writes a git repository (loose objects, refs and index) through a CodebaseSink,
+// without needing the git binary
+type gitRepo struct {
+	sink    CodebaseSink
+	gitdir  string          //Slash separated path of the .git directory inside the sink
+	written map[string]bool //Objects already in the object store
+}
+
+func newGitRepo(sink CodebaseSink, worktree string) *gitRepo {
+	return &gitRepo{sink: sink, gitdir: path.Join(worktree, ".git"), written: make(map[string]bool)}
+}
+
+// init creates the directory layout and config of a non bare repository checked out on branch
+func (g *gitRepo) init(branch string) error {
+	for _, dir := range []string{"objects", "refs/heads", "refs/tags"} {
+		if err := g.sink.MkdirAll(path.Join(g.gitdir, dir)); err != nil {
+			return err
+		}
+	}
+	config := "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n"
+	if err := g.sink.WriteFile(path.Join(g.gitdir, "config"), []byte(config)); err != nil {
+		return err
+	}
+	return g.sink.WriteFile(path.Join(g.gitdir, "HEAD"), []byte("ref: refs/heads/"+branch+"\n"))
+}
+
+// object stores a loose object and returns its hash
+func (g *gitRepo) object(typ string, content []byte) (string, error) {
+	raw := append([]byte(fmt.Sprintf("%s %d\x00", typ, len(content))), content...)
+	sum := sha1.Sum(raw)
+	hash := hex.EncodeToString(sum[:])
+	if g.written[hash] {
+		return hash, nil
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(raw)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	dir := path.Join(g.gitdir, "objects", hash[:2])
+	if err := g.sink.MkdirAll(dir); err != nil {
+		return "", err
+	}
+	if err := g.sink.WriteFile(path.Join(dir, hash[2:]), buf.Bytes()); err != nil {
+		return "", err
+	}
+	g.written[hash] = true
+	return hash, nil
+}
+
+// tree stores the blobs and trees of files, keyed by slash separated path, and returns the root tree hash
+func (g *gitRepo) tree(files map[string][]byte) (string, error) {
+	type entry struct {
+		name  string
+		mode  string
+		hash  string
+		isDir bool
+	}
+	subdirs := make(map[string]map[string][]byte)
+	var entries []entry
+	for p, content := range files {
+		if dir, rest, found := strings.Cut(p, "/"); found {
+			if subdirs[dir] == nil {
+				subdirs[dir] = make(map[string][]byte)
+			}
+			subdirs[dir][rest] = content
+			continue
+		}
+		hash, err := g.object("blob", content)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, entry{name: p, mode: "100644", hash: hash})
+	}
+	for dir, sub := range subdirs {
+		hash, err := g.tree(sub)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, entry{name: dir, mode: "40000", hash: hash, isDir: true})
+	}
+	// Git sorts tree entries as if directory names ended with '/'
+	key := func(e entry) string {
+		if e.isDir {
+			return e.name + "/"
+		}
+		return e.name
+	}
+	sort.Slice(entries, func(i, j int) bool { return key(entries[i]) < key(entries[j]) })
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		raw, _ := hex.DecodeString(e.hash)
+		buf.WriteString(e.mode + " " + e.name + "\x00")
+		buf.Write(raw)
+	}
+	return g.object("tree", buf.Bytes())
+}
+
+// commit stores a commit of tree on top of parent ("" for a root commit) and returns its hash
+func (g *gitRepo) commit(tree, parent, author string, when time.Time, message string) (string, error) {
+	var buf strings.Builder
+	buf.WriteString("tree " + tree + "\n")
+	if parent != "" {
+		buf.WriteString("parent " + parent + "\n")
+	}
+	stamp := fmt.Sprintf("%s %d %s", author, when.Unix(), when.Format("-0700"))
+	buf.WriteString("author " + stamp + "\n")
+	buf.WriteString("committer " + stamp + "\n\n")
+	buf.WriteString(message + "\n")
+	return g.object("commit", []byte(buf.String()))
+}
+
+// setBranch points branch at commit
+func (g *gitRepo) setBranch(branch, commit string) error {
+	return g.sink.WriteFile(path.Join(g.gitdir, "refs/heads", branch), []byte(commit+"\n"))
+}
+
+// index writes a version 2 index matching files, so the checked out work tree shows as clean.
+// Stat fields are left empty, git refreshes them from the work tree on first use.
+func (g *gitRepo) index(files map[string][]byte) error {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, uint32(len(paths)))
+	for _, p := range paths {
+		sum := sha1.Sum(append([]byte(fmt.Sprintf("blob %d\x00", len(files[p]))), files[p]...))
+		start := buf.Len()
+		var stat [10]uint32 // ctime, ctime ns, mtime, mtime ns, dev, ino, mode, uid, gid, size
+		stat[6] = 0100644
+		stat[9] = uint32(len(files[p]))
+		binary.Write(&buf, binary.BigEndian, stat)
+		buf.Write(sum[:])
+		binary.Write(&buf, binary.BigEndian, uint16(min(len(p), 0xfff)))
+		buf.WriteString(p)
+		// Entries are NUL padded to a multiple of 8 bytes, with at least one NUL
+		pad := 8 - (buf.Len()-start)%8
+		buf.Write(make([]byte, pad))
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return g.sink.WriteFile(path.Join(g.gitdir, "index"), buf.Bytes())
+}
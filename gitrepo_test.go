@@ -0,0 +1,147 @@
+package resrap
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGitObjectHashes(t *testing.T) {
+	sink := NewMemorySink()
+	g := newGitRepo(sink, "")
+	blob, err := g.object("blob", []byte("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob != "ce013625030ba8dba906f756967f9e9ca394464a" {
+		t.Errorf("blob hash %s, want git's ce013625030ba8dba906f756967f9e9ca394464a", blob)
+	}
+	stored := sink.FS()[".git/objects/ce/013625030ba8dba906f756967f9e9ca394464a"]
+	if stored == nil {
+		t.Fatal("blob not stored as a loose object")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(stored.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := io.ReadAll(zr); string(raw) != "blob 6\x00hello\n" {
+		t.Errorf("loose object holds %q", raw)
+	}
+
+	if empty, _ := g.tree(nil); empty != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("empty tree hash %s, want git's 4b825dc642cb6eb9a060e54bf8d69288fbee4904", empty)
+	}
+	// "a" sorts after "a.c" as a directory, before it as a file
+	tree, err := g.tree(map[string][]byte{"a.c": []byte("x\n"), "a/b.c": []byte("y\n"), "a-b": []byte("z\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree != "1db8cb12b2df20c608ef4f99739741a2f8927d19" {
+		t.Errorf("tree hash %s, want git's 1db8cb12b2df20c608ef4f99739741a2f8927d19", tree)
+	}
+}
+
+// TestGitHistoryFsck checks a generated history with the git binary, when there is one
+func TestGitHistoryFsck(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	tree, err := ParseDSL(testDSL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	summary, err := tree.GenerateStructure(codebaseResrap(t), "", WithSink(NewDiskSink(dir)),
+		WithCodebaseSeed(5), WithGitHistory(GitHistory{Commits: 6, Authors: []string{"A <a@example.com>", "B <b@example.com>"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("fsck", "--strict", "--no-dangling")
+	if head := git("rev-parse", "HEAD"); head != summary.Head {
+		t.Errorf("HEAD is %s, the summary says %s", head, summary.Head)
+	}
+	if n := git("rev-list", "--count", "HEAD"); n != "6" {
+		t.Errorf("%s commits, want 6", n)
+	}
+	if status := git("status", "--porcelain"); status != "" {
+		t.Errorf("work tree isn't clean:\n%s", status)
+	}
+}
+
+func TestGitHistoryWithoutFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	tree, err := ParseDSL("src/\n  empty/\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if _, err := tree.GenerateStructure(NewResrap(), "", WithSink(NewDiskSink(dir)), WithGitHistory(GitHistory{Commits: 4})); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git", "rev-list", "--count", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git rev-list: %v\n%s", err, out)
+	}
+	if n := strings.TrimSpace(string(out)); n != "1" {
+		t.Errorf("%s commits, want only the initial one", n)
+	}
+}
+
+func TestGitHistorySymbolPools(t *testing.T) {
+	r := NewResrap()
+	for name, grammar := range map[string]string{
+		"defs": "program : 'def ' name ';\\n' ;\nname : [a-z] ;\n",
+		"uses": "program : ('use ' ref ';\\n')+ ;\nref : [A-Z] ;\n",
+	} {
+		if err := r.ParseGrammar(name, grammar); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := ParseDSL("defs[2x4 d*.txt export=name:names]\nuses[6x12 u*.txt import=ref:names]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewMemorySink()
+	summary, err := tree.GenerateStructure(r, "", WithSink(sink), WithGitHistory(GitHistory{Commits: 30}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	planned := make(map[string]string)
+	for _, f := range summary.Manifest.Files {
+		planned[f.Path] = f.SHA256
+	}
+	changed := 0
+	for p, f := range sink.FS() {
+		if !strings.HasPrefix(p, "u") {
+			continue
+		}
+		if planned[p] != contentHash(string(f.Data)) {
+			changed++
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(f.Data)), "\n") {
+			if ref := strings.TrimSuffix(strings.TrimPrefix(line, "use "), ";"); strings.ToLower(ref) != ref {
+				t.Errorf("%s: '%s' doesn't use a symbol of the pool", p, line)
+			}
+		}
+	}
+	if changed == 0 {
+		t.Error("the history changed none of the importing files")
+	}
+}
@@ -1,6 +1,7 @@
 package resrap
 
 import (
+	"context"
 	"sort"
 	"strings"
 
@@ -87,18 +88,47 @@ func (s *syntaxGraph) Normalize() {
 		// For probability based selections
 	}
 }
+
+// walkStats describes a single walk through the graph
+type walkStats struct {
+	tokens   int  //Tokens printed
+	maxDepth int  //Deepest the jump stack grew
+	missing  bool //Starting node was not found
+	cut      bool //The token limit stopped the walk
+	stopped  bool //The context of the walk was done before it ended
+}
+
 func (s *syntaxGraph) GraphWalk(prng *prng, start string, tokens int) string {
+	result, _ := s.walk(context.Background(), prng, start, tokens, nil)
+	return result
+}
+
+// walkCheck is how many steps a walk takes between checks of its context
+const walkCheck = 1 << 12
+
+// walk generates up to tokens tokens from start, sharing symbols through hooks when not nil.
+// It gives up when ctx is done, so walks that never end don't hold their goroutine forever.
+func (s *syntaxGraph) walk(ctx context.Context, prng *prng, start string, tokens int, hooks *walkHooks) (string, walkStats) {
 	var result strings.Builder
+	var stats walkStats
 	jumpStack := stack.New()
 	startingNode := s.nodeRef[s.namemap[start]]
 	if startingNode == nil {
-		return ""
+		stats.missing = true
+		return "", stats
 	}
 	printedTokens := 0
 	current := startingNode
-	for current != nil {
+	for step := 1; current != nil; step++ {
+		if step%walkCheck == 0 && ctx.Err() != nil {
+			stats.tokens = printedTokens
+			stats.stopped = true
+			return result.String(), stats
+		}
 		if printedTokens >= tokens {
-			return result.String()
+			stats.tokens = printedTokens
+			stats.cut = true
+			return result.String(), stats
 		}
 		// Process logic only if name starts with ' or [
 
@@ -111,8 +141,17 @@ func (s *syntaxGraph) GraphWalk(prng *prng, start string, tokens int) string {
 		} else if current.typ == rx {
 			result.WriteString(s.regexhandler.GenerateString(s.charmap[current.id], prng))
 		} else if current.typ == pointer {
+			if symbol, ok := hooks.substitute(current.pointer, prng); ok {
+				result.WriteString(symbol)
+				printedTokens++
+				current = current.next[0].node // Straight to the jump node, the rule is not walked
+				continue
+			}
 			jumpStack.Push(current.next[0].node.id)
-			current = s.GetNode(current.pointer, header)
+			stats.maxDepth = max(stats.maxDepth, jumpStack.Len())
+			hooks.enter(current.pointer, result.Len(), jumpStack.Len())
+			// Lookups only from here on, walks run concurrently on the same graph
+			current = s.nodeRef[current.pointer]
 			continue // Skip the normal next node selection
 		} else if current.typ == end {
 			if jumpStack.Len() != 0 {
@@ -121,7 +160,8 @@ func (s *syntaxGraph) GraphWalk(prng *prng, start string, tokens int) string {
 				if !ok {
 					break
 				}
-				current = s.GetNode(id, idk)
+				hooks.leave(jumpStack.Len(), &result)
+				current = s.nodeRef[id]
 				continue // Skip the normal next node selection
 			}
 		}
@@ -140,7 +180,8 @@ func (s *syntaxGraph) GraphWalk(prng *prng, start string, tokens int) string {
 			current = nil
 		}
 	}
-	return result.String()
+	stats.tokens = printedTokens
+	return result.String(), stats
 }
 
 // Helper function to handle escape sequences
@@ -9,6 +9,7 @@ type token struct {
 	id   uint32 //Will be generated by the parser
 	typ  tokenType
 	text string //Generated by the Scanner
+	pos  int    //Byte offset of the token in the grammar
 }
 
 type tokenType int8
@@ -32,6 +33,8 @@ const (
 	bracclose   //)
 	colon
 	semicolon
+	eof     //Past the last token
+	comment //'//' to the end of the line, not passed to the parser
 )
 
 func (t tokenType) String() string {
@@ -70,6 +73,10 @@ func (t tokenType) String() string {
 		return "colon"
 	case semicolon:
 		return "semicolon"
+	case eof:
+		return "eof"
+	case comment:
+		return "comment"
 	default:
 		return fmt.Sprintf("tokenType(%d)", int(t))
 	}
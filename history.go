@@ -0,0 +1,252 @@
+package resrap
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitHistory describes the commit history WithGitHistory generates
+type GitHistory struct {
+	Commits  int           // Number of commits, the first one adds the generated codebase
+	Authors  []string      // "Name <email>" identities commits are spread over, DefaultGitAuthor when empty
+	Start    time.Time     // Time of the first commit, 2020-01-01 UTC when zero
+	Interval time.Duration // Average time between commits, an hour when zero
+	Branch   string        // Branch the history is written on, "main" when empty
+	Seed     uint64        // Drives authors, timestamps and mutations, mixed with WithCodebaseSeed
+}
+
+// DefaultGitAuthor signs the commits of a GitHistory without Authors
+const DefaultGitAuthor = "Resrap <resrap@example.com>"
+
+// WithGitHistory turns the generated codebase into a git repository with a history of h.Commits commits.
+// The first commit adds the generated files, every following one adds, deletes or edits a few files
+// with content generated from their grammar. The work tree holds the last commit.
+// Everything is written through the sink, the git binary is not needed.
+func WithGitHistory(h GitHistory) CodebaseOption {
+	return func(c *codebaseConfig) {
+		c.git = &h
+	}
+}
+
+// historyFile is a file of the work tree while the history is generated
+type historyFile struct {
+	content []byte
+	entry   ManifestEntry //The planned file this one comes from, new content is generated like it
+}
+
+// writeHistory generates the commits on top of the files generate wrote to the capture sink,
+// then writes the last work tree and the repository to the real sink
+func (g *codebaseGen) writeHistory() error {
+	h := *g.cfg.git
+	if h.Commits <= 0 {
+		h.Commits = 1
+	}
+	if len(h.Authors) == 0 {
+		h.Authors = []string{DefaultGitAuthor}
+	}
+	if h.Start.IsZero() {
+		h.Start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if h.Interval <= 0 {
+		h.Interval = time.Hour
+	}
+	if h.Branch == "" {
+		h.Branch = "main"
+	}
+	seed := h.Seed ^ g.cfg.seed
+
+	captured := g.capture.FS()
+	tree := make(map[string]*historyFile, len(g.files))
+	for _, f := range g.files {
+		tree[f.Path] = &historyFile{content: captured[g.sinkPath(f.Path)].Data, entry: f}
+	}
+
+	repo := newGitRepo(g.out, g.sinkPath("."))
+	if err := repo.init(h.Branch); err != nil {
+		return fmt.Errorf("failed to create git repository: %w", err)
+	}
+	when := h.Start
+	parent := ""
+	for k := 0; k < h.Commits; k++ {
+		prng := newPRNG(g.r.source, BatchSeed(seed, k))
+		message := "Initial commit"
+		if k > 0 {
+			when = when.Add(h.Interval/2 + time.Duration(prng.RandomInt(0, int(h.Interval))))
+			var err error
+			if message, err = g.mutate(tree, k, &prng); err != nil {
+				return err
+			}
+			if message == "" {
+				continue //Nothing to change without files, no empty commits
+			}
+		}
+		files := make(map[string][]byte, len(tree))
+		for p, f := range tree {
+			files[p] = f.content
+		}
+		root, err := repo.tree(files)
+		if err != nil {
+			return fmt.Errorf("failed to write git tree: %w", err)
+		}
+		author := h.Authors[prng.RandomInt(0, len(h.Authors))]
+		if parent, err = repo.commit(root, parent, author, when, message); err != nil {
+			return fmt.Errorf("failed to write git commit: %w", err)
+		}
+		if g.cfg.logger != nil {
+			g.cfg.logger.Info("created commit", slog.String("hash", parent), slog.String("message", message))
+		}
+	}
+	if err := repo.setBranch(h.Branch, parent); err != nil {
+		return fmt.Errorf("failed to write git branch: %w", err)
+	}
+	g.summary.Head = parent
+
+	// Check out the last commit
+	for _, dir := range g.dirs {
+		if err := g.out.MkdirAll(g.sinkPath(dir)); err != nil {
+			return fmt.Errorf("failed to create directory '%s': %w", g.sinkPath(dir), err)
+		}
+	}
+	files := make(map[string][]byte, len(tree))
+	g.summary.Files, g.summary.Bytes = 0, 0
+	for _, p := range sortedPaths(tree) {
+		loc := g.sinkPath(p)
+		if err := g.out.MkdirAll(path.Dir(loc)); err != nil {
+			return fmt.Errorf("failed to create directory '%s': %w", path.Dir(loc), err)
+		}
+		if err := g.out.WriteFile(loc, tree[p].content); err != nil {
+			return fmt.Errorf("failed to write file '%s': %w", loc, err)
+		}
+		files[p] = tree[p].content
+		g.summary.Files++
+		g.summary.Bytes += int64(len(tree[p].content))
+	}
+	if err := repo.index(files); err != nil {
+		return fmt.Errorf("failed to write git index: %w", err)
+	}
+	return nil
+}
+
+// mutate applies the changes of commit k to tree and returns the commit message, "" when tree is
+// empty and there is nothing to change. Each commit makes one to three changes: an edit (60%),
+// an added file (25%) or a deleted file (15%).
+func (g *codebaseGen) mutate(tree map[string]*historyFile, k int, prng *prng) (string, error) {
+	var changes []string
+	touched := make(map[string]bool)
+	for n := prng.RandomInt(1, 4); n > 0 && len(tree) > 0; n-- {
+		paths := sortedPaths(tree)
+		target := paths[prng.RandomInt(0, len(paths))]
+		f := tree[target]
+		roll := prng.Random()
+		if touched[target] {
+			continue //A file changes at most once per commit
+		}
+		touched[target] = true
+		switch {
+		case roll < 0.15 && len(tree) > 1:
+			delete(tree, target)
+			changes = append(changes, "Delete "+target)
+		case roll < 0.40:
+			// A new file next to target, from the same grammar
+			entry := f.entry
+			entry.Seed = prng.src.Uint64()
+			res := g.generateOne(entry)
+			if res.err != nil {
+				return "", fmt.Errorf("failed to generate commit %d: %w", k+1, res.err)
+			}
+			added := newHistoryPath(tree, f.entry.Path, k)
+			tree[added] = &historyFile{content: []byte(res.content), entry: entry}
+			if entry.NamePool != "" {
+				g.pools.add(entry.NamePool, path.Base(added))
+			}
+			touched[added] = true
+			g.summary.Tokens += res.tokens
+			changes = append(changes, "Add "+added)
+		default:
+			// Replace up to three lines at a random place with a short generated fragment
+			fragment := f.entry
+			fragment.Seed = prng.src.Uint64()
+			fragment.Tokens = max(f.entry.Tokens/8, 4)
+			res := g.generateOne(fragment)
+			if res.err != nil {
+				return "", fmt.Errorf("failed to generate commit %d: %w", k+1, res.err)
+			}
+			text := res.content
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			lines := strings.SplitAfter(string(f.content), "\n")
+			if lines[len(lines)-1] == "" {
+				lines = lines[:len(lines)-1]
+			}
+			at := prng.RandomInt(0, len(lines)+1)
+			end := min(at+prng.RandomInt(0, 4), len(lines))
+			edited := strings.Join(lines[:at], "") + text + strings.Join(lines[end:], "")
+			tree[target] = &historyFile{content: []byte(edited), entry: f.entry}
+			g.summary.Tokens += res.tokens
+			changes = append(changes, "Edit "+target)
+		}
+	}
+	switch len(changes) {
+	case 0:
+		return "", nil
+	case 1:
+		return changes[0], nil
+	}
+	return fmt.Sprintf("Update %d files\n\n%s", len(changes), strings.Join(changes, "\n")), nil
+}
+
+// generateOne generates a single file outside of the planned ones, on the pool when one is set.
+// It imports symbols from every file generated so far and adds the symbols it exports to the pools.
+func (g *codebaseGen) generateOne(f ManifestEntry) generatedFile {
+	var hooks *walkHooks
+	if l := g.graphs()[f.Grammar]; l.graph != nil {
+		hooks = newWalkHooks(l.graph, f, g.pools.snapshot())
+	}
+	var res generatedFile
+	if g.cfg.pool == nil {
+		res = g.generateFile(f, hooks)
+	} else {
+		out := make(chan generatedFile, 1)
+		g.generateOnPool(f, hooks, out)
+		res = <-out
+	}
+	for _, link := range f.Exports {
+		_, pool, _ := parseSymbolLink(link)
+		for _, symbol := range res.symbols[pool] {
+			g.pools.add(pool, symbol)
+		}
+	}
+	return res
+}
+
+// newHistoryPath names a file added in commit k next to a planned one, keeping its extension
+func newHistoryPath(tree map[string]*historyFile, planned string, k int) string {
+	dir, name := path.Split(planned)
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		suffix := "_" + strconv.Itoa(k)
+		if i > 0 {
+			suffix += "_" + strconv.Itoa(i)
+		}
+		p := dir + stem + suffix + ext
+		if tree[p] == nil {
+			return p
+		}
+	}
+}
+
+func sortedPaths(tree map[string]*historyFile) []string {
+	paths := make([]string, 0, len(tree))
+	for p := range tree {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,246 @@
+package resrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (t nodeType) String() string {
+	switch t {
+	case start:
+		return "start"
+	case header:
+		return "rule"
+	case jump:
+		return "jump"
+	case end:
+		return "end"
+	case ch:
+		return "literal"
+	case rx:
+		return "regex"
+	case pointer:
+		return "call"
+	default:
+		return "node"
+	}
+}
+
+// GrammarGraph is the syntax graph of a grammar, as exported by ExportGraph
+type GrammarGraph struct {
+	Grammar string            `json:"grammar"`
+	Rule    string            `json:"rule,omitempty"` // Rule the graph is limited to, empty for the whole grammar
+	Rules   map[string]uint32 `json:"rules"`          // Rule name -> id of its rule node
+	Nodes   []GraphNode       `json:"nodes"`
+}
+
+// GraphNode is a node of a GrammarGraph
+type GraphNode struct {
+	ID   uint32      `json:"id"`
+	Type string      `json:"type"`           // start, rule, call, literal, regex, jump or end
+	Text string      `json:"text,omitempty"` // The literal, the regex class, or the rule name of rule and call nodes
+	Next []GraphEdge `json:"next,omitempty"`
+}
+
+// GraphEdge is a possible next step of a walk, Probability being normalized over the node's edges
+type GraphEdge struct {
+	To          uint32  `json:"to"`
+	Probability float32 `json:"p"`
+}
+
+// Grammars lists the names of the loaded grammars, sorted
+func (r *Resrap) Grammars() []string {
+	names := make([]string, 0, len(r.languageGraph))
+	for name := range r.languageGraph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Rules lists the rules defined in grammar 'name', sorted
+func (r *Resrap) Rules(name string) ([]string, error) {
+	graph, err := r.graph(name)
+	if err != nil {
+		return nil, err
+	}
+	return graph.ruleNames(), nil
+}
+
+// graph returns the syntax graph of a loaded grammar
+func (r *Resrap) graph(name string) (*syntaxGraph, error) {
+	l, ok := r.languageGraph[name]
+	if !ok || l.graph == nil {
+		return nil, fmt.Errorf("grammar '%s' not found", name)
+	}
+	return l.graph, nil
+}
+
+func (s *syntaxGraph) ruleNames() []string {
+	names := make([]string, 0, len(s.namemap))
+	for name := range s.namemap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rules lists the rules of a loaded grammar, none when it couldn't be read
+func (l lang) rules() []string {
+	if l.graph == nil {
+		return []string{}
+	}
+	return l.graph.ruleNames()
+}
+
+// ruleOf maps rule node ids back to rule names
+func (s *syntaxGraph) ruleOf() map[uint32]string {
+	rev := make(map[uint32]string, len(s.namemap))
+	for name, id := range s.namemap {
+		rev[id] = name
+	}
+	return rev
+}
+
+// probabilities returns the normalized probability of each edge of node, from its cumulative frequencies
+func (n *syntaxNode) probabilities() []float32 {
+	p := make([]float32, len(n.next))
+	prev := float32(0)
+	for i := range n.next {
+		if i < len(n.cf) {
+			p[i] = n.cf[i] - prev
+			prev = n.cf[i]
+		}
+	}
+	return p
+}
+
+// ruleNodes lists the ids of the nodes making up rule, without following calls into other rules
+func (s *syntaxGraph) ruleNodes(rule string) ([]uint32, error) {
+	root, ok := s.namemap[rule]
+	if !ok {
+		return nil, fmt.Errorf("rule '%s' not found", rule)
+	}
+	seen := map[uint32]bool{root: true}
+	queue := []uint32{root}
+	for len(queue) > 0 {
+		node := s.nodeRef[queue[0]]
+		queue = queue[1:]
+		if node == nil || node.id == uint32(end) {
+			continue //The shared end node leads back into other rules
+		}
+		for _, next := range node.next {
+			if !seen[next.node.id] {
+				seen[next.node.id] = true
+				queue = append(queue, next.node.id)
+			}
+		}
+	}
+	ids := make([]uint32, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// ExportGraph returns the syntax graph of grammar 'name', limited to the nodes of rule when it is not empty
+func (r *Resrap) ExportGraph(name, rule string) (GrammarGraph, error) {
+	graph, err := r.graph(name)
+	if err != nil {
+		return GrammarGraph{}, err
+	}
+	var ids []uint32
+	if rule != "" {
+		if ids, err = graph.ruleNodes(rule); err != nil {
+			return GrammarGraph{}, fmt.Errorf("%w in grammar '%s'", err, name)
+		}
+	} else {
+		for id := range graph.nodeRef {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+
+	out := GrammarGraph{Grammar: name, Rule: rule, Rules: make(map[string]uint32)}
+	for rname, id := range graph.namemap {
+		out.Rules[rname] = id
+	}
+	rules := graph.ruleOf()
+	for _, id := range ids {
+		node := graph.nodeRef[id]
+		if node == nil {
+			continue
+		}
+		gn := GraphNode{ID: id, Type: node.typ.String()}
+		switch node.typ {
+		case ch, rx:
+			gn.Text = graph.charmap[id]
+		case pointer:
+			gn.Text = rules[node.pointer]
+		case header:
+			gn.Text = rules[id]
+		}
+		p := node.probabilities()
+		for i, next := range node.next {
+			if rule != "" && id == uint32(end) {
+				break //Edges of the shared end node belong to other rules
+			}
+			gn.Next = append(gn.Next, GraphEdge{To: next.node.id, Probability: p[i]})
+		}
+		out.Nodes = append(out.Nodes, gn)
+	}
+	return out, nil
+}
+
+// ExportGraphJSON writes the graph returned by ExportGraph to w as indented JSON
+func (r *Resrap) ExportGraphJSON(w io.Writer, name, rule string) error {
+	graph, err := r.ExportGraph(name, rule)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(graph)
+}
+
+// ExportDOT writes the graph returned by ExportGraph to w in Graphviz DOT format
+func (r *Resrap) ExportDOT(w io.Writer, name, rule string) error {
+	graph, err := r.ExportGraph(name, rule)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("digraph " + strconv.Quote(name) + " {\n")
+	b.WriteString("  node [fontname=\"monospace\"];\n")
+	for _, n := range graph.Nodes {
+		var label, shape string
+		switch n.Type {
+		case "rule":
+			label, shape = n.Text, "box"
+		case "call":
+			label, shape = n.Text, "box, style=rounded"
+		case "literal":
+			label, shape = "'"+n.Text+"'", "ellipse"
+		case "regex":
+			label, shape = "["+n.Text+"]", "ellipse"
+		case "jump":
+			label, shape = "", "point"
+		default:
+			label, shape = n.Type, "diamond"
+		}
+		fmt.Fprintf(&b, "  n%d [label=%s, shape=%s];\n", n.ID, strconv.Quote(label), shape)
+	}
+	for _, n := range graph.Nodes {
+		for _, e := range n.Next {
+			fmt.Fprintf(&b, "  n%d -> n%d [label=\"%.2f\"];\n", n.ID, e.To, e.Probability)
+		}
+	}
+	b.WriteString("}\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
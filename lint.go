@@ -0,0 +1,271 @@
+package resrap
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Severity tells errors, which make a grammar unusable, from warnings
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// MarshalText encodes s as "error" or "warning"
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic is a problem found in a grammar
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule,omitempty"` // Rule the problem is about, empty when it isn't tied to one
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Rule != "" {
+		return fmt.Sprintf("%v: rule '%s': %s", d.Severity, d.Rule, d.Message)
+	}
+	return fmt.Sprintf("%v: %s", d.Severity, d.Message)
+}
+
+// Lint checks grammar 'name' for problems, starting_node being the rule generation starts from.
+// Errors are the scanner, parser and validation errors the grammar was loaded with, a missing
+// starting node and rules that can neither complete nor generate a token, which generation never
+// returns from; warnings are rules never used from starting_node, rules that can never complete,
+// whose output is always cut by the token limit, and what was lost converting the grammar from another format.
+func (r *Resrap) Lint(name, starting_node string) []Diagnostic {
+	l, ok := r.languageGraph[name]
+	return lintLang(name, l, ok, starting_node)
+}
+
+// lintLang lints grammar 'name', found telling whether it was loaded at all
+func lintLang(name string, l lang, found bool, starting_node string) []Diagnostic {
+	if !found || l.graph == nil {
+		msg := fmt.Sprintf("grammar '%s' not found", name)
+		if l.err != nil {
+			msg = l.err.Error()
+		}
+		return []Diagnostic{{Severity: SeverityError, Message: msg}}
+	}
+	diags := append([]Diagnostic(nil), l.warnings...)
+	if l.err != nil {
+		for _, err := range unjoin(l.err) {
+			diags = append(diags, Diagnostic{Severity: SeverityError, Message: err.Error()})
+		}
+		return diags
+	}
+	if _, ok := l.graph.namemap[starting_node]; !ok {
+		diags = append(diags, Diagnostic{Severity: SeverityError, Rule: starting_node, Message: "starting node not found"})
+	} else {
+		used := l.graph.reachableRules(starting_node)
+		for _, rule := range l.graph.ruleNames() {
+			if !used[rule] {
+				diags = append(diags, Diagnostic{Severity: SeverityWarning, Rule: rule,
+					Message: fmt.Sprintf("never used from '%s'", starting_node)})
+			}
+		}
+	}
+	lengths := l.graph.minTokens()
+	endless := l.graph.endlessRules()
+	for _, rule := range l.graph.ruleNames() {
+		if endless[rule] {
+			diags = append(diags, Diagnostic{Severity: SeverityError, Rule: rule, Message: neverEndsMessage})
+		} else if _, ok := lengths[rule]; !ok {
+			diags = append(diags, Diagnostic{Severity: SeverityWarning, Rule: rule,
+				Message: "can never complete, its output is always cut by the token limit"})
+		}
+	}
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Severity < diags[j].Severity })
+	return diags
+}
+
+// neverEndsMessage is the error of rules that can neither complete nor generate a token
+const neverEndsMessage = "can never complete nor generate a token, generating it would never end"
+
+// endlessRules returns the rules that can neither complete nor generate a token
+func (s *syntaxGraph) endlessRules() map[string]bool {
+	lengths, printing := s.minTokens(), s.printingRules()
+	endless := map[string]bool{}
+	for name := range s.namemap {
+		if _, ok := lengths[name]; !ok && !printing[name] {
+			endless[name] = true
+		}
+	}
+	return endless
+}
+
+// printingRules returns the rules a walk can generate a token from, directly or through the rules they call.
+// The token limit stops the others only when they complete.
+func (s *syntaxGraph) printingRules() map[string]bool {
+	rules := s.ruleOf()
+	nodes := make(map[string][]uint32, len(s.namemap))
+	for name := range s.namemap {
+		nodes[name], _ = s.ruleNodes(name)
+	}
+	printing := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for name, ids := range nodes {
+			if printing[name] {
+				continue
+			}
+			for _, id := range ids {
+				node := s.nodeRef[id]
+				if node != nil && (node.typ == ch || node.typ == pointer && printing[rules[node.pointer]]) {
+					printing[name], changed = true, true
+					break
+				}
+			}
+		}
+	}
+	return printing
+}
+
+// MinTokens returns the fewest tokens each rule of grammar 'name' can complete with.
+// Rules that can never complete are left out.
+func (r *Resrap) MinTokens(name string) (map[string]int, error) {
+	graph, err := r.graph(name)
+	if err != nil {
+		return nil, err
+	}
+	return graph.minTokens(), nil
+}
+
+// unjoin splits an error built with errors.Join back into its parts
+func unjoin(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var all []error
+		for _, e := range joined.Unwrap() {
+			all = append(all, unjoin(e)...)
+		}
+		return all
+	}
+	var parts []error
+	for _, line := range strings.Split(err.Error(), "\n") {
+		parts = append(parts, errors.New(line))
+	}
+	return parts
+}
+
+// reachableRules returns the rules a walk from rule can reach, rule included
+func (s *syntaxGraph) reachableRules(rule string) map[string]bool {
+	rules := s.ruleOf()
+	used := map[string]bool{rule: true}
+	queue := []string{rule}
+	for len(queue) > 0 {
+		ids, _ := s.ruleNodes(queue[0])
+		queue = queue[1:]
+		for _, id := range ids {
+			if node := s.nodeRef[id]; node != nil && node.typ == pointer {
+				if name := rules[node.pointer]; !used[name] {
+					used[name] = true
+					queue = append(queue, name)
+				}
+			}
+		}
+	}
+	return used
+}
+
+// minTokens computes the fewest tokens each rule can complete with, by shortest paths from each rule node
+// to the end node where literals cost a token and calls cost the length of the called rule.
+// Lengths are refined until no rule improves; rules that can't complete are left out.
+func (s *syntaxGraph) minTokens() map[string]int {
+	lengths := make(map[uint32]int)
+	for changed := true; changed; {
+		changed = false
+		for _, id := range s.namemap {
+			if d, ok := s.shortestCompletion(id, lengths); ok {
+				if old, known := lengths[id]; !known || d < old {
+					lengths[id] = d
+					changed = true
+				}
+			}
+		}
+	}
+	out := make(map[string]int, len(lengths))
+	for name, id := range s.namemap {
+		if d, ok := lengths[id]; ok {
+			out[name] = d
+		}
+	}
+	return out
+}
+
+// shortestCompletion runs Dijkstra from a rule node, calls into rules of unknown length are not taken
+func (s *syntaxGraph) shortestCompletion(root uint32, lengths map[uint32]int) (int, bool) {
+	dist := map[uint32]int{root: 0}
+	queue := &distQueue{{id: root, dist: 0}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(distItem)
+		if item.dist > dist[item.id] {
+			continue
+		}
+		node := s.nodeRef[item.id]
+		if node == nil {
+			continue
+		}
+		if node.id == uint32(end) || len(node.next) == 0 {
+			return item.dist, true //Back to the caller, or the end of the whole walk
+		}
+		cost := 0
+		next := node.next
+		switch node.typ {
+		case ch:
+			cost = 1
+		case pointer:
+			called, ok := lengths[node.pointer]
+			if !ok {
+				continue
+			}
+			cost = called
+			next = node.next[:1] //Calls continue at their jump node
+		}
+		p := node.probabilities()
+		for i, edge := range next {
+			if p[i] <= 0 && node.typ != pointer {
+				continue //Never taken by a walk
+			}
+			d := item.dist + cost
+			if old, seen := dist[edge.node.id]; !seen || d < old {
+				dist[edge.node.id] = d
+				heap.Push(queue, distItem{id: edge.node.id, dist: d})
+			}
+		}
+	}
+	return math.MaxInt, false
+}
+
+type distItem struct {
+	id   uint32
+	dist int
+}
+
+// distQueue is a min heap of distItem by dist
+type distQueue []distItem
+
+func (q distQueue) Len() int           { return len(q) }
+func (q distQueue) Less(i, j int) bool { return q[i].dist < q[j].dist }
+func (q distQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *distQueue) Push(x any)        { *q = append(*q, x.(distItem)) }
+func (q *distQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,600 @@
+package resrap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ServeLSP runs a language server for grammar files, reading JSON-RPC messages from in and
+// writing to out until the client sends exit. It offers diagnostics, go to definition, find
+// references, rename, hover with the probabilities of a rule and its shortest derivation, and a
+// code lens generating a sample from each rule. Documents are only read from the client.
+func ServeLSP(in io.Reader, out io.Writer) error {
+	s := &lspServer{in: bufio.NewReader(in), out: out, docs: map[string]*lspDocument{}}
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// lspSampleCommand generates a sample from a rule, its arguments are the document URI and the rule
+const lspSampleCommand = "resrap.generateSample"
+
+// lspSampleTokens is the token limit of the samples of the code lens
+const lspSampleTokens = 100
+
+// lspSampleTimeout bounds the time a sample may take
+var lspSampleTimeout = 2 * time.Second
+
+// JSON-RPC error codes
+const (
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcRequestFailed  = -32803
+)
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"` //Absent for notifications
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+}
+
+type rpcErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   rpcError        `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// LSP structures, positions count UTF-16 code units like the protocol
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"` //1 error, 2 warning
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCommand struct {
+	Title     string `json:"title"`
+	Command   string `json:"command"`
+	Arguments []any  `json:"arguments,omitempty"`
+}
+
+type lspCodeLens struct {
+	Range   lspRange    `json:"range"`
+	Command *lspCommand `json:"command,omitempty"`
+}
+
+type lspMarkup struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspHover struct {
+	Contents lspMarkup `json:"contents"`
+	Range    lspRange  `json:"range"`
+}
+
+// lspParams holds the parameters of every request and notification handled, each using its own
+type lspParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position lspPosition `json:"position"`
+	Context  struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+	NewName   string            `json:"newName"`
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments"`
+}
+
+type lspServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*lspDocument
+	shutdown bool
+}
+
+// lspDocument is an open grammar with what was read from it
+type lspDocument struct {
+	uri    string
+	text   string
+	lines  []int   //Offset of the start of each line
+	tokens []token //Scanner tokens, nil when the grammar doesn't scan
+	diags  []lspDiagnostic
+	lang   lang //Loaded grammar, its err set when it doesn't load
+}
+
+// read reads a message framed by a Content-Length header
+func (s *lspServer) read() (*rpcMessage, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length '%s'", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	var msg rpcMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	return &msg, nil
+}
+
+func (s *lspServer) write(v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *lspServer) notify(method string, params any) error {
+	return s.write(rpcNotification{"2.0", method, params})
+}
+
+// handle answers a request, or acts on a notification
+func (s *lspServer) handle(msg *rpcMessage) error {
+	var params lspParams
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil && msg.ID != nil {
+			return s.write(rpcErrorResponse{"2.0", msg.ID, rpcError{rpcInvalidParams, err.Error()}})
+		}
+	}
+	if msg.ID == nil {
+		return s.notification(msg.Method, &params)
+	}
+
+	var result any
+	var err error
+	if s.shutdown {
+		err = &rpcError{rpcInvalidRequest, "server is shut down"}
+	} else {
+		result, err = s.request(msg.Method, &params)
+	}
+	if err != nil {
+		rerr, ok := err.(*rpcError)
+		if !ok {
+			rerr = &rpcError{rpcRequestFailed, err.Error()}
+		}
+		return s.write(rpcErrorResponse{"2.0", msg.ID, *rerr})
+	}
+	return s.write(rpcResponse{"2.0", msg.ID, result})
+}
+
+func (s *lspServer) notification(method string, p *lspParams) error {
+	switch method {
+	case "textDocument/didOpen":
+		return s.update(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		if n := len(p.ContentChanges); n > 0 {
+			return s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text) //Full sync, the last change is the document
+		}
+	case "textDocument/didClose":
+		delete(s.docs, p.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", map[string]any{"uri": p.TextDocument.URI, "diagnostics": []lspDiagnostic{}})
+	}
+	return nil //initialized, didSave and the others need nothing
+}
+
+func (s *lspServer) request(method string, p *lspParams) (any, error) {
+	if method == "initialize" {
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"renameProvider":         true,
+				"hoverProvider":          true,
+				"codeLensProvider":       map[string]any{"resolveProvider": false},
+				"executeCommandProvider": map[string]any{"commands": []string{lspSampleCommand}},
+			},
+			"serverInfo": map[string]any{"name": "resrap"},
+		}, nil
+	}
+	if method == "shutdown" {
+		s.shutdown = true
+		return nil, nil
+	}
+	if method == "workspace/executeCommand" {
+		return s.executeCommand(p)
+	}
+
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		if strings.HasPrefix(method, "textDocument/") {
+			return nil, nil //Not open, nothing known about it
+		}
+		return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("method '%s' not supported", method)}
+	}
+	switch method {
+	case "textDocument/definition":
+		return doc.definition(p.Position), nil
+	case "textDocument/references":
+		return doc.references(p.Position, p.Context.IncludeDeclaration), nil
+	case "textDocument/rename":
+		return doc.rename(p.Position, p.NewName)
+	case "textDocument/hover":
+		return doc.hover(p.Position), nil
+	case "textDocument/codeLens":
+		return doc.codeLenses(), nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("method '%s' not supported", method)}
+}
+
+// update reads a new version of a document and publishes its diagnostics
+func (s *lspServer) update(uri, text string) error {
+	doc := newLSPDocument(uri, text)
+	s.docs[uri] = doc
+	return s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": doc.diags})
+}
+
+// executeCommand runs the code lens command, showing the sample and returning it
+func (s *lspServer) executeCommand(p *lspParams) (any, error) {
+	if p.Command != lspSampleCommand {
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown command '%s'", p.Command)}
+	}
+	var uri, rule string
+	if len(p.Arguments) < 2 || json.Unmarshal(p.Arguments[0], &uri) != nil || json.Unmarshal(p.Arguments[1], &rule) != nil {
+		return nil, &rpcError{rpcInvalidParams, "expected the document URI and a rule"}
+	}
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, fmt.Errorf("document '%s' is not open", uri)
+	}
+	if doc.lang.err != nil {
+		return nil, fmt.Errorf("grammar has errors: %v", doc.lang.err)
+	}
+	// Samples run on the message loop, a rule whose generation never ends must not hang the server
+	ctx, cancel := context.WithTimeout(context.Background(), lspSampleTimeout)
+	defer cancel()
+	prng := randomPRNG(nil)
+	job := codeGenReq{name: uri, startnode: rule, tokens: lspSampleTokens, seed: prng.seed, id: uri, ctx: ctx}
+	sample, _, err := observedWalk(map[string]lang{uri: doc.lang}, NoopMetrics{}, nil, job, &prng)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("no sample of '%s' within %v, its generation may never end", rule, lspSampleTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = s.notify("window/showMessage", map[string]any{
+		"type":    3, //Info
+		"message": fmt.Sprintf("Sample of '%s' (seed %d):\n%s", rule, prng.seed, sample),
+	})
+	return sample, err
+}
+
+func newLSPDocument(uri, text string) *lspDocument {
+	d := &lspDocument{uri: uri, text: text, lines: []int{0}, diags: []lspDiagnostic{}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	sc := scanner{Input: text}
+	tokens, scanErrs := sc.scan()
+	for _, e := range scanErrs {
+		d.addDiag(e.Pos, e.Pos+1, 1, e.Msg)
+	}
+	d.lang = newLang()
+	gb := newGraphBuilder()
+	d.lang.err = gb.start_generation(text)
+	d.lang.graph, d.lang.source = &gb.pars.graph, text
+	if d.lang.err == nil {
+		d.lang.graph.Normalize()
+	}
+	if len(scanErrs) > 0 {
+		return d
+	}
+	d.tokens = tokens
+	for i, err := range gb.pars.errors {
+		pos := gb.pars.errorPos[i]
+		d.addDiag(pos, pos+d.tokenLen(pos), 1, err.Error())
+	}
+	for _, err := range gb.pars.ValidateGraph() {
+		var undefined undefinedRuleError
+		if !errors.As(err, &undefined) {
+			d.addDiag(0, 0, 1, err.Error())
+			continue
+		}
+		for _, ref := range d.occurrences(undefined.name) {
+			d.addDiag(ref.pos, ref.pos+len(ref.text), 1, err.Error())
+		}
+	}
+	if d.lang.err == nil {
+		endless := d.lang.graph.endlessRules()
+		for i, t := range d.tokens {
+			if t.typ == identifier && endless[t.text] && d.isDefinition(i) {
+				d.addDiag(t.pos, t.pos+len(t.text), 1, neverEndsMessage)
+			}
+		}
+	}
+	sort.SliceStable(d.diags, func(i, j int) bool {
+		a, b := d.diags[i].Range.Start, d.diags[j].Range.Start
+		return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+	})
+	return d
+}
+
+func (d *lspDocument) addDiag(from, to, severity int, msg string) {
+	d.diags = append(d.diags, lspDiagnostic{Range: d.rangeOf(from, to), Severity: severity, Source: "resrap", Message: msg})
+}
+
+// tokenLen returns the length of the token at pos, 1 when there is none
+func (d *lspDocument) tokenLen(pos int) int {
+	for _, t := range d.tokens {
+		if t.pos == pos && t.typ == identifier {
+			return len(t.text)
+		}
+	}
+	return 1
+}
+
+// position converts a byte offset to a line and a UTF-16 column
+func (d *lspDocument) position(offset int) lspPosition {
+	offset = min(max(offset, 0), len(d.text))
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	col := len(utf16.Encode([]rune(d.text[d.lines[line]:offset])))
+	return lspPosition{line, col}
+}
+
+// offset converts a line and a UTF-16 column to a byte offset
+func (d *lspDocument) offset(p lspPosition) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset, col := d.lines[p.Line], 0
+	for offset < len(d.text) && d.text[offset] != '\n' && col < p.Character {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		col += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+func (d *lspDocument) rangeOf(from, to int) lspRange {
+	return lspRange{d.position(from), d.position(to)}
+}
+
+// identifierAt returns the rule name under the cursor
+func (d *lspDocument) identifierAt(p lspPosition) (token, bool) {
+	offset := d.offset(p)
+	for _, t := range d.tokens {
+		if t.typ == identifier && t.pos <= offset && offset <= t.pos+len(t.text) {
+			return t, true
+		}
+	}
+	return token{}, false
+}
+
+// isDefinition reports whether the identifier at index i of the tokens names the rule it defines
+func (d *lspDocument) isDefinition(i int) bool {
+	return i+1 < len(d.tokens) && d.tokens[i+1].typ == colon
+}
+
+// occurrences lists the identifiers naming rule, definitions included
+func (d *lspDocument) occurrences(rule string) []token {
+	var found []token
+	for _, t := range d.tokens {
+		if t.typ == identifier && t.text == rule {
+			found = append(found, t)
+		}
+	}
+	return found
+}
+
+// definitions lists the identifiers defining rule, more than one being an error
+func (d *lspDocument) definitions(rule string) []token {
+	var found []token
+	for i, t := range d.tokens {
+		if t.typ == identifier && t.text == rule && d.isDefinition(i) {
+			found = append(found, t)
+		}
+	}
+	return found
+}
+
+func (d *lspDocument) location(t token) lspLocation {
+	return lspLocation{d.uri, d.rangeOf(t.pos, t.pos+len(t.text))}
+}
+
+func (d *lspDocument) definition(p lspPosition) []lspLocation {
+	locations := []lspLocation{}
+	if t, ok := d.identifierAt(p); ok {
+		for _, def := range d.definitions(t.text) {
+			locations = append(locations, d.location(def))
+		}
+	}
+	return locations
+}
+
+func (d *lspDocument) references(p lspPosition, withDefinition bool) []lspLocation {
+	locations := []lspLocation{}
+	t, ok := d.identifierAt(p)
+	if !ok {
+		return locations
+	}
+	for i, ref := range d.tokens {
+		if ref.typ == identifier && ref.text == t.text && (withDefinition || !d.isDefinition(i)) {
+			locations = append(locations, d.location(ref))
+		}
+	}
+	return locations
+}
+
+func (d *lspDocument) rename(p lspPosition, newName string) (any, error) {
+	t, ok := d.identifierAt(p)
+	if !ok {
+		return nil, &rpcError{rpcRequestFailed, "no rule name here"}
+	}
+	if r, _ := utf8.DecodeRuneInString(newName); !isIdentifier(newName) || !isIdentStart(r) {
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("'%s' is not a valid rule name", newName)}
+	}
+	if newName != t.text && len(d.occurrences(newName)) > 0 {
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("rule '%s' already exists", newName)}
+	}
+	edits := []lspTextEdit{}
+	for _, ref := range d.occurrences(t.text) {
+		edits = append(edits, lspTextEdit{d.rangeOf(ref.pos, ref.pos+len(ref.text)), newName})
+	}
+	return map[string]any{"changes": map[string][]lspTextEdit{d.uri: edits}}, nil
+}
+
+// hover describes the rule under the cursor: the normalized probability of each of its
+// alternatives and the fewest tokens it completes with
+func (d *lspDocument) hover(p lspPosition) *lspHover {
+	t, ok := d.identifierAt(p)
+	if !ok {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s**\n\n", t.text)
+	if d.lang.err != nil {
+		b.WriteString("Fix the errors of the grammar to see its probabilities.")
+		return &lspHover{lspMarkup{"markdown", b.String()}, d.rangeOf(t.pos, t.pos+len(t.text))}
+	}
+	if n, ok := d.lang.graph.minTokens()[t.text]; ok {
+		fmt.Fprintf(&b, "Shortest derivation: %d tokens\n\n", n)
+	} else {
+		b.WriteString("Can never complete, its output is always cut by the token limit\n\n")
+	}
+	b.WriteString(d.alternatives(t.text))
+	return &lspHover{lspMarkup{"markdown", b.String()}, d.rangeOf(t.pos, t.pos+len(t.text))}
+}
+
+// alternatives lists the alternatives of rule with the chance each is picked
+func (d *lspDocument) alternatives(rule string) string {
+	tree, _, err := readGrammarTree(d.text)
+	if err != nil {
+		return ""
+	}
+	var alts []*fmtAlt
+	for _, r := range tree.rules {
+		if r.name == rule {
+			alts = r.alts
+		}
+	}
+	root := d.lang.graph.nodeRef[d.lang.graph.namemap[rule]]
+	if len(alts) < 2 || root == nil {
+		return ""
+	}
+	probs := root.probabilities()
+	var b strings.Builder
+	b.WriteString("| Alternative | Probability |\n| --- | --- |\n")
+	for i, alt := range alts {
+		chance := "-"
+		if len(probs) == len(alts) { //One edge per alternative, they are in order
+			chance = strconv.FormatFloat(float64(probs[i])*100, 'f', 1, 64) + "%"
+		}
+		text := alt.text()
+		if text == "" {
+			text = "*(empty)*"
+		} else {
+			text = "`" + strings.ReplaceAll(text, "|", `\|`) + "`"
+		}
+		fmt.Fprintf(&b, "| %s | %s |\n", text, chance)
+	}
+	if len(probs) != len(alts) {
+		b.WriteString("\nAlternatives starting with an optional element or a group share their choices, see `resrap graph`.\n")
+	}
+	return b.String()
+}
+
+// codeLenses offers to generate a sample above every rule, once the grammar loads.
+// Rules whose generation would never end get none.
+func (d *lspDocument) codeLenses() []lspCodeLens {
+	lenses := []lspCodeLens{}
+	if d.lang.err != nil {
+		return lenses
+	}
+	endless := d.lang.graph.endlessRules()
+	for i, t := range d.tokens {
+		if t.typ == identifier && d.isDefinition(i) && !endless[t.text] {
+			lenses = append(lenses, lspCodeLens{
+				Range:   d.rangeOf(t.pos, t.pos+len(t.text)),
+				Command: &lspCommand{Title: "Generate sample", Command: lspSampleCommand, Arguments: []any{d.uri, t.text}},
+			})
+		}
+	}
+	return lenses
+}
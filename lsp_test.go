@@ -0,0 +1,414 @@
+package resrap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lspClient drives ServeLSP over in-memory pipes
+type lspClient struct {
+	t     *testing.T
+	in    *io.PipeWriter
+	out   *bufio.Reader
+	done  chan error
+	id    int
+	notes []lspReply //Notifications read while waiting for a response
+}
+
+// lspReply is any message of the server
+type lspReply struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type lspTestDiagnostics struct {
+	URI         string          `json:"uri"`
+	Diagnostics []lspDiagnostic `json:"diagnostics"`
+}
+
+func startLSP(t *testing.T) *lspClient {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &lspClient{t: t, in: inW, out: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		err := ServeLSP(inR, outW)
+		outW.Close()
+		c.done <- err
+	}()
+	t.Cleanup(func() {
+		inW.Close()
+		outR.Close() //Unblocks a server writing to a failed test
+		<-c.done
+	})
+	return c
+}
+
+// writeRaw sends body framed by a Content-Length header
+func (c *lspClient) writeRaw(body string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *lspClient) send(id any, method string, params any) {
+	c.t.Helper()
+	msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+	if id != nil {
+		msg["id"] = id
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.writeRaw(string(body))
+}
+
+// read reads the next message of the server
+func (c *lspClient) read() lspReply {
+	c.t.Helper()
+	header, err := textproto.NewReader(c.out).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("reading header: %v", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatalf("bad Content-Length '%s'", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.out, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var reply lspReply
+	if err := json.Unmarshal(body, &reply); err != nil {
+		c.t.Fatalf("invalid message %s: %v", body, err)
+	}
+	return reply
+}
+
+// request sends a request and decodes its result into result, returning the error of the response
+func (c *lspClient) request(method string, params, result any) *rpcError {
+	c.t.Helper()
+	c.id++
+	c.send(c.id, method, params)
+	for {
+		reply := c.read()
+		if reply.Method != "" {
+			c.notes = append(c.notes, reply)
+			continue
+		}
+		if string(reply.ID) != strconv.Itoa(c.id) {
+			c.t.Fatalf("%s: response to id %s, expected %d", method, reply.ID, c.id)
+		}
+		if reply.Error != nil {
+			return reply.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(reply.Result, result); err != nil {
+				c.t.Fatalf("%s: %v in %s", method, err, reply.Result)
+			}
+		}
+		return nil
+	}
+}
+
+// notified returns the first notification of method read so far
+func (c *lspClient) notified(method string) lspReply {
+	c.t.Helper()
+	for i, n := range c.notes {
+		if n.Method == method {
+			c.notes = append(c.notes[:i], c.notes[i+1:]...)
+			return n
+		}
+	}
+	c.t.Fatalf("no %s notification", method)
+	return lspReply{}
+}
+
+// diagnostics reads the diagnostics the server publishes after a document changes
+func (c *lspClient) diagnostics(uri string) []lspDiagnostic {
+	c.t.Helper()
+	reply := c.read()
+	if reply.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("got %s, expected diagnostics", reply.Method)
+	}
+	var p lspTestDiagnostics
+	if err := json.Unmarshal(reply.Params, &p); err != nil {
+		c.t.Fatal(err)
+	}
+	if p.URI != uri {
+		c.t.Fatalf("diagnostics of %s, expected %s", p.URI, uri)
+	}
+	return p.Diagnostics
+}
+
+func (c *lspClient) open(uri, text string) []lspDiagnostic {
+	c.t.Helper()
+	c.send(nil, "textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "text": text}})
+	return c.diagnostics(uri)
+}
+
+func at(uri string, line, char int) map[string]any {
+	return map[string]any{"textDocument": map[string]any{"uri": uri}, "position": lspPosition{line, char}}
+}
+
+func span(line, from, to int) lspRange {
+	return lspRange{lspPosition{line, from}, lspPosition{line, to}}
+}
+
+func TestLSPFraming(t *testing.T) {
+	c := startLSP(t)
+	var init struct {
+		Capabilities map[string]any
+		ServerInfo   struct{ Name string }
+	}
+	if err := c.request("initialize", map[string]any{}, &init); err != nil {
+		t.Fatal(err)
+	}
+	if init.ServerInfo.Name != "resrap" || init.Capabilities["renameProvider"] != true {
+		t.Errorf("unexpected initialize result %+v", init)
+	}
+
+	// Two messages in one write, then a message with an extra header split across writes
+	open := `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"a","text":"program : 'x' ;\n"}}}`
+	lens := `{"jsonrpc":"2.0","id":"lens","method":"textDocument/codeLens","params":{"textDocument":{"uri":"a"}}}`
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%sContent-Length: %d\r\n\r\n%s", len(open), open, len(lens), lens); err != nil {
+		t.Fatal(err)
+	}
+	if d := c.diagnostics("a"); len(d) != 0 {
+		t.Errorf("unexpected diagnostics %+v", d)
+	}
+	if reply := c.read(); string(reply.ID) != `"lens"` || reply.Error != nil {
+		t.Errorf("unexpected reply %+v to a string id", reply)
+	}
+	hover := `{"jsonrpc":"2.0","id":7,"method":"textDocument/hover","params":{"textDocument":{"uri":"a"},"position":{"line":0,"character":0}}}`
+	for _, part := range []string{"Content-Type: application/vscode-jsonrpc; charset=utf-8\r\n", fmt.Sprintf("Content-Length: %d\r\n\r\n", len(hover)), hover[:20], hover[20:]} {
+		if _, err := io.WriteString(c.in, part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply := c.read(); string(reply.ID) != "7" || !strings.Contains(string(reply.Result), "**program**") {
+		t.Errorf("unexpected hover %+v", reply)
+	}
+
+	if err := c.request("textDocument/unknown", at("closed", 0, 0), nil); err != nil {
+		t.Errorf("request on a closed document failed: %v", err)
+	}
+	if err := c.request("workspace/symbol", map[string]any{}, nil); err == nil || err.Code != rpcMethodNotFound {
+		t.Errorf("unknown method: got %v, expected code %d", err, rpcMethodNotFound)
+	}
+	if err := c.request("shutdown", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.request("textDocument/hover", at("a", 0, 0), nil); err == nil || err.Code != rpcInvalidRequest {
+		t.Errorf("request after shutdown: got %v, expected code %d", err, rpcInvalidRequest)
+	}
+	c.send(nil, "exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("exit: %v", err)
+	}
+	c.done <- nil
+}
+
+func TestLSPFramingErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"missing length": "Content-Type: application/json\r\n\r\n{}",
+		"bad length":     "Content-Length: ten\r\n\r\n{}",
+		"bad json":       "Content-Length: 2\r\n\r\n{]",
+	} {
+		if err := ServeLSP(strings.NewReader(input), io.Discard); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if err := ServeLSP(strings.NewReader(""), io.Discard); err != nil {
+		t.Errorf("end of input: %v", err)
+	}
+}
+
+func TestLSPDiagnostics(t *testing.T) {
+	c := startLSP(t)
+	// The emoji takes two UTF-16 code units, é one
+	got := c.open("undefined", "program : '😀é' missing ;\n")
+	want := []lspDiagnostic{{Range: span(0, 16, 23), Severity: 1, Source: "resrap", Message: "Definition of 'missing' not found"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("undefined rule:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	got = c.open("endless", "program : 'x' spin ;\nspin : spin ;\n")
+	want = []lspDiagnostic{{Range: span(1, 0, 4), Severity: 1, Source: "resrap", Message: neverEndsMessage}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("endless rule:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	c.send(nil, "textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": "endless"},
+		"contentChanges": []map[string]any{{"text": "program : 'x' ;"}, {"text": "program : 'x' spin ;\nspin : 'y' ;\n"}},
+	})
+	if got := c.diagnostics("endless"); len(got) != 0 {
+		t.Errorf("fixed grammar still has %+v", got)
+	}
+
+	got = c.open("unterminated", "program : 'x ;\n")
+	want = []lspDiagnostic{{Range: span(0, 11, 12), Severity: 1, Source: "resrap", Message: `unterminated '\''`}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unterminated literal:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	c.send(nil, "textDocument/didClose", map[string]any{"textDocument": map[string]any{"uri": "undefined"}})
+	if got := c.diagnostics("undefined"); len(got) != 0 {
+		t.Errorf("closed document keeps %+v", got)
+	}
+}
+
+// lspNavGrammar has multi-byte text before the references on its first line
+const lspNavGrammar = "program : '😀é' word ' ' word ;\nword : 'a' | 'b' ;\n"
+
+func TestLSPNavigation(t *testing.T) {
+	c := startLSP(t)
+	if d := c.open("nav", lspNavGrammar); len(d) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", d)
+	}
+	first, second, def := span(0, 16, 20), span(0, 25, 29), span(1, 0, 4)
+
+	var locs []lspLocation
+	for _, p := range []lspPosition{{0, 16}, {0, 18}, {0, 29}, {1, 2}} {
+		if err := c.request("textDocument/definition", at("nav", p.Line, p.Character), &locs); err != nil {
+			t.Fatal(err)
+		}
+		if want := []lspLocation{{"nav", def}}; !reflect.DeepEqual(locs, want) {
+			t.Errorf("definition at %+v: got %+v", p, locs)
+		}
+	}
+	// In the middle of the emoji, no rule name there
+	if err := c.request("textDocument/definition", at("nav", 0, 12), &locs); err != nil || len(locs) != 0 {
+		t.Errorf("definition off a name: got %+v, %v", locs, err)
+	}
+
+	refs := map[string]any{"textDocument": map[string]any{"uri": "nav"}, "position": lspPosition{1, 1}, "context": map[string]any{"includeDeclaration": false}}
+	if err := c.request("textDocument/references", refs, &locs); err != nil {
+		t.Fatal(err)
+	}
+	if want := []lspLocation{{"nav", first}, {"nav", second}}; !reflect.DeepEqual(locs, want) {
+		t.Errorf("references: got %+v", locs)
+	}
+	refs["context"] = map[string]any{"includeDeclaration": true}
+	if err := c.request("textDocument/references", refs, &locs); err != nil {
+		t.Fatal(err)
+	}
+	if want := []lspLocation{{"nav", first}, {"nav", second}, {"nav", def}}; !reflect.DeepEqual(locs, want) {
+		t.Errorf("references with the declaration: got %+v", locs)
+	}
+
+	rename := func(p lspPosition, name string) (map[string][]lspTextEdit, *rpcError) {
+		params := map[string]any{"textDocument": map[string]any{"uri": "nav"}, "position": p, "newName": name}
+		var edit struct{ Changes map[string][]lspTextEdit }
+		err := c.request("textDocument/rename", params, &edit)
+		return edit.Changes, err
+	}
+	changes, err := rename(lspPosition{0, 27}, "letter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]lspTextEdit{"nav": {{first, "letter"}, {second, "letter"}, {def, "letter"}}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("rename: got %+v", changes)
+	}
+	for name, code := range map[string]int{"program": rpcInvalidParams, "1word": rpcInvalidParams, "two words": rpcInvalidParams} {
+		if _, err := rename(lspPosition{1, 0}, name); err == nil || err.Code != code {
+			t.Errorf("rename to '%s': got %v, expected code %d", name, err, code)
+		}
+	}
+	if _, err := rename(lspPosition{0, 8}, "x"); err == nil || err.Code != rpcRequestFailed {
+		t.Errorf("rename off a name: got %v", err)
+	}
+
+	var hover lspHover
+	if err := c.request("textDocument/hover", at("nav", 1, 0), &hover); err != nil {
+		t.Fatal(err)
+	}
+	if v := hover.Contents.Value; !strings.Contains(v, "Shortest derivation: 1 tokens") || !strings.Contains(v, "| `'a'` | 50.0% |") {
+		t.Errorf("hover:\n%s", v)
+	}
+	if hover.Range != def {
+		t.Errorf("hover range %+v", hover.Range)
+	}
+}
+
+func TestLSPCodeLens(t *testing.T) {
+	defer func(d time.Duration) { lspSampleTimeout = d }(lspSampleTimeout)
+	lspSampleTimeout = 50 * time.Millisecond
+
+	c := startLSP(t)
+	c.open("lens", "program : word ;\nword : 'a' | 'b' ;\nspin : spin ;\n")
+	var lenses []lspCodeLens
+	if err := c.request("textDocument/codeLens", map[string]any{"textDocument": map[string]any{"uri": "lens"}}, &lenses); err != nil {
+		t.Fatal(err)
+	}
+	var rules []string
+	for _, l := range lenses {
+		if l.Command == nil || l.Command.Command != lspSampleCommand || len(l.Command.Arguments) != 2 || l.Command.Arguments[0] != "lens" {
+			t.Fatalf("unexpected lens %+v", l)
+		}
+		rules = append(rules, l.Command.Arguments[1].(string))
+	}
+	if want := []string{"program", "word"}; !reflect.DeepEqual(rules, want) {
+		t.Errorf("lenses on %v, expected %v", rules, want)
+	}
+
+	sample := func(rule string) (string, *rpcError) {
+		var s string
+		err := c.request("workspace/executeCommand", map[string]any{"command": lspSampleCommand, "arguments": []string{"lens", rule}}, &s)
+		return s, err
+	}
+	s, err := sample("word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "a" && s != "b" {
+		t.Errorf("sample of word is '%s'", s)
+	}
+	var msg struct{ Message string }
+	if err := json.Unmarshal(c.notified("window/showMessage").Params, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg.Message, "Sample of 'word' (seed ") || !strings.HasSuffix(msg.Message, "\n"+s) {
+		t.Errorf("unexpected message '%s'", msg.Message)
+	}
+
+	start := time.Now()
+	if _, err := sample("spin"); err == nil || err.Code != rpcRequestFailed || !strings.Contains(err.Message, "may never end") {
+		t.Errorf("endless sample: got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("endless sample took %v", elapsed)
+	}
+	// The server keeps answering after a timeout
+	if _, err := sample("program"); err != nil {
+		t.Errorf("sample after a timeout: %v", err)
+	}
+
+	bad := []map[string]any{
+		{"command": "other", "arguments": []string{"lens", "word"}},
+		{"command": lspSampleCommand, "arguments": []string{"lens"}},
+	}
+	for _, params := range bad {
+		if err := c.request("workspace/executeCommand", params, nil); err == nil || err.Code != rpcInvalidParams {
+			t.Errorf("%v: got %v", params, err)
+		}
+	}
+	if _, err := sample("missing"); err == nil {
+		t.Error("sample of an undefined rule succeeded")
+	}
+}
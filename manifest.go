@@ -0,0 +1,163 @@
+package resrap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// ManifestEntry describes a single generated file, enough to generate it again
+type ManifestEntry struct {
+	Path      string `json:"path"` // Slash separated, relative to the codebase root
+	Grammar   string `json:"grammar"`
+	StartRule string `json:"start"`
+	Seed      uint64 `json:"seed"`
+	Tokens    int    `json:"tokens"`
+	SHA256    string `json:"sha256,omitempty"` // Hex digest of the content, empty for dry runs
+
+	// Symbol pools, see Node. A file only sees the symbols of files with a lower Stage.
+	Stage    int      `json:"stage,omitempty"`
+	Exports  []string `json:"exports,omitempty"`
+	Imports  []string `json:"imports,omitempty"`
+	NamePool string   `json:"name_pool,omitempty"`
+}
+
+// Manifest lists every directory and file of a generated codebase.
+// It can regenerate the codebase with GenerateFromManifest or check one with VerifyManifest.
+type Manifest struct {
+	Stream int             `json:"stream"` // DefaultStreamVersion the codebase was generated with
+	Dirs   []string        `json:"dirs"`
+	Files  []ManifestEntry `json:"files"`
+}
+
+// WriteManifestJSON writes m to w as indented JSON
+func WriteManifestJSON(w io.Writer, m Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// ReadManifestJSON reads a manifest written by WriteManifestJSON
+func ReadManifestJSON(r io.Reader) (Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return Manifest{}, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return m, nil
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkStream rejects manifests generated with a different default stream than this version's
+func (m Manifest) checkStream() error {
+	if m.Stream != 0 && m.Stream != DefaultStreamVersion {
+		return fmt.Errorf("manifest was generated with stream version %d, this version generates version %d", m.Stream, DefaultStreamVersion)
+	}
+	return nil
+}
+
+// checkManifestPath rejects manifest paths that would land outside the codebase root:
+// absolute paths, '..' or empty segments and backslashes. "." only names the root directory.
+func checkManifestPath(p string, dir bool) error {
+	if !fs.ValidPath(p) || strings.Contains(p, "\\") || (p == "." && !dir) {
+		return fmt.Errorf("invalid manifest path '%s', expected a slash separated path inside the codebase root", p)
+	}
+	return nil
+}
+
+// GenerateFromManifest generates the codebase described by m under target, taking the same options as GenerateCodebase.
+// The grammars named in the manifest must be loaded under the same names,
+// and every path must stay inside the codebase root.
+func (r *Resrap) GenerateFromManifest(m Manifest, target string, opts ...CodebaseOption) (CodebaseSummary, error) {
+	if err := m.checkStream(); err != nil {
+		return CodebaseSummary{}, err
+	}
+	for _, dir := range m.Dirs {
+		if err := checkManifestPath(dir, true); err != nil {
+			return CodebaseSummary{}, err
+		}
+	}
+	for _, f := range m.Files {
+		if err := checkManifestPath(f.Path, false); err != nil {
+			return CodebaseSummary{}, err
+		}
+	}
+	g := newCodebaseGen(r, target, opts)
+	g.dirs = append([]string(nil), m.Dirs...)
+	g.files = append([]ManifestEntry(nil), m.Files...)
+	for _, f := range g.files {
+		if err := checkStart(g.graphs(), f.Grammar, f.StartRule); err != nil {
+			return g.summary, fmt.Errorf("invalid manifest entry '%s': %w", f.Path, err)
+		}
+	}
+	err := g.generate()
+	return g.summary, err
+}
+
+// VerifyManifest checks that fsys, rooted at the codebase root, holds exactly the files of m.
+// Files are compared against the manifest's SHA256 digests, or regenerated when the manifest has none,
+// and files the manifest doesn't list are reported. A .git directory at the root is left out,
+// for codebases generated as git repositories. Returns every mismatch found.
+func (r *Resrap) VerifyManifest(m Manifest, fsys fs.FS) error {
+	if err := m.checkStream(); err != nil {
+		return err
+	}
+	// Files without a digest are regenerated together, imported symbols depend on earlier files
+	var expected map[string]string
+	for _, f := range m.Files {
+		if f.SHA256 == "" {
+			summary, err := r.GenerateFromManifest(m, "", WithSink(NewMemorySink()))
+			if err != nil {
+				return err
+			}
+			expected = make(map[string]string, len(summary.Manifest.Files))
+			for _, g := range summary.Manifest.Files {
+				expected[g.Path] = g.SHA256
+			}
+			break
+		}
+	}
+	var errs []error
+	listed := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		listed[f.Path] = true
+	}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && name == ".git" {
+			return fs.SkipDir
+		}
+		if !d.IsDir() && !listed[name] {
+			errs = append(errs, fmt.Errorf("%s: not in the manifest", name))
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, f := range m.Files {
+		data, err := fs.ReadFile(fsys, f.Path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		want := f.SHA256
+		if want == "" {
+			want = expected[f.Path]
+		}
+		if contentHash(string(data)) != want {
+			errs = append(errs, fmt.Errorf("%s: content does not match the manifest", f.Path))
+		}
+	}
+	return errors.Join(errs...)
+}
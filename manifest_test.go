@@ -0,0 +1,47 @@
+package resrap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateFromManifestRejectsEscapingPaths(t *testing.T) {
+	r := codebaseResrap(t)
+	file := func(p string) ManifestEntry {
+		return ManifestEntry{Path: p, Grammar: "c", StartRule: "program", Seed: 1, Tokens: 10}
+	}
+	hostile := map[string]Manifest{
+		"parent file":    {Dirs: []string{"."}, Files: []ManifestEntry{file("../../x.c")}},
+		"inner parent":   {Dirs: []string{"."}, Files: []ManifestEntry{file("src/../../x.c")}},
+		"absolute file":  {Dirs: []string{"."}, Files: []ManifestEntry{file("/tmp/x.c")}},
+		"backslash file": {Dirs: []string{"."}, Files: []ManifestEntry{file(`..\x.c`)}},
+		"empty file":     {Dirs: []string{"."}, Files: []ManifestEntry{file("")}},
+		"root as file":   {Dirs: []string{"."}, Files: []ManifestEntry{file(".")}},
+		"empty segment":  {Dirs: []string{"."}, Files: []ManifestEntry{file("src//x.c")}},
+		"parent dir":     {Dirs: []string{"..", "../x"}},
+		"absolute dir":   {Dirs: []string{"/tmp/x"}},
+		"empty dir":      {Dirs: []string{""}},
+	}
+	for name, m := range hostile {
+		base := t.TempDir()
+		target := filepath.Join(base, "a", "b")
+		_, err := r.GenerateFromManifest(m, "", WithSink(NewDiskSink(target)))
+		if err == nil || !strings.Contains(err.Error(), "invalid manifest path") {
+			t.Errorf("%s: got %v, want an invalid manifest path error", name, err)
+		}
+		if entries, _ := os.ReadDir(base); len(entries) != 0 {
+			t.Errorf("%s: wrote %s before rejecting the manifest", name, entries[0].Name())
+		}
+	}
+
+	m := Manifest{Dirs: []string{".", "src"}, Files: []ManifestEntry{file("src/x.c"), file("y.c")}}
+	sink := NewMemorySink()
+	if _, err := r.GenerateFromManifest(m, "", WithSink(sink)); err != nil {
+		t.Fatal(err)
+	}
+	if fsys := sink.FS(); fsys["src/x.c"] == nil || fsys["y.c"] == nil {
+		t.Error("valid manifest paths not generated")
+	}
+}
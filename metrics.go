@@ -0,0 +1,213 @@
+package resrap
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Metrics receives runtime measurements from Resrap and ResrapMT.
+// Implementations must be safe for concurrent use, the worker pool reports from every goroutine.
+type Metrics interface {
+	// QueueDepth reports the number of jobs waiting in the ResrapMT queue
+	QueueDepth(depth int)
+	// JobLatency reports how long a single generation took for a grammar
+	JobLatency(grammar string, d time.Duration)
+	// TokensGenerated reports the number of tokens emitted by a single generation
+	TokensGenerated(grammar string, tokens int)
+	// StackDepth reports the deepest the jump stack grew during a single generation
+	StackDepth(grammar string, depth int)
+	// GenerationError reports a failed parse or generation for a grammar
+	GenerationError(grammar string)
+}
+
+// NoopMetrics is the default Metrics, it discards everything
+type NoopMetrics struct{}
+
+func (NoopMetrics) QueueDepth(int)                   {}
+func (NoopMetrics) JobLatency(string, time.Duration) {}
+func (NoopMetrics) TokensGenerated(string, int)      {}
+func (NoopMetrics) StackDepth(string, int)           {}
+func (NoopMetrics) GenerationError(string)           {}
+
+// orNoop returns m, or NoopMetrics when m is nil
+func orNoop(m Metrics) Metrics {
+	if m == nil {
+		return NoopMetrics{}
+	}
+	return m
+}
+
+// latencyBuckets are the upper bounds of the job latency histogram
+var latencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// latencyHistogram is an expvar.Var holding cumulative latency buckets for one grammar
+type latencyHistogram struct {
+	mu     sync.Mutex
+	counts []int64 // one per bucket plus the overflow bucket
+	count  int64
+	sum    time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]int64, len(latencyBuckets)+1)}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += d
+}
+
+// String renders the histogram as JSON, as required by expvar.Var
+func (h *latencyHistogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := make(map[string]int64, len(h.counts))
+	for i, c := range h.counts {
+		if i < len(latencyBuckets) {
+			buckets["le_"+latencyBuckets[i].String()] = c
+		} else {
+			buckets["inf"] = c
+		}
+	}
+	out, _ := json.Marshal(struct {
+		Buckets map[string]int64 `json:"buckets"`
+		Count   int64            `json:"count"`
+		SumMs   float64          `json:"sum_ms"`
+	}{buckets, h.count, float64(h.sum) / float64(time.Millisecond)})
+	return string(out)
+}
+
+// ExpvarMetrics publishes metrics through the standard expvar package,
+// making them visible at /debug/vars when the expvar handler is mounted.
+type ExpvarMetrics struct {
+	queueDepth    *expvar.Int
+	tokens        *expvar.Map
+	errors        *expvar.Map
+	maxStackDepth *expvar.Map
+	latency       *expvar.Map
+
+	mu         sync.Mutex
+	histograms map[string]*latencyHistogram
+}
+
+// NewExpvarMetrics creates an ExpvarMetrics publishing its variables under prefix (e.g., "resrap").
+// Calling it twice with the same prefix returns metrics sharing the already published variables.
+func NewExpvarMetrics(prefix string) *ExpvarMetrics {
+	return &ExpvarMetrics{
+		queueDepth:    expvarInt(prefix + ".queue_depth"),
+		tokens:        expvarMap(prefix + ".tokens_generated"),
+		errors:        expvarMap(prefix + ".errors"),
+		maxStackDepth: expvarMap(prefix + ".max_stack_depth"),
+		latency:       expvarMap(prefix + ".job_latency"),
+		histograms:    make(map[string]*latencyHistogram),
+	}
+}
+
+func expvarInt(name string) *expvar.Int {
+	if v, ok := expvar.Get(name).(*expvar.Int); ok {
+		return v
+	}
+	return expvar.NewInt(name)
+}
+
+func expvarMap(name string) *expvar.Map {
+	if v, ok := expvar.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	return expvar.NewMap(name)
+}
+
+func (e *ExpvarMetrics) QueueDepth(depth int) {
+	e.queueDepth.Set(int64(depth))
+}
+
+func (e *ExpvarMetrics) JobLatency(grammar string, d time.Duration) {
+	e.mu.Lock()
+	h, ok := e.histograms[grammar]
+	if !ok {
+		if existing, found := e.latency.Get(grammar).(*latencyHistogram); found {
+			h = existing
+		} else {
+			h = newLatencyHistogram()
+			e.latency.Set(grammar, h)
+		}
+		e.histograms[grammar] = h
+	}
+	e.mu.Unlock()
+	h.observe(d)
+}
+
+func (e *ExpvarMetrics) TokensGenerated(grammar string, tokens int) {
+	e.tokens.Add(grammar, int64(tokens))
+}
+
+func (e *ExpvarMetrics) StackDepth(grammar string, depth int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if v, ok := e.maxStackDepth.Get(grammar).(*expvar.Int); ok && v.Value() >= int64(depth) {
+		return
+	}
+	v := new(expvar.Int)
+	v.Set(int64(depth))
+	e.maxStackDepth.Set(grammar, v)
+}
+
+func (e *ExpvarMetrics) GenerationError(grammar string) {
+	e.errors.Add(grammar, 1)
+}
+
+// observedWalk runs a single generation job, reporting it to m and tracing it on logger when set.
+// Unknown grammars and starting nodes are reported as errors and produce no content.
+func observedWalk(graphs map[string]lang, m Metrics, logger *slog.Logger, job codeGenReq, prng *prng) (string, walkStats, error) {
+	begin := time.Now()
+	if err := checkStart(graphs, job.name, job.startnode); err != nil {
+		m.GenerationError(job.name)
+		if logger != nil {
+			logger.LogAttrs(context.Background(), slog.LevelWarn, "resrap job failed",
+				slog.String("id", job.id), slog.String("grammar", job.name), slog.String("start", job.startnode),
+				slog.String("error", err.Error()))
+		}
+		return "", walkStats{missing: true}, err
+	}
+	ctx := job.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	code, stats := graphs[job.name].graph.walk(ctx, prng, job.startnode, job.tokens, job.hooks)
+	if stats.stopped {
+		m.GenerationError(job.name)
+		return code, stats, ctx.Err()
+	}
+	elapsed := time.Since(begin)
+	m.JobLatency(job.name, elapsed)
+	m.TokensGenerated(job.name, stats.tokens)
+	m.StackDepth(job.name, stats.maxDepth)
+	if logger != nil {
+		logger.LogAttrs(context.Background(), slog.LevelDebug, "resrap job",
+			slog.String("id", job.id), slog.String("grammar", job.name), slog.String("start", job.startnode),
+			slog.Uint64("seed", prng.seed), slog.Int("tokens", stats.tokens),
+			slog.Int("max_stack_depth", stats.maxDepth), slog.Duration("latency", elapsed))
+	}
+	return code, stats, nil
+}
@@ -0,0 +1,236 @@
+package resrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics keeps everything reported to it
+type recordingMetrics struct {
+	mu        sync.Mutex
+	depths    []int
+	latencies map[string]int
+	tokens    map[string]int
+	stacks    map[string]int
+	errors    map[string]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		latencies: map[string]int{},
+		tokens:    map[string]int{},
+		stacks:    map[string]int{},
+		errors:    map[string]int{},
+	}
+}
+
+func (m *recordingMetrics) QueueDepth(depth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depths = append(m.depths, depth)
+}
+
+func (m *recordingMetrics) JobLatency(grammar string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latencies[grammar]++
+}
+
+func (m *recordingMetrics) TokensGenerated(grammar string, tokens int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[grammar] += tokens
+}
+
+func (m *recordingMetrics) StackDepth(grammar string, depth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stacks[grammar] = max(m.stacks[grammar], depth)
+}
+
+func (m *recordingMetrics) GenerationError(grammar string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[grammar]++
+}
+
+const nestedGrammar = "program: item;\nitem: inner;\ninner: 'x';\n"
+
+func TestMetricsReported(t *testing.T) {
+	m := newRecordingMetrics()
+	r := NewResrap()
+	r.SetMetrics(m)
+	if err := r.ParseGrammar("nested", nestedGrammar); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ParseGrammar("bad", "program: missing;"); err == nil {
+		t.Fatal("grammar with an undefined rule parsed")
+	}
+	if code := r.GenerateWithSeeded("nested", "program", 1, 3); code != "x" {
+		t.Fatalf("generated %q", code)
+	}
+	r.GenerateWithSeeded("nested", "nope", 1, 3)
+	r.GenerateWithSeeded("unknown", "program", 1, 3)
+
+	if m.latencies["nested"] != 1 || m.tokens["nested"] != 1 {
+		t.Errorf("nested: %d latencies and %d tokens, want 1 and 1", m.latencies["nested"], m.tokens["nested"])
+	}
+	if m.stacks["nested"] < 2 {
+		t.Errorf("nested: max stack depth %d, want at least 2", m.stacks["nested"])
+	}
+	for grammar, want := range map[string]int{"nested": 1, "bad": 1, "unknown": 1} {
+		if m.errors[grammar] != want {
+			t.Errorf("%s: %d errors, want %d", grammar, m.errors[grammar], want)
+		}
+	}
+
+	// Nil restores the default, nothing more is reported
+	r.SetMetrics(nil)
+	r.GenerateWithSeeded("nested", "program", 1, 3)
+	if m.latencies["nested"] != 1 {
+		t.Error("metrics reported after SetMetrics(nil)")
+	}
+}
+
+func TestMetricsReportedByPool(t *testing.T) {
+	m := newRecordingMetrics()
+	r := NewResrapMT(2, 8)
+	r.SetMetrics(m)
+	if err := r.ParseGrammar("nested", nestedGrammar); err != nil {
+		t.Fatal(err)
+	}
+	r.StartResrap()
+	defer r.ShutDownResrap()
+	for i := 0; i < 4; i++ {
+		r.GenerateWithSeeded("job", "nested", "program", uint64(i), 3)
+	}
+	r.GenerateWithSeeded("fail", "nested", "nope", 0, 3)
+	for i := 0; i < 5; i++ {
+		<-r.GetCodeChannel()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.latencies["nested"] != 4 || m.tokens["nested"] != 4 || m.errors["nested"] != 1 {
+		t.Errorf("%d latencies, %d tokens and %d errors, want 4, 4 and 1",
+			m.latencies["nested"], m.tokens["nested"], m.errors["nested"])
+	}
+	// Every queued job is reported, then again when a worker takes it
+	if len(m.depths) != 10 {
+		t.Errorf("queue depth reported %d times, want 10", len(m.depths))
+	}
+	for _, d := range m.depths {
+		if d < 0 || d > 8 {
+			t.Errorf("queue depth %d out of the queue's range", d)
+		}
+	}
+}
+
+// expvarJSON decodes the published variable name into v
+func expvarJSON(t *testing.T, name string, v any) {
+	t.Helper()
+	published := expvar.Get(name)
+	if published == nil {
+		t.Fatalf("%s not published", name)
+	}
+	if err := json.Unmarshal([]byte(published.String()), v); err != nil {
+		t.Fatalf("%s: %v in %s", name, err, published.String())
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	// expvar variables can't be unpublished, each run gets its own
+	prefix := fmt.Sprintf("resrap_test_%d", time.Now().UnixNano())
+	m := NewExpvarMetrics(prefix)
+	m.QueueDepth(5)
+	m.TokensGenerated("c", 10)
+	m.TokensGenerated("c", 7)
+	m.StackDepth("c", 4)
+	m.StackDepth("c", 2)
+	m.GenerationError("sql")
+	for _, d := range []time.Duration{50 * time.Microsecond, 2 * time.Millisecond, 2 * time.Second} {
+		m.JobLatency("c", d)
+	}
+
+	// A second one with the same prefix keeps adding to the published variables
+	again := NewExpvarMetrics(prefix)
+	again.GenerationError("sql")
+	again.JobLatency("c", time.Millisecond)
+
+	var depth int
+	expvarJSON(t, prefix+".queue_depth", &depth)
+	if depth != 5 {
+		t.Errorf("queue depth %d, want 5", depth)
+	}
+	var tokens, stacks, errors map[string]int
+	expvarJSON(t, prefix+".tokens_generated", &tokens)
+	expvarJSON(t, prefix+".max_stack_depth", &stacks)
+	expvarJSON(t, prefix+".errors", &errors)
+	if tokens["c"] != 17 || stacks["c"] != 4 || errors["sql"] != 2 {
+		t.Errorf("tokens %v, max stack depth %v, errors %v", tokens, stacks, errors)
+	}
+
+	var latency map[string]struct {
+		Buckets map[string]int `json:"buckets"`
+		Count   int            `json:"count"`
+		SumMs   float64        `json:"sum_ms"`
+	}
+	expvarJSON(t, prefix+".job_latency", &latency)
+	h := latency["c"]
+	if h.Count != 4 || h.SumMs != 2003.05 {
+		t.Errorf("%d latencies summing to %vms, want 4 and 2003.05", h.Count, h.SumMs)
+	}
+	want := map[string]int{"le_100µs": 1, "le_1ms": 1, "le_5ms": 1, "inf": 1}
+	if len(h.Buckets) != len(latencyBuckets)+1 {
+		t.Errorf("%d buckets, want %d", len(h.Buckets), len(latencyBuckets)+1)
+	}
+	for bucket, count := range h.Buckets {
+		if count != want[bucket] {
+			t.Errorf("bucket %s: %d, want %d", bucket, count, want[bucket])
+		}
+	}
+}
+
+func TestLoggerTracesJobs(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewResrap()
+	r.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	if err := r.ParseGrammar("nested", nestedGrammar); err != nil {
+		t.Fatal(err)
+	}
+	r.GenerateWithSeeded("nested", "program", 9, 3)
+	r.GenerateWithSeeded("nested", "nope", 9, 3)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d records, want 2:\n%s", len(lines), buf.String())
+	}
+	var job, failed map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &job); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &failed); err != nil {
+		t.Fatal(err)
+	}
+	if job["level"] != "DEBUG" || job["msg"] != "resrap job" || job["grammar"] != "nested" ||
+		job["seed"] != 9.0 || job["tokens"] != 1.0 {
+		t.Errorf("job record %v", job)
+	}
+	if failed["level"] != "WARN" || failed["msg"] != "resrap job failed" || failed["start"] != "nope" ||
+		!strings.Contains(failed["error"].(string), "nope") {
+		t.Errorf("failed job record %v", failed)
+	}
+
+	r.SetLogger(nil)
+	buf.Reset()
+	r.GenerateWithSeeded("nested", "program", 9, 3)
+	if buf.Len() != 0 {
+		t.Errorf("traced after SetLogger(nil): %s", buf.String())
+	}
+}
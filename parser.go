@@ -17,6 +17,7 @@ type parser struct {
 	inter_rep    map[uint32][]token //Intermediate Representation
 	tokens       []token
 	errors       []error
+	errorPos     []int //Offset in the grammar of the token each error was found at
 	index        int
 	graph        syntaxGraph
 	regexhandler regexer
@@ -49,14 +50,26 @@ func (i *parser) get_func_ptr() uint32 {
 	return i.func_ptr
 }
 func (i *parser) curr() token {
+	if i.index >= len(i.tokens) {
+		if n := len(i.tokens); n > 0 {
+			return token{typ: eof, pos: i.tokens[n-1].pos} //Errors at the end point at the last token
+		}
+		return token{typ: eof}
+	}
 	return i.tokens[i.index]
 }
+
+// fail records a parse error found at offset pos
+func (i *parser) fail(pos int, err error) {
+	i.errors = append(i.errors, err)
+	i.errorPos = append(i.errorPos, pos)
+}
 func (i *parser) match(word tokenType, expec []tokenType) bool {
 	return slices.Contains(expec, word)
 }
 func (i *parser) expect(expected []tokenType, errmsg string) bool {
 	if !i.match(i.curr().typ, expected) {
-		i.errors = append(i.errors, fmt.Errorf("%s", errmsg))
+		i.fail(i.curr().pos, fmt.Errorf("%s", errmsg))
 		i.index++
 		return true
 	}
@@ -94,7 +107,7 @@ func (i *parser) parse_subject() {
 	}
 	id := i.get_index(subject.text)
 	if i.def_check[id] { //If map is already set to true
-		i.errors = append(i.errors, fmt.Errorf("Multiple definitions for %s", subject.text))
+		i.fail(subject.pos, fmt.Errorf("Multiple definitions for %s", subject.text))
 	}
 
 	i.def_check[id] = true
@@ -119,6 +132,10 @@ func (i *parser) parse_rules(root uint32, isDeep bool) (*syntaxNode, *syntaxNode
 		endNode = i.graph.GetNode(i.get_func_ptr(), end)
 	}
 	for {
+		if startBuffer == nil && i.match(i.curr().typ, []tokenType{maybe, oneormore, anyno, infinite}) {
+			i.fail(i.curr().pos, fmt.Errorf("Nothing to repeat before %v", i.curr().typ))
+			return nil, nil
+		}
 		switch i.curr().typ {
 		case identifier:
 			//Means its a reference to a different Subject(presumably)
@@ -150,7 +167,10 @@ func (i *parser) parse_rules(root uint32, isDeep bool) (*syntaxNode, *syntaxNode
 			bufferNode = jumpNode
 		case colon:
 			//Colon is not allowed here
-			i.errors = append(i.errors, fmt.Errorf("Missing Semicolon"))
+			i.fail(i.curr().pos, fmt.Errorf("Missing Semicolon"))
+			return nil, nil
+		case eof:
+			i.fail(i.curr().pos, fmt.Errorf("Missing Semicolon at end of grammar"))
 			return nil, nil
 		case maybe:
 			startBuffer.AddEdgeNext(&i.graph, bufferNode, 1-i.get_probability()) //An option to skip to the end
@@ -167,40 +187,44 @@ func (i *parser) parse_rules(root uint32, isDeep bool) (*syntaxNode, *syntaxNode
 		case padding:
 			bufferNode.AddEdgeNext(&i.graph, endNode, 1)
 			if isDeep {
-				i.errors = append(i.errors, fmt.Errorf("Stray '('"))
+				i.fail(i.curr().pos, fmt.Errorf("Stray '('"))
 			}
 			i.index++
 			return nil, nil //End of this statement
 		case bracopen:
 			i.index++
 			startBuffer, bufferNode = i.parse_rules(bufferNode.id, true)
+			if bufferNode == nil {
+				return nil, nil //The bracket had errors
+			}
 		case bracclose:
 			if isDeep {
 				bufferNode.AddEdgeNext(&i.graph, endNode, 1)
 				return rootnode, endNode
 			}
-			i.errors = append(i.errors, fmt.Errorf("Stray ')' found"))
+			i.fail(i.curr().pos, fmt.Errorf("Stray ')' found"))
 		case infinite:
 			//Now at the end it will loop back to this case
 			endNode.AddEdgeNext(&i.graph, startBuffer, 1)
 		default:
-			continue
+			i.fail(i.curr().pos, fmt.Errorf("Unexpected %v", i.curr().typ))
+			return nil, nil
 		}
 		i.index++
 	}
 }
 func (i *parser) get_probability() float32 {
 	i.index++
-	if i.tokens[i.index].typ == probability {
+	if i.curr().typ == probability {
 		num := i.tokens[i.index].text
 		numf, err := strconv.ParseFloat(num, 32)
 		if err != nil {
+			i.fail(i.curr().pos, err)
 			i.index--
-			i.errors = append(i.errors, err)
 			return 0
 		}
 		if numf < 0 {
-			i.errors = append(i.errors, fmt.Errorf("Negative Probability Found"))
+			i.fail(i.curr().pos, fmt.Errorf("Negative Probability Found"))
 			return 0
 		}
 		return float32(numf)
@@ -208,6 +232,16 @@ func (i *parser) get_probability() float32 {
 	i.index-- //Reverting
 	return 0.5
 }
+
+// undefinedRuleError is a rule that is referenced but never defined
+type undefinedRuleError struct {
+	name string
+}
+
+func (e undefinedRuleError) Error() string {
+	return fmt.Sprintf("Definition of '%s' not found", e.name)
+}
+
 func (p *parser) ValidateGraph() []error {
 	if len(p.errors) > 0 {
 		return nil
@@ -215,7 +249,7 @@ func (p *parser) ValidateGraph() []error {
 	var errors []error
 	for key, val := range p.def_check {
 		if !val {
-			errors = append(errors, fmt.Errorf("Definition of '%s' not found", p.rev_name_map[key]))
+			errors = append(errors, undefinedRuleError{p.rev_name_map[key]})
 		}
 	}
 	return errors
@@ -0,0 +1,533 @@
+package resrap
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PEG grammars are imported from the three notations in use:
+//
+//	Sum <- Number ('+' Number)*                classic PEG
+//	sum = number ("+" number)* { return ... }  peg.js and Peggy
+//	sum = { number ~ ("+" ~ number)* }         pest
+//
+// Ordered choice becomes Resrap's choice, every alternative being equally likely: an alternative
+// an earlier one shadows can still be generated. Lookahead predicates are dropped with a warning,
+// except !x followed by any character, which generates a character x doesn't match.
+// PEG grammars work on characters, so every rule is lexical, except the non atomic rules of pest
+// grammars defining WHITESPACE, which are generated with a space between their tokens.
+
+type pegTokenKind uint8
+
+const (
+	pEOF    pegTokenKind = iota
+	pIdent               //Rule name
+	pString              //"..." or '...', text is decoded
+	pClass               //[...], text is the raw content
+	pNumber              //Count of a pest repetition
+	pAction              //{...} action or semantic predicate of peg.js
+	pPunct               //Operators, text holds them
+)
+
+type pegToken struct {
+	kind pegTokenKind
+	text string
+	line int
+}
+
+// pestBody matches the rules of pest grammars, whose bodies are in braces
+var pestBody = regexp.MustCompile(`(?m)^\s*[A-Za-z_]\w*\s*=\s*[_@$!]?\s*\{`)
+
+// tokenizePEG splits a PEG grammar into tokens, dropping comments. Braces are actions
+// except in pest grammars, where they hold rule bodies and repetition counts.
+func tokenizePEG(src string, pest bool) ([]pegToken, error) {
+	var toks []pegToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(src[i:], "//") || c == '#' && !pest:
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case isIdentStart(rune(c)):
+			j := i
+			for j < len(src) && isIdentPart(rune(src[j])) {
+				j++
+			}
+			toks = append(toks, pegToken{pIdent, src[i:j], line})
+			i = j
+		case isDigit(rune(c)):
+			j := i
+			for j < len(src) && isDigit(rune(src[j])) {
+				j++
+			}
+			toks = append(toks, pegToken{pNumber, src[i:j], line})
+			i = j
+		case c == '"' || c == '\'' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			j := i + 1
+			for j < len(src) && src[j] != closing && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != closing {
+				return nil, fmt.Errorf("line %d: unterminated %c", line, c)
+			}
+			tok := pegToken{pString, unescapeANTLR(src[i+1 : j]), line}
+			if c == '[' {
+				tok = pegToken{pClass, src[i+1 : j], line}
+			}
+			toks = append(toks, tok)
+			if i = j + 1; i < len(src) && src[i] == 'i' && (i+1 == len(src) || !isIdentPart(rune(src[i+1]))) {
+				i++ //Case insensitive, generated as written
+			}
+		case c == '{' && !pest:
+			end, err := skipAction(src, i)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			toks = append(toks, pegToken{pAction, src[i+1 : end-1], line})
+			line += strings.Count(src[i:end], "\n")
+			i = end
+		default:
+			op := string(c)
+			for _, long := range []string{"<-", ".."} {
+				if strings.HasPrefix(src[i:], long) {
+					op = long
+				}
+			}
+			if len(op) == 1 && !strings.Contains("=/|~&!$@:?*+().^;,{}", op) {
+				r, _ := utf8.DecodeRuneInString(src[i:])
+				return nil, fmt.Errorf("line %d: unexpected character %q", line, r)
+			}
+			toks = append(toks, pegToken{pPunct, op, line})
+			i += len(op)
+		}
+	}
+	return append(toks, pegToken{pEOF, "", line}), nil
+}
+
+type pegParser struct {
+	toks    []pegToken
+	pos     int
+	g       *ggrammar
+	pest    bool
+	current string //Rule being read, for warnings
+	soi     string //First rule matching from the start of input, the start rule of pest grammars
+}
+
+// importPEG converts a classic PEG, peg.js or pest grammar
+func importPEG(src string, _ importConfig) (*ggrammar, error) {
+	pest := pestBody.MatchString(src)
+	toks, err := tokenizePEG(src, pest)
+	if err != nil {
+		return nil, err
+	}
+	p := &pegParser{toks: toks, g: &ggrammar{}, pest: pest}
+	for p.peek().kind != pEOF {
+		if p.peek().kind == pAction { //Initializers of peg.js
+			p.pos++
+			continue
+		}
+		r, err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+		p.g.rules = append(p.g.rules, r)
+	}
+	if len(p.g.rules) == 0 {
+		return nil, fmt.Errorf("grammar has no rules")
+	}
+
+	p.g.start = p.g.rules[0].name
+	if pest {
+		p.pestStart()
+		p.pestAtomic()
+	}
+	return p.g, nil
+}
+
+// pestAtomic makes the rules atomic rules call atomic too, like pest does
+func (p *pegParser) pestAtomic() {
+	rules := map[string]*grule{}
+	var atomic []*grule
+	for _, r := range p.g.rules {
+		rules[r.name] = r
+		if r.lexical {
+			atomic = append(atomic, r)
+		}
+	}
+	for len(atomic) > 0 {
+		r := atomic[len(atomic)-1]
+		atomic = atomic[:len(atomic)-1]
+		r.expr.refs(func(name string) {
+			if called := rules[name]; called != nil && !called.lexical {
+				called.lexical = true
+				atomic = append(atomic, called)
+			}
+		})
+	}
+}
+
+// pestStart picks the start rule of a pest grammar, which doesn't have one: the first rule
+// matching from the start of input (SOI), else the first rule that isn't WHITESPACE or COMMENT
+func (p *pegParser) pestStart() {
+	if p.soi != "" {
+		p.g.start = p.soi
+		return
+	}
+	for _, r := range p.g.rules {
+		if r.name != "WHITESPACE" && r.name != "COMMENT" {
+			p.g.start = r.name
+			return
+		}
+	}
+}
+
+func (p *pegParser) peek() pegToken { return p.toks[p.pos] }
+
+func (p *pegParser) next() pegToken {
+	tok := p.toks[p.pos]
+	if tok.kind != pEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *pegParser) is(punct string) bool {
+	tok := p.peek()
+	return tok.kind == pPunct && tok.text == punct
+}
+
+func (p *pegParser) accept(punct string) bool {
+	if p.is(punct) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *pegParser) expect(punct string) error {
+	if !p.accept(punct) {
+		return p.errorf("expected '%s'", punct)
+	}
+	return nil
+}
+
+func (p *pegParser) errorf(format string, args ...any) error {
+	tok := p.peek()
+	found := "end of grammar"
+	if tok.kind != pEOF {
+		found = fmt.Sprintf("'%s'", tok.text)
+	}
+	return fmt.Errorf("line %d: %s, found %s", tok.line, fmt.Sprintf(format, args...), found)
+}
+
+// ruleStart reports whether the tokens from the current one start the next rule:
+// 'name <-', 'name =' or peg.js' 'name "display name" ='
+func (p *pegParser) ruleStart() bool {
+	at := func(i int) pegToken { return p.toks[min(p.pos+i, len(p.toks)-1)] }
+	if at(0).kind != pIdent {
+		return false
+	}
+	after := at(1)
+	if after.kind == pString {
+		after = at(2)
+	}
+	return after.kind == pPunct && (after.text == "<-" || after.text == "=")
+}
+
+func (p *pegParser) rule() (*grule, error) {
+	if !p.ruleStart() {
+		return nil, p.errorf("expected a rule like 'name <- ...' or 'name = ...'")
+	}
+	r := &grule{name: p.next().text, lexical: true}
+	p.current = r.name
+	if p.peek().kind == pString {
+		p.pos++ //Display name
+	}
+	p.pos++ //<- or =
+	if !p.pest {
+		expr, err := p.alternatives()
+		if err != nil {
+			return nil, err
+		}
+		p.accept(";")
+		r.expr = expr
+		return r, nil
+	}
+
+	// pest: atomic rules (@ and $) work on characters, the others have WHITESPACE between their tokens
+	atomic := false
+	switch tok := p.peek(); {
+	case tok.kind == pPunct && (tok.text == "@" || tok.text == "$"):
+		atomic = true
+		p.pos++
+	case tok.kind == pPunct && tok.text == "!", tok.kind == pIdent && tok.text == "_":
+		p.pos++
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	expr, err := p.alternatives()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	r.expr = expr
+	if p.pestSpaced() && !atomic && r.name != "WHITESPACE" && r.name != "COMMENT" {
+		r.lexical = false
+		p.g.sep = " "
+	}
+	return r, nil
+}
+
+// pestSpaced reports whether the pest grammar defines WHITESPACE, skipped between the tokens of non atomic rules
+func (p *pegParser) pestSpaced() bool {
+	for i := 0; i+1 < len(p.toks); i++ {
+		if p.toks[i].kind == pIdent && p.toks[i].text == "WHITESPACE" && p.toks[i+1].kind == pPunct && p.toks[i+1].text == "=" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pegParser) alternatives() (*gexpr, error) {
+	var alts []*gexpr
+	for {
+		seq, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, seq)
+		if !p.accept("/") && !p.accept("|") {
+			return gAltOf(alts...), nil
+		}
+	}
+}
+
+func (p *pegParser) sequence() (*gexpr, error) {
+	var items []*gexpr
+	for !p.ruleStart() {
+		tok := p.peek()
+		if tok.kind == pEOF || tok.kind == pPunct && strings.Contains("/|);}", tok.text) {
+			break
+		}
+		switch {
+		case tok.kind == pAction: //Semantic action of peg.js
+			p.pos++
+		case p.accept("~"):
+		case tok.kind == pPunct && (tok.text == "&" || tok.text == "!"):
+			p.pos++
+			item, err := p.lookahead(tok.text)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		default:
+			item, err := p.suffixed()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	return gSeqOf(items...), nil
+}
+
+// lookahead reads a predicate after its & or !. Predicates generate nothing, except !x followed
+// by any character, which becomes a character x doesn't match.
+func (p *pegParser) lookahead(op string) (*gexpr, error) {
+	if p.peek().kind == pAction {
+		p.pos++
+		p.g.warn(p.current, "semantic predicate %s{...} dropped, what it rejects can be generated", op)
+		return &gexpr{kind: gEmpty}, nil
+	}
+	e, err := p.suffixed()
+	if err != nil {
+		return nil, err
+	}
+	if op == "!" {
+		p.accept("~")
+		anyPest := p.pest && p.peek().kind == pIdent && p.peek().text == "ANY"
+		if anyPest {
+			p.pos++
+		}
+		if anyPest || p.accept(".") {
+			return gMinus(gChars(anyChar), e), nil
+		}
+	}
+	p.g.warn(p.current, "lookahead %s dropped, what it rejects can be generated", op)
+	return &gexpr{kind: gEmpty}, nil
+}
+
+// suffixed reads an atom and its ?, * or + operator, or the {n,m} count of pest
+func (p *pegParser) suffixed() (*gexpr, error) {
+	e, err := p.atom()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.accept("?"):
+		return gRepeat(gOpt, e), nil
+	case p.accept("*"):
+		return gRepeat(gStar, e), nil
+	case p.accept("+"):
+		return gRepeat(gPlus, e), nil
+	case p.pest && p.is("{"):
+		return p.count(e)
+	}
+	return e, nil
+}
+
+// count reads a pest repetition count: {n}, {n,}, {,m} or {n,m}
+func (p *pegParser) count(e *gexpr) (*gexpr, error) {
+	p.pos++
+	least, most := 0, -1
+	if p.peek().kind == pNumber {
+		least, _ = strconv.Atoi(p.next().text)
+		most = least
+	}
+	if p.accept(",") {
+		most = -1
+		if p.peek().kind == pNumber {
+			most, _ = strconv.Atoi(p.next().text)
+		}
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	if most >= 0 && most < least {
+		return nil, p.errorf("repetition of at least %d and at most %d", least, most)
+	}
+	repeated, exact := gCount(e, least, most)
+	if !exact {
+		p.g.warn(p.current, "repetition counts above %d are approximated", maxCopies)
+	}
+	return repeated, nil
+}
+
+func (p *pegParser) atom() (*gexpr, error) {
+	// Labels, plucks and text operators of peg.js don't change what is matched
+	for {
+		if tok := p.peek(); tok.kind == pIdent && p.toks[p.pos+1].kind == pPunct && p.toks[p.pos+1].text == ":" {
+			p.pos += 2
+		} else if !p.accept("@") && !p.accept("$") && !(p.pest && p.accept("^")) {
+			break
+		}
+	}
+
+	tok := p.next()
+	switch tok.kind {
+	case pString:
+		if p.pest && p.accept("..") { //'a'..'z'
+			to := p.next()
+			if to.kind != pString {
+				p.pos--
+				return nil, p.errorf("expected the end of the range")
+			}
+			lo, _ := utf8.DecodeRuneInString(tok.text)
+			hi, _ := utf8.DecodeRuneInString(to.text)
+			return gChars(charSet{}.union(charSet{{lo, max(lo, hi)}})), nil
+		}
+		return gLiteral(tok.text), nil
+	case pClass:
+		body := tok.text
+		negated := strings.HasPrefix(body, "^")
+		set, exact := parseANTLRSet(strings.TrimPrefix(body, "^"))
+		if !exact {
+			p.g.warn(p.current, "unicode properties approximated by ASCII letters")
+		}
+		if negated {
+			set = anyChar.minus(set)
+		}
+		return gChars(set), nil
+	case pIdent:
+		if p.pest {
+			if e, ok := p.pestBuiltin(tok.text); ok {
+				return e, nil
+			}
+		}
+		return gRule(tok.text), nil
+	case pPunct:
+		switch tok.text {
+		case ".":
+			return gChars(anyChar), nil
+		case "(":
+			e, err := p.alternatives()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+	}
+	p.pos--
+	return nil, p.errorf("expected an expression")
+}
+
+// pestCharClasses are the ASCII character classes built in pest
+var pestCharClasses = map[string]charSet{
+	"ASCII_DIGIT":         {{'0', '9'}},
+	"ASCII_NONZERO_DIGIT": {{'1', '9'}},
+	"ASCII_BIN_DIGIT":     {{'0', '1'}},
+	"ASCII_OCT_DIGIT":     {{'0', '7'}},
+	"ASCII_HEX_DIGIT":     {{'0', '9'}, {'A', 'F'}, {'a', 'f'}},
+	"ASCII_ALPHA_LOWER":   {{'a', 'z'}},
+	"ASCII_ALPHA_UPPER":   {{'A', 'Z'}},
+	"ASCII_ALPHA":         {{'A', 'Z'}, {'a', 'z'}},
+	"ASCII_ALPHANUMERIC":  {{'0', '9'}, {'A', 'Z'}, {'a', 'z'}},
+	"ASCII":               anyChar,
+}
+
+// pestBuiltin translates the built-in rules of pest. The stack rules match text pushed
+// earlier, which isn't tracked: they generate nothing.
+func (p *pegParser) pestBuiltin(name string) (*gexpr, bool) {
+	if set, ok := pestCharClasses[name]; ok {
+		return gChars(set), true
+	}
+	switch name {
+	case "ANY":
+		return gChars(anyChar), true
+	case "SOI":
+		if p.soi == "" {
+			p.soi = p.current
+		}
+		return &gexpr{kind: gEmpty}, true
+	case "EOI":
+		return &gexpr{kind: gEmpty}, true
+	case "NEWLINE":
+		return gLiteral("\n"), true
+	case "PUSH":
+		return &gexpr{kind: gEmpty}, true //PUSH(e) generates the group that follows
+	case "POP", "PEEK", "DROP", "POP_ALL", "PEEK_ALL":
+		p.g.warn(p.current, "%s matches text pushed earlier, generated as nothing", name)
+		return &gexpr{kind: gEmpty}, true
+	}
+	return nil, false
+}
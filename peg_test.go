@@ -0,0 +1,77 @@
+package resrap
+
+import "testing"
+
+func TestImportPEG(t *testing.T) {
+	testImports(t, FormatPEG, []importCase{
+		{
+			name: "classic PEG",
+			src: `# Sums
+Sum    <- Num ('+' Num)*
+Num    <- [1-3] [0-2]? / '0'
+Str    <- '"' (!'"' .)* '"'
+Other  <- !'x' Word &'y' Word
+Word   <- [a-b]+
+`,
+			want: `program: Sum;
+Sum: Num Sum_1*;
+Sum_1: '+' Num;
+Num: Num_1 Num_2? | '0';
+Num_1: '1' | '2' | '3';
+Num_2: '0' | '1' | '2';
+Str: '"' Str_1* '"';
+Str_1: '\t' | ' ' | '!' | '#' | '$' | '%' | '&' | '\'' | '(' | ')' | '*' | '+' | ',' | '-' | '.' | '/' | '0' | '1' | '2' | '3' | '4' | '5' | '6' | '7' | '8' | '9' | ':' | ';' | '<' | '=' | '>' | '?' | '@' | 'A' | 'B' | 'C' | 'D' | 'E' | 'F' | 'G' | 'H' | 'I' | 'J' | 'K' | 'L' | 'M' | 'N' | 'O' | 'P' | 'Q' | 'R' | 'S' | 'T' | 'U' | 'V' | 'W' | 'X' | 'Y' | 'Z' | '[' | '\\' | ']' | '^' | '_' | '` + "`" + `' | 'a' | 'b' | 'c' | 'd' | 'e' | 'f' | 'g' | 'h' | 'i' | 'j' | 'k' | 'l' | 'm' | 'n' | 'o' | 'p' | 'q' | 'r' | 's' | 't' | 'u' | 'v' | 'w' | 'x' | 'y' | 'z' | '{' | '|' | '}' | '~';
+Other: Word Word;
+Word: Word_1+;
+Word_1: 'a' | 'b';
+`,
+			warnings: []string{"Other: lookahead ! dropped, what it rejects can be generated", "Other: lookahead & dropped, what it rejects can be generated"},
+			sample:   "0",
+		},
+		{
+			name: "peg.js",
+			src: `start = head:num tail:("+" n:num { return n; })* { return head; }
+num "number" = $[0-2]+ / "one"i
+check = &{ return true; } "c" / @"d"
+`,
+			want: `program: start;
+start: num start_1*;
+start_1: '+' num;
+num: num_1+ | 'one';
+num_1: '0' | '1' | '2';
+check: 'c' | 'd';
+`,
+			warnings: []string{"check: semantic predicate &{...} dropped, what it rejects can be generated"},
+			sample:   "one",
+		},
+		{
+			name: "pest",
+			src: `WHITESPACE = _{ " " }
+file  = { SOI ~ item* ~ EOI }
+item  = { kw ~ ident }
+kw    = { ^"let" | "var" }
+ident = @{ ASCII_ALPHA ~ ('0'..'2'){1,3} }
+str   = ${ "'" ~ (!"'" ~ ANY)* ~ "'" }
+stack = { PUSH(kw) ~ POP }
+big   = { "a"{20} }
+`,
+			want: `program: file;
+WHITESPACE: ' ';
+file: item*;
+item: kw ident ' ';
+kw: 'let ' | 'var ';
+ident: ident_1 ident_2 ident_3? ident_4?;
+ident_1: 'A' | 'B' | 'C' | 'D' | 'E' | 'F' | 'G' | 'H' | 'I' | 'J' | 'K' | 'L' | 'M' | 'N' | 'O' | 'P' | 'Q' | 'R' | 'S' | 'T' | 'U' | 'V' | 'W' | 'X' | 'Y' | 'Z' | 'a' | 'b' | 'c' | 'd' | 'e' | 'f' | 'g' | 'h' | 'i' | 'j' | 'k' | 'l' | 'm' | 'n' | 'o' | 'p' | 'q' | 'r' | 's' | 't' | 'u' | 'v' | 'w' | 'x' | 'y' | 'z';
+ident_2: '0' | '1' | '2';
+ident_3: '0' | '1' | '2';
+ident_4: '0' | '1' | '2';
+str: '\'' str_1* '\'';
+str_1: '\t' | ' ' | '!' | '"' | '#' | '$' | '%' | '&' | '(' | ')' | '*' | '+' | ',' | '-' | '.' | '/' | '0' | '1' | '2' | '3' | '4' | '5' | '6' | '7' | '8' | '9' | ':' | ';' | '<' | '=' | '>' | '?' | '@' | 'A' | 'B' | 'C' | 'D' | 'E' | 'F' | 'G' | 'H' | 'I' | 'J' | 'K' | 'L' | 'M' | 'N' | 'O' | 'P' | 'Q' | 'R' | 'S' | 'T' | 'U' | 'V' | 'W' | 'X' | 'Y' | 'Z' | '[' | '\\' | ']' | '^' | '_' | '` + "`" + `' | 'a' | 'b' | 'c' | 'd' | 'e' | 'f' | 'g' | 'h' | 'i' | 'j' | 'k' | 'l' | 'm' | 'n' | 'o' | 'p' | 'q' | 'r' | 's' | 't' | 'u' | 'v' | 'w' | 'x' | 'y' | 'z' | '{' | '|' | '}' | '~';
+stack: kw;
+big: 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a ' 'a '+;
+`,
+			warnings: []string{"stack: POP matches text pushed earlier, generated as nothing", "big: repetition counts above 16 are approximated"},
+			sample:   "var Z01 ",
+		},
+	})
+}
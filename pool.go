@@ -0,0 +1,124 @@
+package resrap
+
+import (
+	"context"
+	"time"
+)
+
+// Resize grows or shrinks the worker pool to n goroutines (at least 1).
+// Jobs are never dropped: retired workers finish the job they are on and
+// everything still queued is picked up by the remaining ones.
+func (r *ResrapMT) Resize(n int) {
+	n = max(n, 1)
+	r.poolmu.Lock()
+	defer r.poolmu.Unlock()
+	if r.isShutDown() {
+		return
+	}
+	diff := n - r.workers
+	r.workers = n
+	r.poolsize = n
+	for ; diff > 0; diff-- {
+		go r.mtparser()
+	}
+	if diff < 0 {
+		// Busy workers only notice the stop signal once they are idle, so don't block on them
+		go func(count int) {
+			for ; count > 0; count-- {
+				select {
+				case r.stop <- struct{}{}:
+				case <-r.done:
+					return
+				}
+			}
+		}(-diff)
+	}
+}
+
+// PoolSize returns the number of workers the pool is currently sized to
+func (r *ResrapMT) PoolSize() int {
+	r.poolmu.Lock()
+	defer r.poolmu.Unlock()
+	return r.workers
+}
+
+// QueueDepth returns the number of jobs waiting for a worker
+func (r *ResrapMT) QueueDepth() int {
+	return len(r.pendingjobs)
+}
+
+// AverageLatency returns the moving average time from submitting a job to its completion
+func (r *ResrapMT) AverageLatency() time.Duration {
+	return time.Duration(r.latency.Load())
+}
+
+// observeLatency folds one job into the moving average, weighting the newest job by 1/8
+func (r *ResrapMT) observeLatency(d time.Duration) {
+	r.jobsrun.Add(1)
+	for {
+		old := r.latency.Load()
+		next := int64(d)
+		if old != 0 {
+			next = old + (int64(d)-old)/8
+		}
+		if r.latency.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// AutoscaleConfig bounds and tunes the autoscaler started by Autoscale
+type AutoscaleConfig struct {
+	Min           int           //Smallest pool size, at least 1
+	Max           int           //Largest pool size
+	TargetLatency time.Duration //Grow while the average job latency is above this, 0 to ignore latency
+	TargetQueue   int           //Grow while more jobs than this are waiting, 0 means the current pool size
+	Interval      time.Duration //How often the pool is re-evaluated, defaults to one second
+}
+
+// Autoscale periodically resizes the pool within cfg.Min and cfg.Max based on queue depth and latency.
+// The pool grows by half when the queue or latency is above target, and shrinks by a quarter
+// when the queue is empty and latency is comfortably below target, or no job completed since the
+// last evaluation (the average only moves when jobs complete, so it is stale on an idle pool).
+// It blocks until ctx is cancelled or the pool is shut down, so run it in its own goroutine.
+func (r *ResrapMT) Autoscale(ctx context.Context, cfg AutoscaleConfig) {
+	cfg.Min = max(cfg.Min, 1)
+	cfg.Max = max(cfg.Max, cfg.Min)
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	lastrun := r.jobsrun.Load()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.done:
+			return
+		case <-ticker.C:
+			run := r.jobsrun.Load()
+			idle := run == lastrun
+			lastrun = run
+			r.Resize(autoscaleTarget(cfg, r.PoolSize(), r.QueueDepth(), r.AverageLatency(), idle))
+		}
+	}
+}
+
+// autoscaleTarget decides the next pool size from the current one and the observed load.
+// idle is true when no job completed since the last evaluation.
+func autoscaleTarget(cfg AutoscaleConfig, current, depth int, latency time.Duration, idle bool) int {
+	targetQueue := cfg.TargetQueue
+	if targetQueue <= 0 {
+		targetQueue = current
+	}
+	slow := cfg.TargetLatency > 0 && latency > cfg.TargetLatency
+	relaxed := cfg.TargetLatency == 0 || latency < cfg.TargetLatency/2 || idle
+	next := current
+	if depth > targetQueue || (slow && depth > 0) {
+		next = current + max(current/2, 1)
+	} else if depth == 0 && relaxed {
+		next = current - max(current/4, 1)
+	}
+	return min(max(next, cfg.Min), cfg.Max)
+}
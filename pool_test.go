@@ -0,0 +1,135 @@
+package resrap
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAutoscaleTarget(t *testing.T) {
+	cfg := AutoscaleConfig{Min: 2, Max: 10, TargetLatency: 10 * time.Millisecond}
+	tests := []struct {
+		name    string
+		cfg     AutoscaleConfig
+		current int
+		depth   int
+		latency time.Duration
+		idle    bool
+		want    int
+	}{
+		{"queue above pool size grows by half", cfg, 4, 5, 0, false, 6},
+		{"growth is at least one", cfg, 1, 2, 0, false, 2},
+		{"growth stops at max", cfg, 8, 20, 0, false, 10},
+		{"slow with waiting jobs grows", cfg, 4, 1, 20 * time.Millisecond, false, 6},
+		{"slow without waiting jobs stays", cfg, 4, 0, 20 * time.Millisecond, false, 4},
+		{"queue at target stays", cfg, 4, 4, 0, false, 4},
+		{"empty and fast shrinks by a quarter", cfg, 8, 0, time.Millisecond, false, 6},
+		{"empty between half and full target stays", cfg, 8, 0, 7 * time.Millisecond, false, 8},
+		{"idle shrinks with a stale latency", cfg, 8, 0, 20 * time.Millisecond, true, 6},
+		{"shrinking stops at min", cfg, 2, 0, 0, true, 2},
+		{"explicit target queue", AutoscaleConfig{Min: 1, Max: 10, TargetQueue: 10}, 4, 8, 0, false, 4},
+		{"no latency target shrinks when empty", AutoscaleConfig{Min: 1, Max: 10}, 4, 0, time.Hour, false, 3},
+	}
+	for _, tt := range tests {
+		if got := autoscaleTarget(tt.cfg, tt.current, tt.depth, tt.latency, tt.idle); got != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// poolWithGrammar returns a pool of size workers with the nested grammar, not started
+func poolWithGrammar(t *testing.T, workers, queue int) *ResrapMT {
+	t.Helper()
+	r := NewResrapMT(workers, queue)
+	if err := r.ParseGrammar("nested", nestedGrammar); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestResizeKeepsJobs(t *testing.T) {
+	const jobs = 20
+	r := poolWithGrammar(t, 4, jobs)
+	r.StartResrap()
+	defer r.ShutDownResrap()
+	if r.PoolSize() != 4 {
+		t.Fatalf("pool size %d, want 4", r.PoolSize())
+	}
+	// Nobody reads the results yet, so the workers block and the rest stays queued
+	for i := 0; i < jobs; i++ {
+		r.GenerateWithSeeded(fmt.Sprint(i), "nested", "program", uint64(i), 3)
+	}
+	r.Resize(0)
+	if r.PoolSize() != 1 {
+		t.Errorf("pool size %d after Resize(0), want 1", r.PoolSize())
+	}
+	r.Resize(3)
+	seen := map[string]bool{}
+	for i := 0; i < jobs; i++ {
+		res := <-r.GetCodeChannel()
+		if seen[res.Id] || res.Code != "x" {
+			t.Errorf("job %s: %q, seen before %v", res.Id, res.Code, seen[res.Id])
+		}
+		seen[res.Id] = true
+	}
+	if r.QueueDepth() != 0 {
+		t.Errorf("%d jobs left in the queue", r.QueueDepth())
+	}
+	if r.AverageLatency() <= 0 {
+		t.Errorf("average latency %v after %d jobs", r.AverageLatency(), jobs)
+	}
+}
+
+func TestResizeAfterShutdown(t *testing.T) {
+	r := poolWithGrammar(t, 2, 1)
+	r.StartResrap()
+	r.ShutDownResrap()
+	r.Resize(5)
+	if r.PoolSize() != 2 {
+		t.Errorf("pool size %d after resizing a shut down pool, want 2", r.PoolSize())
+	}
+}
+
+// waitPoolSize polls until the pool has size n, failing after a second
+func waitPoolSize(t *testing.T, r *ResrapMT, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for r.PoolSize() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool size %d, want %d", r.PoolSize(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAutoscale(t *testing.T) {
+	const jobs = 16
+	r := poolWithGrammar(t, 1, jobs)
+	r.StartResrap()
+	defer r.ShutDownResrap()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		r.Autoscale(ctx, AutoscaleConfig{Min: 1, Max: 4, Interval: time.Millisecond})
+		close(stopped)
+	}()
+
+	// The results aren't read, so the queue stays full and the pool grows to its max
+	for i := 0; i < jobs; i++ {
+		r.GenerateWithSeeded(fmt.Sprint(i), "nested", "program", uint64(i), 3)
+	}
+	waitPoolSize(t, r, 4)
+	for i := 0; i < jobs; i++ {
+		<-r.GetCodeChannel()
+	}
+	// Idle, it shrinks back to its min
+	waitPoolSize(t, r, 1)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Autoscale still running after its context was cancelled")
+	}
+}
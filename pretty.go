@@ -0,0 +1,484 @@
+package resrap
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Grammars are pretty-printed from a tree of their rules, read from the scanner's tokens.
+// The colons of consecutive rules are aligned, a blank line starting a new block. Rules too
+// long for a line have one alternative per line, the '|' under the ':'. Comments are kept: a
+// comment on the line of an alternative stays at its end, the others go above the alternative
+// or the rule they are in.
+
+// maxRuleWidth is the width past which a rule with several alternatives gets one alternative per line
+const maxRuleWidth = 80
+
+type fmtRule struct {
+	name    string
+	alts    []*fmtAlt
+	leading []string //Comments above the rule, "" for a blank line
+	pos     int      //Offset of the name
+	end     int      //Offset of the ';'
+}
+
+type fmtAlt struct {
+	items    []*fmtItem
+	prob     string //Probability written after the '|' that closes the alternative
+	first    int    //Offsets of the first and last token of the alternative, -1 when it is empty
+	last     int
+	closer   int //Offset of the '|', ')' or ';' that closes it
+	leading  []string
+	trailing string
+}
+
+// fmtItem is a rule reference, a literal, a regex or a group, with its probability and operators
+type fmtItem struct {
+	tok  token
+	alts []*fmtAlt //Alternatives of a group
+	prob string
+	post []fmtPostfix
+}
+
+type fmtPostfix struct {
+	op   tokenType
+	prob string
+}
+
+// FormatGrammar pretty-prints a grammar. Formatting its result again gives the same text,
+// and parses to the same graph.
+func FormatGrammar(src string) (string, error) {
+	f, comments, err := readGrammarTree(src)
+	if err != nil {
+		return "", err
+	}
+	tail := f.placeComments(comments)
+	for _, r := range f.rules {
+		normalizeChoice(r.alts, true)
+	}
+	return f.print(tail), nil
+}
+
+// readGrammarTree reads the rules of a grammar as written, with its comments
+func readGrammarTree(src string) (*grammarFormatter, []token, error) {
+	gb := newGraphBuilder()
+	if err := gb.start_generation(src); err != nil && (len(gb.pars.errors) > 0 || gb.pars.tokens == nil) {
+		return nil, nil, err //Syntax errors only, rules that are used but not defined are fine
+	}
+	sc := scanner{Input: src}
+	toks, _ := sc.scan()
+	f := &grammarFormatter{src: src, toks: toks}
+	for f.i < len(toks) {
+		r, err := f.rule()
+		if err != nil {
+			return nil, nil, err
+		}
+		f.rules = append(f.rules, r)
+	}
+	return f, sc.comments, nil
+}
+
+type grammarFormatter struct {
+	src   string
+	toks  []token
+	i     int
+	rules []*fmtRule
+}
+
+func (f *grammarFormatter) curr() token {
+	if f.i >= len(f.toks) {
+		return token{typ: eof, pos: len(f.src)}
+	}
+	return f.toks[f.i]
+}
+
+// prob reads the probability after the current token, if any
+func (f *grammarFormatter) prob() string {
+	if f.curr().typ == probability {
+		f.i++
+		return f.toks[f.i-1].text
+	}
+	return ""
+}
+
+func (f *grammarFormatter) rule() (*fmtRule, error) {
+	name := f.curr()
+	if name.typ != identifier || f.i+1 >= len(f.toks) || f.toks[f.i+1].typ != colon {
+		return nil, fmt.Errorf("expected a rule at offset %d", name.pos)
+	}
+	f.i += 2
+	r := &fmtRule{name: name.text, pos: name.pos}
+	alts, err := f.alternatives(padding)
+	if err != nil {
+		return nil, err
+	}
+	r.alts, r.end = alts, alts[len(alts)-1].closer
+	return r, nil
+}
+
+// alternatives reads alternatives up to the closing token, ';' for a rule or ')' for a group
+func (f *grammarFormatter) alternatives(closing tokenType) ([]*fmtAlt, error) {
+	var alts []*fmtAlt
+	for {
+		alt := &fmtAlt{first: -1, last: -1}
+		start := f.i
+		if err := f.sequence(alt); err != nil {
+			return nil, err
+		}
+		if f.i > start {
+			alt.first, alt.last = f.toks[start].pos, f.toks[f.i-1].pos
+		}
+		tok := f.curr()
+		alt.closer = tok.pos
+		alts = append(alts, alt)
+		f.i++
+		switch tok.typ {
+		case option:
+			alt.prob = f.prob()
+		case closing:
+			return alts, nil
+		default:
+			return nil, fmt.Errorf("unexpected %v at offset %d", tok.typ, tok.pos)
+		}
+	}
+}
+
+func (f *grammarFormatter) sequence(alt *fmtAlt) error {
+	for {
+		tok := f.curr()
+		switch tok.typ {
+		case identifier, character, regex:
+			f.i++
+			alt.items = append(alt.items, &fmtItem{tok: tok, prob: f.prob()})
+		case bracopen:
+			f.i++
+			alts, err := f.alternatives(bracclose)
+			if err != nil {
+				return err
+			}
+			alt.items = append(alt.items, &fmtItem{tok: tok, alts: alts})
+		case maybe, oneormore, anyno, infinite:
+			if len(alt.items) == 0 {
+				return fmt.Errorf("nothing to repeat at offset %d", tok.pos)
+			}
+			f.i++
+			last := alt.items[len(alt.items)-1]
+			post := fmtPostfix{op: tok.typ}
+			if tok.typ != infinite {
+				post.prob = f.prob()
+			}
+			last.post = append(last.post, post)
+		default:
+			return nil
+		}
+	}
+}
+
+// placeComments gives every comment to the rule or the alternative it belongs to,
+// returning the comments after the last rule
+func (f *grammarFormatter) placeComments(comments []token) []string {
+	prevEnd := 0 //End of what was placed last, for blank lines
+	placeLeading := func(leading *[]string, c token) {
+		if prevEnd > 0 && f.blankBetween(prevEnd, c.pos) {
+			*leading = append(*leading, "")
+		}
+		*leading = append(*leading, c.text)
+		prevEnd = c.pos + len(c.text)
+	}
+	k := 0
+	for i, r := range f.rules {
+		for ; k < len(comments) && comments[k].pos < r.pos; k++ {
+			placeLeading(&r.leading, comments[k])
+		}
+		if prevEnd > 0 && f.blankBetween(prevEnd, r.pos) {
+			r.leading = append(r.leading, "")
+		}
+		lineEnd := f.lineEnd(r.end)
+		if i+1 < len(f.rules) {
+			lineEnd = min(lineEnd, f.rules[i+1].pos) //Rules sharing a line, the comments after the last are its own
+		}
+		for ; k < len(comments) && comments[k].pos < lineEnd; k++ {
+			f.placeInRule(r, comments[k])
+		}
+		prevEnd = lineEnd
+	}
+	var tail []string
+	for ; k < len(comments); k++ {
+		placeLeading(&tail, comments[k])
+	}
+	return tail
+}
+
+// blankBetween reports whether a blank line separates the offsets from and to
+func (f *grammarFormatter) blankBetween(from, to int) bool {
+	if from >= to {
+		return false
+	}
+	between := f.src[from:to]
+	first := strings.IndexByte(between, '\n')
+	return first >= 0 && strings.TrimSpace(between) == "" && strings.Contains(between[first+1:], "\n")
+}
+
+func (f *grammarFormatter) lineEnd(pos int) int {
+	if end := strings.IndexByte(f.src[pos:], '\n'); end >= 0 {
+		return pos + end
+	}
+	return len(f.src)
+}
+
+// ownLine reports whether only spaces come before pos on its line
+func (f *grammarFormatter) ownLine(pos int) bool {
+	start := strings.LastIndexByte(f.src[:pos], '\n') + 1
+	return strings.TrimSpace(f.src[start:pos]) == ""
+}
+
+// placeInRule places a comment that is inside a rule, or after its ';' on the same line
+func (f *grammarFormatter) placeInRule(r *fmtRule, c token) {
+	i := len(r.alts) - 1
+	for j, alt := range r.alts {
+		if c.pos < alt.closer {
+			i = j
+			break
+		}
+	}
+	alt := r.alts[i]
+	leadOf := func(i int) {
+		if i == 0 {
+			r.leading = append(r.leading, c.text)
+		} else {
+			r.alts[i].leading = append(r.alts[i].leading, c.text)
+		}
+	}
+	switch {
+	case !f.ownLine(c.pos) && alt.trailing == "":
+		alt.trailing = c.text
+	case f.ownLine(c.pos) && alt.first >= 0 && c.pos > alt.last && i < len(r.alts)-1:
+		leadOf(i + 1)
+	default:
+		leadOf(i)
+	}
+}
+
+// normalizeChoice scales the probabilities of alternatives to sum to 1 when they are the only
+// choices of the node they start from, so generation picks them as before. Groups are normalized
+// when nothing before or after them adds choices to that node.
+func normalizeChoice(alts []*fmtAlt, closed bool) {
+	explicit := false
+	for _, alt := range alts {
+		if len(alt.items) == 0 || alt.items[0].alts != nil || alt.items[0].skippable() {
+			closed = false
+			break
+		}
+		explicit = explicit || alt.items[0].prob != ""
+	}
+	if closed && explicit && len(alts) > 1 {
+		weights := make([]float64, len(alts))
+		sum := 0.0
+		for i, alt := range alts {
+			weights[i] = 0.5 //The parser's default
+			if p := alt.items[0].prob; p != "" {
+				weights[i], _ = strconv.ParseFloat(p, 64)
+			}
+			sum += weights[i]
+		}
+		if math.Abs(sum-1) < 0.01 || sum == 0 {
+			sum = 1 //Already normalized, kept as written so formatting again changes nothing
+		}
+		for i, alt := range alts {
+			alt.items[0].prob = formatProb(weights[i] / sum)
+		}
+	}
+
+	for _, alt := range alts {
+		for j, item := range alt.items {
+			if item.alts == nil {
+				continue
+			}
+			// A group starts from the node of the item before it, which loops when repeated
+			prev := (*fmtItem)(nil)
+			if j > 0 {
+				prev = alt.items[j-1]
+			}
+			normalizeChoice(item.alts, prev != nil && prev.alts == nil && !prev.loops() && !item.skippable())
+		}
+	}
+}
+
+// skippable reports whether the item has a '?' or '*', which adds a choice to skip it
+func (it *fmtItem) skippable() bool {
+	for _, p := range it.post {
+		if p.op == maybe || p.op == anyno {
+			return true
+		}
+	}
+	return false
+}
+
+// loops reports whether the item has a '+', '*' or '^', which add choices after it
+func (it *fmtItem) loops() bool {
+	for _, p := range it.post {
+		if p.op == oneormore || p.op == anyno || p.op == infinite {
+			return true
+		}
+	}
+	return false
+}
+
+// formatProb writes a probability with at most 4 decimals, keeping positive ones above 0
+func formatProb(p float64) string {
+	rounded := math.Round(p*1e4) / 1e4
+	if rounded == 0 && p > 0 {
+		rounded = 1e-4
+	}
+	return strconv.FormatFloat(rounded, 'f', -1, 64)
+}
+
+// canonicalProb writes a probability as it was given, in its shortest form
+func canonicalProb(p string) string {
+	v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+	if err != nil {
+		return p
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func probText(p string) string {
+	if p == "" {
+		return ""
+	}
+	return "<" + canonicalProb(p) + ">"
+}
+
+func (it *fmtItem) text() string {
+	var b strings.Builder
+	switch it.tok.typ {
+	case character:
+		b.WriteString("'" + it.tok.text + "'")
+	case regex:
+		b.WriteString("[" + it.tok.text + "]")
+	case bracopen:
+		b.WriteString("(" + altsText(it.alts) + ")")
+	default:
+		b.WriteString(it.tok.text)
+	}
+	b.WriteString(probText(it.prob))
+	for _, p := range it.post {
+		b.WriteString(map[tokenType]string{maybe: "?", oneormore: "+", anyno: "*", infinite: "^"}[p.op])
+		b.WriteString(probText(p.prob))
+	}
+	return b.String()
+}
+
+func (alt *fmtAlt) text() string {
+	parts := make([]string, len(alt.items))
+	for i, it := range alt.items {
+		parts[i] = it.text()
+	}
+	return strings.Join(parts, " ")
+}
+
+// altsText writes alternatives on one line
+func altsText(alts []*fmtAlt) string {
+	var b strings.Builder
+	for i, alt := range alts {
+		if i > 0 {
+			b.WriteString(" |" + probText(alts[i-1].prob))
+			if len(alt.items) > 0 {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(alt.text())
+	}
+	return strings.TrimLeft(b.String(), " ")
+}
+
+func (f *grammarFormatter) print(tail []string) string {
+	var lines []string
+	width := 0 //Width of the names of the current block
+	for i, r := range f.rules {
+		if i == 0 || r.blankAbove() {
+			width = 0
+			for _, next := range f.rules[i:] {
+				if next != r && next.blankAbove() {
+					break
+				}
+				width = max(width, utf8.RuneCountInString(next.name))
+			}
+		}
+		for j, c := range r.leading {
+			if c != "" || len(lines) > 0 && (j == 0 || r.leading[j-1] != "") {
+				lines = append(lines, c)
+			}
+		}
+		lines = append(lines, r.lines(width)...)
+	}
+	for j, c := range tail {
+		if c != "" || len(lines) > 0 && (j == 0 || tail[j-1] != "") {
+			lines = append(lines, c)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// blankAbove reports whether a blank line comes before the rule, starting a new block
+func (r *fmtRule) blankAbove() bool {
+	for _, c := range r.leading {
+		if c == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// lines writes the rule, its name padded to width
+func (r *fmtRule) lines(width int) []string {
+	name := r.name + strings.Repeat(" ", width-utf8.RuneCountInString(r.name)) + ":"
+	last := r.alts[len(r.alts)-1]
+	multi := false
+	for i, alt := range r.alts {
+		multi = multi || i > 0 && len(alt.leading) > 0 || alt != last && alt.trailing != ""
+	}
+	if body := altsText(r.alts); !multi {
+		line := name
+		if body != "" {
+			line += " " + body
+		}
+		line += ";"
+		if len(r.alts) == 1 || utf8.RuneCountInString(line) <= maxRuleWidth {
+			return []string{withComment(line, last.trailing)}
+		}
+	}
+
+	indent := strings.Repeat(" ", width)
+	var lines []string
+	for i, alt := range r.alts {
+		line := name
+		if i > 0 {
+			for _, c := range alt.leading {
+				lines = append(lines, indent+"  "+c)
+			}
+			line = indent + "|" + probText(r.alts[i-1].prob)
+		}
+		if len(alt.items) > 0 {
+			line += " " + alt.text()
+		}
+		if alt == last {
+			line += ";"
+		}
+		lines = append(lines, withComment(line, alt.trailing))
+	}
+	return lines
+}
+
+func withComment(line, comment string) string {
+	if comment == "" {
+		return line
+	}
+	return line + " " + comment
+}
@@ -0,0 +1,96 @@
+package resrap
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+var formatInputs = map[string]string{
+	"one line":    "program: a;a: 'x' | 'y' b;b: [0-9];\n",
+	"shared line": "program: a b; a: 'x'<0.2> | 'y'<0.6>; b: ('z' a)*; // after b\n",
+	"comments": `// Header comment
+
+// Block comment
+program: stmt+; // trailing
+stmt: 'a' // first
+    | 'b' // second
+    // own line, before the third
+    | 'c' <0.5>;
+
+
+// Lone comment between rules
+expr: stmt (' + ' stmt)?;
+// Comment at the end
+`,
+	"no final newline": "program: 'x' | 'y'; // done",
+}
+
+// formatCases returns the inputs along with the example grammars
+func formatCases(t *testing.T) map[string]string {
+	cases := map[string]string{}
+	for name, src := range formatInputs {
+		cases[name] = src
+	}
+	for _, file := range []string{"example/c.g4", "example/sql.g4", "example/Infinity.g4"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cases[file] = string(data)
+	}
+	return cases
+}
+
+func TestFormatGrammarIdempotent(t *testing.T) {
+	for name, src := range formatCases(t) {
+		once, err := FormatGrammar(src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		twice, err := FormatGrammar(once)
+		if err != nil {
+			t.Fatalf("%s: formatted grammar doesn't parse: %v\n%s", name, err, once)
+		}
+		if once != twice {
+			t.Errorf("%s: formatting isn't idempotent\nfirst:\n%s\nsecond:\n%s", name, once, twice)
+		}
+	}
+}
+
+func TestFormatGrammarKeepsComments(t *testing.T) {
+	for name, src := range formatInputs {
+		out, err := FormatGrammar(src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, line := range strings.Split(src, "\n") {
+			if i := strings.Index(line, "//"); i >= 0 && !strings.Contains(out, line[i:]) {
+				t.Errorf("%s: comment %q lost\n%s", name, line[i:], out)
+			}
+		}
+	}
+}
+
+func TestFormatGrammarGeneratesTheSame(t *testing.T) {
+	for name, src := range formatCases(t) {
+		out, err := FormatGrammar(src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		r := NewResrap()
+		if err := r.ParseGrammar("before", src); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := r.ParseGrammar("after", out); err != nil {
+			t.Fatalf("%s: formatted grammar doesn't parse: %v\n%s", name, err, out)
+		}
+		for seed := uint64(0); seed < 20; seed++ {
+			before := r.GenerateWithSeeded("before", "program", seed, 60)
+			after := r.GenerateWithSeeded("after", "program", seed, 60)
+			if before != after {
+				t.Errorf("%s, seed %d: generated %q, formatted grammar %q", name, seed, before, after)
+			}
+		}
+	}
+}
@@ -4,32 +4,60 @@ import (
 	"math/rand"
 )
 
-type prng struct {
-	seed   uint64
-	number uint64
+// DefaultStreamVersion identifies the random stream produced by the default Source for a given seed.
+// It is bumped whenever a change to XorShift64, Random or RandomInt would change generated content,
+// so stored snapshots can record which stream they were generated with.
+//
+// Version 1: xorshift64 with shifts 13, 7, 17; floats are the top 53 bits divided by 2^53;
+// integers in [min, max) are min + int(float*(max-min)); seed 0 starts from state 0x9e3779b97f4a7c15,
+// making it the one seed whose stream is another's.
+const DefaultStreamVersion = 1
+
+// Source is a stream of uniformly distributed 64 bit values driving generation.
+// The sources of math/rand/v2 (rand.PCG, rand.ChaCha8, ...) satisfy it as is.
+type Source interface {
+	Uint64() uint64
 }
 
-func (p *prng) setSeed(seed uint64) {
-	p.number = seed
-	p.seed = seed
+// SourceFactory builds the Source used for a seeded generation
+type SourceFactory func(seed uint64) Source
+
+// XorShift64 is the default Source, see DefaultStreamVersion for its exact stream
+type XorShift64 struct {
+	state uint64
 }
-func (p *prng) generateSeed() {
-	seed := rand.Uint64()
-	p.number = seed
-	p.seed = seed
+
+// NewXorShift64 creates the default Source for seed, every seed including 0 is a valid one.
+// xorshift64 has 2^64-1 usable states for 2^64 seeds, so seed 0 shares the stream of seed
+// 0x9e3779b97f4a7c15, the state it is moved to. Every other pair of seeds has distinct streams.
+func NewXorShift64(seed uint64) *XorShift64 {
+	if seed == 0 {
+		seed = 0x9e3779b97f4a7c15 //xorshift never leaves the all zero state
+	}
+	return &XorShift64{state: seed}
 }
-func (p *prng) nextPRN() uint64 {
+
+func (x *XorShift64) Uint64() uint64 {
 	//Using the XOR shift method for PRN generation
-	p.number ^= p.number << 13
-	p.number ^= p.number >> 7
-	p.number ^= p.number << 17
-	return p.number
+	x.state ^= x.state << 13
+	x.state ^= x.state >> 7
+	x.state ^= x.state << 17
+	return x.state
+}
+
+func defaultSource(seed uint64) Source {
+	return NewXorShift64(seed)
+}
+
+type prng struct {
+	seed uint64
+	src  Source
 }
 
 // random returns a float64 in [0,1)
 func (p *prng) Random() float64 {
 	// Take the next 53 random bits (same precision as math/rand.Float64)
-	v := p.nextPRN() >> 11        // keep top 53 bits
+	v := p.src.Uint64() >> 11     // keep top 53 bits
 	return float64(v) / (1 << 53) // normalize to [0,1)
 }
 
@@ -42,12 +70,25 @@ func (p *prng) RandomInt(min, max int) int {
 	return min + int(r*float64(max-min))
 }
 
-func newPRNG(seed uint64) prng {
-	prng := prng{}
-	if seed == 0 {
-		prng.generateSeed()
-	} else {
-		prng.setSeed(seed)
+// newPRNG creates a prng for seed from factory, the default Source when factory is nil
+func newPRNG(factory SourceFactory, seed uint64) prng {
+	if factory == nil {
+		factory = defaultSource
 	}
-	return prng
+	return prng{seed: seed, src: factory(seed)}
+}
+
+// randomPRNG creates a prng from a freshly picked random seed, readable back from its seed field
+func randomPRNG(factory SourceFactory) prng {
+	return newPRNG(factory, rand.Uint64())
+}
+
+// BatchSeed returns the seed of item i in a batch started from masterSeed.
+// Seeds are the SplitMix64 stream of masterSeed, so any single item of a batch
+// can be regenerated on its own with GenerateWithSeeded.
+func BatchSeed(masterSeed uint64, i int) uint64 {
+	z := masterSeed + uint64(i+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
 }
@@ -0,0 +1,67 @@
+package resrap
+
+import "testing"
+
+// The pinned outputs of docs/PRNG.md, any change to them needs a DefaultStreamVersion bump
+
+func TestXorShift64Stream(t *testing.T) {
+	if DefaultStreamVersion != 1 {
+		t.Fatalf("DefaultStreamVersion is %d, the pinned outputs are those of version 1", DefaultStreamVersion)
+	}
+	src := NewXorShift64(1)
+	for i, want := range []uint64{1082269761, 1152992998833853505, 11177516664432764457} {
+		if got := src.Uint64(); got != want {
+			t.Errorf("value %d: got %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestXorShift64SeedZero(t *testing.T) {
+	src := NewXorShift64(0)
+	for i := 0; i < 3; i++ {
+		if got := src.Uint64(); got == 0 {
+			t.Fatalf("value %d of seed 0 is 0, the stream is stuck", i)
+		}
+	}
+}
+
+func TestPinnedGrammarOutputs(t *testing.T) {
+	r := NewResrap()
+	if err := r.ParseGrammar("digits", "number : [0-9] ('.' [0-9])? ;\nexpr : number (' + ' number)* ;\n"); err != nil {
+		t.Fatal(err)
+	}
+	for seed, want := range map[uint64]string{0: "115", 1: "959 + 558", 42: "135"} {
+		if got := r.GenerateWithSeeded("digits", "expr", seed, 12); got != want {
+			t.Errorf("seed %d: got %q, want %q", seed, got, want)
+		}
+	}
+}
+
+func TestGenerateWithSourceMatchesSeed(t *testing.T) {
+	r := NewResrap()
+	if err := r.ParseGrammarFile("c", "example/c.g4"); err != nil {
+		t.Fatal(err)
+	}
+	for _, seed := range []uint64{0, 1, 42} {
+		seeded := r.GenerateWithSeeded("c", "program", seed, 200)
+		if sourced := r.GenerateWithSource("c", "program", NewXorShift64(seed), 200); sourced != seeded {
+			t.Errorf("seed %d: GenerateWithSource gave %q, GenerateWithSeeded %q", seed, sourced, seeded)
+		}
+	}
+}
+
+func TestPinnedExampleOutputs(t *testing.T) {
+	r := NewResrap()
+	if err := r.ParseGrammarFile("c", "example/c.g4"); err != nil {
+		t.Fatal(err)
+	}
+	pinned := map[uint64]string{
+		1:  "double wgn(){\nfloat oec = age;\nif(aos > iom || gee < 179.360){\ndouble wlr = 891.",
+		42: "int sgh(){\nfloat coi = 068;\nif(mjj > zni){\nint rei = 404.286;\n}\nif(",
+	}
+	for seed, want := range pinned {
+		if got := r.GenerateWithSeeded("c", "program", seed, 20); got != want {
+			t.Errorf("seed %d: got %q, want %q", seed, got, want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package resrap
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Railroad diagrams draw every rule as a track read from left to right: rounded boxes are
+// literals and regexes, square ones are rules. Alternatives branch below the first one and
+// repetitions loop back under what they repeat. Probabilities are written in small text on
+// the track they weight, in Resrap's notation.
+
+const (
+	rrArc    = 10.0 //Radius of the curves
+	rrGap    = 10.0 //Track between elements of a sequence
+	rrBox    = 22.0 //Height of the boxes
+	rrChar   = 8.0  //Width of a character of the boxes
+	rrSmall  = 6.0  //Width of a character of the probabilities
+	rrVGap   = 8.0  //Space between stacked tracks
+	rrMargin = 20.0
+	rrTitle  = 24.0 //Height of the rule names
+)
+
+// rrElem is a piece of track, entered on the left and left on the right at the same height.
+// up and down are how far it reaches above and below its track.
+type rrElem interface {
+	dims() (w, up, down float64)
+	draw(b *strings.Builder, x, y float64)
+}
+
+type rrDims struct{ w, up, down float64 }
+
+func (d rrDims) dims() (float64, float64, float64) { return d.w, d.up, d.down }
+
+type rrBoxElem struct {
+	rrDims
+	text    string
+	rounded bool //Literals and regexes, rules are square
+}
+
+type rrLabel struct {
+	rrDims
+	text string
+}
+
+type rrSeq struct {
+	rrDims
+	items []rrElem
+}
+
+type rrChoice struct {
+	rrDims
+	alts    []rrElem
+	offsets []float64 //Distance of each alternative below the track
+	inner   float64   //Width of the widest alternative
+}
+
+type rrLoop struct {
+	rrDims
+	item  rrElem
+	label string
+	depth float64 //Distance of the way back below the track
+}
+
+func newBox(text string, rounded bool) rrElem {
+	w := float64(utf8.RuneCountInString(text))*rrChar + 2*rrArc
+	return &rrBoxElem{rrDims{w, rrBox / 2, rrBox / 2}, text, rounded}
+}
+
+func newLabel(text string) rrElem {
+	return &rrLabel{rrDims{float64(utf8.RuneCountInString(text))*rrSmall + 6, 14, 0}, text}
+}
+
+func newSeq(items ...rrElem) rrElem {
+	s := &rrSeq{items: items}
+	for i, it := range items {
+		w, up, down := it.dims()
+		if i > 0 {
+			s.w += rrGap
+		}
+		s.w += w
+		s.up, s.down = max(s.up, up), max(s.down, down)
+	}
+	return s
+}
+
+func newChoice(alts ...rrElem) rrElem {
+	if len(alts) == 1 {
+		return alts[0]
+	}
+	c := &rrChoice{alts: alts, offsets: make([]float64, len(alts))}
+	_, c.up, c.down = alts[0].dims()
+	for i, alt := range alts {
+		w, up, down := alt.dims()
+		c.inner = max(c.inner, w)
+		if i > 0 {
+			c.offsets[i] = max(c.down+rrVGap+up, 2*rrArc)
+			c.down = c.offsets[i] + down
+		}
+	}
+	c.w = c.inner + 4*rrArc
+	return c
+}
+
+func newLoop(item rrElem, label string) rrElem {
+	w, up, down := item.dims()
+	l := &rrLoop{item: item, label: label, depth: max(down+rrVGap, 2*rrArc)}
+	l.rrDims = rrDims{w + 2*rrArc, up, l.depth}
+	if label != "" {
+		l.w = max(l.w, float64(utf8.RuneCountInString(label))*rrSmall+4*rrArc)
+		l.down += 14
+	}
+	return l
+}
+
+func (e *rrBoxElem) draw(b *strings.Builder, x, y float64) {
+	radius := 0.0
+	if e.rounded {
+		radius = rrBox / 2
+	}
+	fmt.Fprintf(b, `<rect x="%g" y="%g" width="%g" height="%g" rx="%g"/>`+"\n", x, y-rrBox/2, e.w, rrBox, radius)
+	fmt.Fprintf(b, `<text x="%g" y="%g" text-anchor="middle">%s</text>`+"\n", x+e.w/2, y+4, xmlText(e.text))
+}
+
+func (e *rrLabel) draw(b *strings.Builder, x, y float64) {
+	fmt.Fprintf(b, `<path d="M%g %gh%g"/>`+"\n", x, y, e.w)
+	fmt.Fprintf(b, `<text class="prob" x="%g" y="%g">%s</text>`+"\n", x+3, y-4, xmlText(e.text))
+}
+
+func (e *rrSeq) draw(b *strings.Builder, x, y float64) {
+	for i, it := range e.items {
+		if i > 0 {
+			fmt.Fprintf(b, `<path d="M%g %gh%g"/>`+"\n", x, y, rrGap)
+			x += rrGap
+		}
+		it.draw(b, x, y)
+		w, _, _ := it.dims()
+		x += w
+	}
+}
+
+func (e *rrChoice) draw(b *strings.Builder, x, y float64) {
+	r, in, out := rrArc, x+2*rrArc, x+2*rrArc+e.inner
+	for i, alt := range e.alts {
+		w, _, _ := alt.dims()
+		off := e.offsets[i]
+		if i == 0 {
+			fmt.Fprintf(b, `<path d="M%g %gh%g"/>`+"\n", x, y, 2*r)
+		} else {
+			fmt.Fprintf(b, `<path d="M%g %ga%g %g 0 0 1 %g %gV%ga%g %g 0 0 0 %g %g"/>`+"\n",
+				x, y, r, r, r, r, y+off-r, r, r, r, r)
+		}
+		alt.draw(b, in, y+off)
+		if i == 0 {
+			fmt.Fprintf(b, `<path d="M%g %gH%g"/>`+"\n", in+w, y, x+e.w)
+		} else {
+			fmt.Fprintf(b, `<path d="M%g %gH%ga%g %g 0 0 0 %g %gV%ga%g %g 0 0 1 %g %g"/>`+"\n",
+				in+w, y+off, out, r, r, r, -r, y+r, r, r, r, -r)
+		}
+	}
+}
+
+func (e *rrLoop) draw(b *strings.Builder, x, y float64) {
+	r := rrArc
+	w, _, _ := e.item.dims()
+	in := x + (e.w-w)/2 //The item is centered over a long label
+	fmt.Fprintf(b, `<path d="M%g %gH%g"/>`+"\n", x, y, in)
+	e.item.draw(b, in, y)
+	fmt.Fprintf(b, `<path d="M%g %gH%g"/>`+"\n", in+w, y, x+e.w)
+	fmt.Fprintf(b, `<path d="M%g %ga%g %g 0 0 1 %g %gV%ga%g %g 0 0 1 %g %gH%ga%g %g 0 0 1 %g %gV%ga%g %g 0 0 1 %g %g"/>`+"\n",
+		x+e.w-r, y, r, r, r, r, y+e.depth-r, r, r, -r, r, x+r, r, r, -r, -r, y+r, r, r, r, -r)
+	if e.label != "" {
+		fmt.Fprintf(b, `<text class="prob" x="%g" y="%g" text-anchor="middle">%s</text>`+"\n", x+e.w/2, y+e.depth+12, xmlText(e.label))
+	}
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func xmlText(s string) string { return xmlEscaper.Replace(s) }
+
+// railroadItem builds the track of an element with its probability and operators
+func railroadItem(it *fmtItem) rrElem {
+	var el rrElem
+	switch it.tok.typ {
+	case identifier:
+		el = newBox(it.tok.text, false)
+	case character:
+		el = newBox("'"+it.tok.text+"'", true)
+	case regex:
+		el = newBox("["+it.tok.text+"]", true)
+	default:
+		el = railroadAlts(it.alts)
+	}
+	if it.prob != "" {
+		el = newSeq(newLabel(probText(it.prob)), el)
+	}
+	for _, p := range it.post {
+		switch p.op {
+		case maybe:
+			el = newChoice(el, newSeq(newLabel("?"+probText(p.prob))))
+		case oneormore:
+			label := ""
+			if p.prob != "" {
+				label = "+" + probText(p.prob)
+			}
+			el = newLoop(el, label)
+		case anyno:
+			el = newChoice(newLoop(el, ""), newSeq(newLabel("*"+probText(p.prob))))
+		case infinite:
+			el = newLoop(el, "^")
+		}
+	}
+	return el
+}
+
+func railroadAlts(alts []*fmtAlt) rrElem {
+	tracks := make([]rrElem, len(alts))
+	for i, alt := range alts {
+		items := make([]rrElem, 0, len(alt.items)+1)
+		for _, it := range alt.items {
+			items = append(items, railroadItem(it))
+		}
+		if i < len(alts)-1 && alt.prob != "" {
+			items = append(items, newLabel("|"+probText(alt.prob)))
+		}
+		tracks[i] = newSeq(items...)
+	}
+	return newChoice(tracks...)
+}
+
+// exportRailroad draws the railroad diagrams of the rules, one under the other
+func exportRailroad(name string, rules []*fmtRule) string {
+	var body strings.Builder
+	width, y := 0.0, rrMargin
+	for _, r := range rules {
+		track := railroadAlts(r.alts)
+		w, up, down := track.dims()
+		fmt.Fprintf(&body, `<g id="%s">`+"\n", xmlText(r.name))
+		fmt.Fprintf(&body, `<text class="rule" x="%g" y="%g">%s</text>`+"\n", rrMargin, y+14, xmlText(r.name))
+		base := y + rrTitle + up
+		x := rrMargin
+		fmt.Fprintf(&body, `<path d="M%g %gv%gM%g %gh%g"/>`+"\n", x, base-rrBox/2, rrBox, x, base, rrGap) //Start of the track
+		track.draw(&body, x+rrGap, base)
+		end := x + rrGap + w
+		fmt.Fprintf(&body, `<path d="M%g %gh%gv%gm0 %gv%g"/>`+"\n", end, base, rrGap, -rrBox/2, rrBox/2, rrBox/2)
+		body.WriteString("</g>\n")
+		width = max(width, end+rrGap+rrMargin)
+		y = base + down + rrMargin
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g">`+"\n", width, y, width, y)
+	fmt.Fprintf(&b, "<title>%s</title>\n", xmlText(name))
+	b.WriteString("<style>path{fill:none;stroke:#333;stroke-width:1.5}rect{fill:#eef3ff;stroke:#333;stroke-width:1.5}" +
+		"text{font-family:monospace;font-size:13px}text.prob{font-size:10px;fill:#666}text.rule{font-weight:bold}</style>\n")
+	b.WriteString(`<rect width="100%" height="100%" style="fill:#fff;stroke:none"/>` + "\n")
+	b.WriteString(body.String())
+	b.WriteString("</svg>\n")
+	return b.String()
+}
@@ -8,12 +8,13 @@ import (
 // scanner will take all the components and convert them to a series of tokens
 
 type scanner struct {
-	Input  string
-	pos    int // current byte offset
-	width  int // width of last rune
-	currR  rune
-	lineno int
-	tokens []token
+	Input    string
+	pos      int // current byte offset
+	width    int // width of last rune
+	currR    rune
+	lineno   int
+	tokens   []token
+	comments []token // '//' comments, kept apart from the tokens the parser reads
 }
 
 func extracttokens(inp string) ([]token, []ScanError) {
@@ -72,6 +73,10 @@ func (s *scanner) scanDelimited(open, close rune, allowEscapes bool) (string, *S
 		if r == close {
 			return buf, nil
 		}
+		if allowEscapes && r == '\\' && s.peek() != -1 {
+			buf += string(r) + string(s.next()) //Kept escaped, unescapeString decodes it when generating
+			continue
+		}
 
 		buf += string(r)
 	}
@@ -108,31 +113,40 @@ func (s *scanner) scanIdentifier() string {
 func (s *scanner) scan() ([]token, []ScanError) {
 	var errs []ScanError
 	for s.next() != -1 {
+		start := s.pos - s.width
 		switch s.currR {
+		case '/':
+			if s.peek() != '/' {
+				break
+			}
+			for r := s.peek(); r != '\n' && r != -1; r = s.peek() {
+				s.next()
+			}
+			s.comments = append(s.comments, token{0, comment, s.Input[start:s.pos], start})
 		case '+':
-			s.tokens = append(s.tokens, token{0, oneormore, ""})
+			s.tokens = append(s.tokens, token{0, oneormore, "", start})
 		case '*':
-			s.tokens = append(s.tokens, token{0, anyno, ""})
+			s.tokens = append(s.tokens, token{0, anyno, "", start})
 		case '^':
-			s.tokens = append(s.tokens, token{0, infinite, ""})
+			s.tokens = append(s.tokens, token{0, infinite, "", start})
 		case '?':
-			s.tokens = append(s.tokens, token{0, maybe, ""})
+			s.tokens = append(s.tokens, token{0, maybe, "", start})
 		case '|':
-			s.tokens = append(s.tokens, token{0, option, ""})
+			s.tokens = append(s.tokens, token{0, option, "", start})
 		case ';':
-			s.tokens = append(s.tokens, token{0, padding, ""})
+			s.tokens = append(s.tokens, token{0, padding, "", start})
 		case '(':
-			s.tokens = append(s.tokens, token{0, bracopen, ""})
+			s.tokens = append(s.tokens, token{0, bracopen, "", start})
 		case ')':
-			s.tokens = append(s.tokens, token{0, bracclose, ""})
+			s.tokens = append(s.tokens, token{0, bracclose, "", start})
 		case ':':
-			s.tokens = append(s.tokens, token{0, colon, ""})
+			s.tokens = append(s.tokens, token{0, colon, "", start})
 		case '\'':
-			val, err := s.scanDelimited('\'', '\'', false)
+			val, err := s.scanDelimited('\'', '\'', true)
 			if err != nil {
 				errs = append(errs, *err)
 			} else {
-				s.tokens = append(s.tokens, token{0, character, val})
+				s.tokens = append(s.tokens, token{0, character, val, start})
 			}
 
 		case '<':
@@ -140,7 +154,7 @@ func (s *scanner) scan() ([]token, []ScanError) {
 			if err != nil {
 				errs = append(errs, *err)
 			} else {
-				s.tokens = append(s.tokens, token{0, probability, val})
+				s.tokens = append(s.tokens, token{0, probability, val, start})
 			}
 
 		case '[':
@@ -148,13 +162,13 @@ func (s *scanner) scan() ([]token, []ScanError) {
 			if err != nil {
 				errs = append(errs, *err)
 			} else {
-				s.tokens = append(s.tokens, token{0, regex, val})
+				s.tokens = append(s.tokens, token{0, regex, val, start})
 			}
 		default:
 			if isIdentStart(s.currR) {
 				buff := s.scanIdentifier()
 				if buff != "" {
-					s.tokens = append(s.tokens, token{0, identifier, buff})
+					s.tokens = append(s.tokens, token{0, identifier, buff, start})
 				}
 
 			}
@@ -0,0 +1,48 @@
+package resrap
+
+import "testing"
+
+func TestRandomSeedReplays(t *testing.T) {
+	r := NewResrap()
+	if err := r.ParseGrammarFile("c", "example/c.g4"); err != nil {
+		t.Fatal(err)
+	}
+	seeds := map[uint64]bool{}
+	for i := 0; i < 8; i++ {
+		code, seed := r.GenerateRandomWithSeed("c", "program", 60)
+		if replay := r.GenerateWithSeeded("c", "program", seed, 60); replay != code {
+			t.Errorf("seed %d: replay differs\n%s\n%s", seed, code, replay)
+		}
+		seeds[seed] = true
+	}
+	if len(seeds) < 2 {
+		t.Error("random generations all picked the same seed")
+	}
+}
+
+func TestPoolResultSeedReplays(t *testing.T) {
+	r := NewResrap()
+	mt := NewResrapMT(2, 8)
+	if err := r.ParseGrammarFile("c", "example/c.g4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mt.ParseGrammarFile("c", "example/c.g4"); err != nil {
+		t.Fatal(err)
+	}
+	mt.StartResrap()
+	defer mt.ShutDownResrap()
+	mt.GenerateRandom("random", "c", "program", 60)
+	mt.GenerateWithSeeded("seeded", "c", "program", 0, 60)
+	for i := 0; i < 2; i++ {
+		res := <-mt.GetCodeChannel()
+		if res.Id == "seeded" && res.Seed != 0 {
+			t.Errorf("seeded job reported seed %d, want 0", res.Seed)
+		}
+		if replay := r.GenerateWithSeeded("c", "program", res.Seed, 60); replay != res.Code {
+			t.Errorf("%s job: seed %d replays different code", res.Id, res.Seed)
+		}
+		if res.Tokens == 0 {
+			t.Errorf("%s job: no tokens reported", res.Id)
+		}
+	}
+}
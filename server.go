@@ -0,0 +1,320 @@
+package resrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ServerConfig bounds what a Server accepts, zero values pick the defaults
+type ServerConfig struct {
+	MaxBodyBytes int64         // Largest request body, 1 MiB by default
+	MaxTokens    int           // Largest number of tokens per output, 100000 by default
+	MaxBatch     int           // Largest batch, 1000 outputs by default
+	Timeout      time.Duration // Time a request may take on the worker pool, 10 seconds by default
+	ReadOnly     bool          // Reject grammar uploads
+}
+
+func (c ServerConfig) withDefaults() ServerConfig {
+	if c.MaxBodyBytes <= 0 {
+		c.MaxBodyBytes = 1 << 20
+	}
+	if c.MaxTokens <= 0 {
+		c.MaxTokens = 100000
+	}
+	if c.MaxBatch <= 0 {
+		c.MaxBatch = 1000
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	return c
+}
+
+// Server is an http.Handler serving the grammars of a ResrapMT as a JSON API:
+//
+//	GET  /grammars                 list the grammars and their rules
+//	GET  /grammars/{name}          rules and lint diagnostics of a grammar (?start=rule, program by default)
+//	PUT  /grammars/{name}          upload or replace a grammar, the body being its Resrap or ANTLR4 text (?start=rule)
+//	POST /grammars/{name}/generate {"start", "seed", "tokens"} -> {"code", "seed", "tokens"}
+//	POST /grammars/{name}/batch    {"start", "seed", "tokens", "count"} -> {"seed", "codes"}
+//
+// Jobs run on the pool of the ResrapMT, which should be started. Errors are returned as
+// {"error": {"code", "message", "diagnostics"}} with a matching status code.
+type Server struct {
+	pool *ResrapMT
+	cfg  ServerConfig
+	mux  *http.ServeMux
+	mu   sync.Mutex //Serializes uploads
+}
+
+// NewServer creates a Server generating on pool
+func NewServer(pool *ResrapMT, cfg ServerConfig) *Server {
+	s := &Server{pool: pool, cfg: cfg.withDefaults(), mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /grammars", s.listGrammars)
+	s.mux.HandleFunc("GET /grammars/{name}", s.getGrammar)
+	s.mux.HandleFunc("PUT /grammars/{name}", s.putGrammar)
+	s.mux.HandleFunc("POST /grammars/{name}/generate", s.generate)
+	s.mux.HandleFunc("POST /grammars/{name}/batch", s.batch)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, newAPIError(http.StatusNotFound, "not_found", "no endpoint at %s", req.URL.Path))
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+// apiError is the error body of every failed request
+type apiError struct {
+	status      int
+	Code        string       `json:"code"`
+	Message     string       `json:"message"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+func newAPIError(status int, code, format string, args ...any) *apiError {
+	return &apiError{status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// grammarInfo describes a loaded grammar
+type grammarInfo struct {
+	Name        string       `json:"name"`
+	Rules       []string     `json:"rules"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// generateRequest is the body of generate and batch requests
+type generateRequest struct {
+	Start  string  `json:"start"`  // program by default
+	Seed   *uint64 `json:"seed"`   // Random when missing
+	Tokens int     `json:"tokens"` // 100 by default
+	Count  int     `json:"count"`  // Batch size
+}
+
+type generateResponse struct {
+	Code   string `json:"code"`
+	Seed   uint64 `json:"seed"`
+	Tokens int    `json:"tokens"`
+}
+
+type batchResponse struct {
+	Seed  uint64   `json:"seed"` // Master seed, output i was generated with BatchSeed(seed, i)
+	Codes []string `json:"codes"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		apiErr = newAPIError(http.StatusInternalServerError, "internal", "%v", err)
+	}
+	writeJSON(w, apiErr.status, map[string]*apiError{"error": apiErr})
+}
+
+// readBody reads a request body of at most MaxBodyBytes
+func (s *Server) readBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, s.cfg.MaxBodyBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, newAPIError(http.StatusRequestEntityTooLarge, "too_large", "request body is larger than %d bytes", tooLarge.Limit)
+	}
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "bad_request", "reading request body: %v", err)
+	}
+	return body, nil
+}
+
+// grammarName reads the grammar name of the request path, letters, digits, '_', '-' and '.' only
+func grammarName(req *http.Request) (string, error) {
+	name := req.PathValue("name")
+	for _, c := range name {
+		if !isIdentPart(c) && c != '-' && c != '.' {
+			return "", newAPIError(http.StatusBadRequest, "bad_request", "invalid grammar name '%s'", name)
+		}
+	}
+	return name, nil
+}
+
+// startRule returns the ?start= query parameter, program by default
+func startRule(req *http.Request) string {
+	if start := req.URL.Query().Get("start"); start != "" {
+		return start
+	}
+	return "program"
+}
+
+func (s *Server) listGrammars(w http.ResponseWriter, req *http.Request) {
+	graphs := s.pool.graphs()
+	list := []grammarInfo{}
+	for _, name := range slices.Sorted(maps.Keys(graphs)) {
+		list = append(list, grammarInfo{Name: name, Rules: graphs[name].rules()})
+	}
+	writeJSON(w, http.StatusOK, map[string][]grammarInfo{"grammars": list})
+}
+
+func (s *Server) getGrammar(w http.ResponseWriter, req *http.Request) {
+	name, err := grammarName(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	l, ok := s.pool.graphs()[name]
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotFound, "grammar_not_found", "grammar '%s' not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, grammarInfo{Name: name, Rules: l.rules(), Diagnostics: lintLang(name, l, true, startRule(req))})
+}
+
+func (s *Server) putGrammar(w http.ResponseWriter, req *http.Request) {
+	if s.cfg.ReadOnly {
+		writeError(w, newAPIError(http.StatusForbidden, "read_only", "grammar uploads are disabled"))
+		return
+	}
+	name, err := grammarName(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := s.readBody(w, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Broken grammars are rejected, leaving the one already loaded in place
+	l := importLang(string(body), DetectGrammarFormat(string(body)))
+	diags := lintLang(name, l, true, startRule(req))
+	if len(diags) > 0 && diags[0].Severity == SeverityError {
+		apiErr := newAPIError(http.StatusUnprocessableEntity, "invalid_grammar", "grammar '%s' has errors", name)
+		apiErr.Diagnostics = diags
+		writeError(w, apiErr)
+		return
+	}
+
+	s.mu.Lock()
+	_, replaced := s.pool.graphs()[name]
+	s.pool.store(name, l)
+	s.mu.Unlock()
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, grammarInfo{Name: name, Rules: l.rules(), Diagnostics: diags})
+}
+
+// generateJob decodes a generate or batch request and checks it against the grammar it targets
+func (s *Server) generateJob(w http.ResponseWriter, req *http.Request) (generateRequest, string, error) {
+	var body generateRequest
+	name, err := grammarName(req)
+	if err != nil {
+		return body, "", err
+	}
+	data, err := s.readBody(w, req)
+	if err != nil {
+		return body, "", err
+	}
+	if len(data) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			return body, "", newAPIError(http.StatusBadRequest, "bad_request", "invalid request: %v", err)
+		}
+	}
+	if body.Start == "" {
+		body.Start = "program"
+	}
+	if body.Tokens == 0 {
+		body.Tokens = 100
+	}
+	if body.Tokens < 0 || body.Tokens > s.cfg.MaxTokens {
+		return body, "", newAPIError(http.StatusBadRequest, "bad_request", "tokens must be between 1 and %d", s.cfg.MaxTokens)
+	}
+
+	l, ok := s.pool.graphs()[name]
+	if !ok || l.graph == nil {
+		return body, "", newAPIError(http.StatusNotFound, "grammar_not_found", "grammar '%s' not found", name)
+	}
+	if l.err != nil {
+		apiErr := newAPIError(http.StatusUnprocessableEntity, "invalid_grammar", "grammar '%s' has errors", name)
+		apiErr.Diagnostics = lintLang(name, l, true, body.Start)
+		return body, "", apiErr
+	}
+	if _, ok := l.graph.namemap[body.Start]; !ok {
+		return body, "", newAPIError(http.StatusBadRequest, "start_not_found", "starting node '%s' not found in grammar '%s'", body.Start, name)
+	}
+	return body, name, nil
+}
+
+// poolError maps a pool failure to a response, timeouts and cancellations leaving the job unfinished
+func poolError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newAPIError(http.StatusServiceUnavailable, "timeout", "generation didn't complete in time, the worker pool is busy or the request is too large")
+	}
+	if errors.Is(err, context.Canceled) {
+		return newAPIError(http.StatusServiceUnavailable, "canceled", "request canceled")
+	}
+	return err
+}
+
+func (s *Server) generate(w http.ResponseWriter, req *http.Request) {
+	body, name, err := s.generateJob(w, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	job := codeGenReq{name: name, startnode: body.Start, tokens: body.Tokens, random: body.Seed == nil}
+	if body.Seed != nil {
+		job.seed = *body.Seed
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), s.cfg.Timeout)
+	defer cancel()
+	res, err := s.pool.generate(ctx, job)
+	if err != nil {
+		writeError(w, poolError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, generateResponse{Code: res.Code, Seed: res.Seed, Tokens: res.Tokens})
+}
+
+func (s *Server) batch(w http.ResponseWriter, req *http.Request) {
+	body, name, err := s.generateJob(w, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if body.Count <= 0 || body.Count > s.cfg.MaxBatch {
+		writeError(w, newAPIError(http.StatusBadRequest, "bad_request", "count must be between 1 and %d", s.cfg.MaxBatch))
+		return
+	}
+	seed := rand.Uint64()
+	if body.Seed != nil {
+		seed = *body.Seed
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), s.cfg.Timeout)
+	defer cancel()
+	codes, err := s.pool.GenerateBatch(ctx, name, body.Start, body.Count, body.Tokens, seed)
+	if err != nil {
+		writeError(w, poolError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, batchResponse{Seed: seed, Codes: codes})
+}
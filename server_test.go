@@ -0,0 +1,238 @@
+package resrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const digitsGrammar = "number : [0-9] ('.' [0-9])? ;\nexpr : number (' + ' number)* ;\nprogram : expr ;\n"
+
+// testServer serves a started pool holding the digits grammar
+func testServer(t *testing.T, cfg ServerConfig) (*httptest.Server, *ResrapMT) {
+	t.Helper()
+	pool := NewResrapMT(2, 8)
+	if err := pool.ParseGrammar("digits", digitsGrammar); err != nil {
+		t.Fatal(err)
+	}
+	pool.StartResrap()
+	srv := httptest.NewServer(NewServer(pool, cfg))
+	t.Cleanup(func() {
+		srv.Close()
+		pool.ShutDownResrap()
+	})
+	return srv, pool
+}
+
+// call sends body to srv and decodes the JSON response into out, returning the status code
+func call(t *testing.T, srv *httptest.Server, method, path, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: content type %q", method, path, ct)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// jsonDiagnostic is a Diagnostic as the API writes it
+type jsonDiagnostic struct {
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+// jsonGrammar is the grammarInfo of responses
+type jsonGrammar struct {
+	Name        string           `json:"name"`
+	Rules       []string         `json:"rules"`
+	Diagnostics []jsonDiagnostic `json:"diagnostics"`
+}
+
+// errorBody is the body of failed requests
+type errorBody struct {
+	Error struct {
+		Code        string           `json:"code"`
+		Message     string           `json:"message"`
+		Diagnostics []jsonDiagnostic `json:"diagnostics"`
+	} `json:"error"`
+}
+
+// expectError checks that a request fails with status and code
+func expectError(t *testing.T, srv *httptest.Server, method, path, body string, status int, code string) errorBody {
+	t.Helper()
+	var e errorBody
+	if got := call(t, srv, method, path, body, &e); got != status || e.Error.Code != code {
+		t.Errorf("%s %s: got %d %q (%s), want %d %q", method, path, got, e.Error.Code, e.Error.Message, status, code)
+	}
+	return e
+}
+
+func TestServerGrammars(t *testing.T) {
+	srv, _ := testServer(t, ServerConfig{})
+
+	var list struct{ Grammars []jsonGrammar }
+	if status := call(t, srv, "GET", "/grammars", "", &list); status != http.StatusOK || len(list.Grammars) != 1 || list.Grammars[0].Name != "digits" {
+		t.Fatalf("got %d %+v, want the digits grammar", status, list)
+	}
+	var info jsonGrammar
+	if status := call(t, srv, "GET", "/grammars/digits?start=expr", "", &info); status != http.StatusOK || !slices.Equal(info.Rules, []string{"expr", "number", "program"}) ||
+		len(info.Diagnostics) != 1 || info.Diagnostics[0].Rule != "program" {
+		t.Errorf("got %d %+v", status, info)
+	}
+
+	if status := call(t, srv, "PUT", "/grammars/words", "program : 'a' word ;\nword : 'b' ;\nunused : 'c' ;\n", &info); status != http.StatusCreated {
+		t.Errorf("new grammar: status %d, want 201", status)
+	}
+	if len(info.Diagnostics) != 1 || info.Diagnostics[0].Rule != "unused" {
+		t.Errorf("warnings %v, want the unused rule", info.Diagnostics)
+	}
+	if status := call(t, srv, "PUT", "/grammars/words", "program : 'a' ;\n", &info); status != http.StatusOK {
+		t.Errorf("replaced grammar: status %d, want 200", status)
+	}
+	if status := call(t, srv, "PUT", "/grammars/expr", "grammar E;\ne : 'x' ;\n", &info); status != http.StatusCreated || !slices.Contains(info.Rules, "e") {
+		t.Errorf("ANTLR4 grammar: got %d %+v", status, info)
+	}
+
+	e := expectError(t, srv, "PUT", "/grammars/words", "program : 'a' missing ;\n", http.StatusUnprocessableEntity, "invalid_grammar")
+	if len(e.Error.Diagnostics) == 0 || e.Error.Diagnostics[0].Severity != "error" {
+		t.Errorf("diagnostics %v, want an error first", e.Error.Diagnostics)
+	}
+	e = expectError(t, srv, "PUT", "/grammars/loop", "program : a ;\na : a ;\n", http.StatusUnprocessableEntity, "invalid_grammar")
+	if !slices.ContainsFunc(e.Error.Diagnostics, func(d jsonDiagnostic) bool { return strings.Contains(d.Message, "never end") }) {
+		t.Errorf("diagnostics %v, want the never-ending rule", e.Error.Diagnostics)
+	}
+	// The rejected upload left the grammar in place
+	if call(t, srv, "GET", "/grammars/words", "", &info); !slices.Equal(info.Rules, []string{"program"}) {
+		t.Errorf("rules %v after a rejected upload", info.Rules)
+	}
+
+	expectError(t, srv, "GET", "/grammars/nope", "", http.StatusNotFound, "grammar_not_found")
+	expectError(t, srv, "GET", "/grammars/a%20b", "", http.StatusBadRequest, "bad_request")
+	expectError(t, srv, "DELETE", "/nowhere", "", http.StatusNotFound, "not_found")
+
+	ro, _ := testServer(t, ServerConfig{ReadOnly: true})
+	expectError(t, ro, "PUT", "/grammars/words", "program : 'a' ;\n", http.StatusForbidden, "read_only")
+}
+
+func TestServerGenerate(t *testing.T) {
+	srv, _ := testServer(t, ServerConfig{MaxTokens: 200, MaxBatch: 10})
+	r := NewResrap()
+	if err := r.ParseGrammar("digits", digitsGrammar); err != nil {
+		t.Fatal(err)
+	}
+
+	var res generateResponse
+	if status := call(t, srv, "POST", "/grammars/digits/generate", `{"seed": 1, "tokens": 12, "start": "expr"}`, &res); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if want := r.GenerateWithSeeded("digits", "expr", 1, 12); res.Code != want || res.Seed != 1 || res.Tokens == 0 {
+		t.Errorf("got %+v, want code %q with seed 1", res, want)
+	}
+	// A random generation returns the seed that replays it
+	res = generateResponse{}
+	if status := call(t, srv, "POST", "/grammars/digits/generate", "", &res); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if want := r.GenerateWithSeeded("digits", "program", res.Seed, 100); res.Code != want {
+		t.Errorf("seed %d replays as %q, the server generated %q", res.Seed, want, res.Code)
+	}
+
+	var batch batchResponse
+	if status := call(t, srv, "POST", "/grammars/digits/batch", `{"seed": 7, "tokens": 10, "count": 5}`, &batch); status != http.StatusOK {
+		t.Fatalf("batch status %d", status)
+	}
+	if want, _ := r.GenerateBatch(t.Context(), "digits", "program", 5, 10, 7); batch.Seed != 7 || !slices.Equal(batch.Codes, want) {
+		t.Errorf("batch %+v, want %q", batch, want)
+	}
+
+	expectError(t, srv, "POST", "/grammars/digits/generate", `{"start": "nope"}`, http.StatusBadRequest, "start_not_found")
+	expectError(t, srv, "POST", "/grammars/digits/generate", `{"tokens": 201}`, http.StatusBadRequest, "bad_request")
+	expectError(t, srv, "POST", "/grammars/digits/generate", `{"colour": "red"}`, http.StatusBadRequest, "bad_request")
+	expectError(t, srv, "POST", "/grammars/digits/generate", `{"seed": `, http.StatusBadRequest, "bad_request")
+	expectError(t, srv, "POST", "/grammars/nope/generate", "", http.StatusNotFound, "grammar_not_found")
+	expectError(t, srv, "POST", "/grammars/digits/batch", `{"count": 0}`, http.StatusBadRequest, "bad_request")
+	expectError(t, srv, "POST", "/grammars/digits/batch", `{"count": 11}`, http.StatusBadRequest, "bad_request")
+	expectError(t, srv, "GET", "/grammars/digits/generate", "", http.StatusNotFound, "not_found")
+}
+
+func TestServerLimits(t *testing.T) {
+	srv, _ := testServer(t, ServerConfig{MaxBodyBytes: 64})
+	big := "program : '" + strings.Repeat("a", 100) + "' ;\n"
+	expectError(t, srv, "PUT", "/grammars/big", big, http.StatusRequestEntityTooLarge, "too_large")
+	expectError(t, srv, "POST", "/grammars/digits/generate", `{"start": "`+strings.Repeat("a", 100)+`"}`, http.StatusRequestEntityTooLarge, "too_large")
+	if status := call(t, srv, "PUT", "/grammars/small", "program : 'a' ;\n", nil); status != http.StatusCreated {
+		t.Errorf("small grammar: status %d", status)
+	}
+}
+
+func TestServerTimeoutFreesWorkers(t *testing.T) {
+	srv, pool := testServer(t, ServerConfig{Timeout: 50 * time.Millisecond})
+	// Uploads reject it, a grammar loaded by the host can still never end
+	if err := pool.ParseGrammar("loop", "program : a ;\na : a ;\n"); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		expectError(t, srv, "POST", "/grammars/loop/generate", "", http.StatusServiceUnavailable, "timeout")
+	}
+	var res generateResponse
+	if status := call(t, srv, "POST", "/grammars/digits/generate", `{"seed": 1}`, &res); status != http.StatusOK || res.Code == "" {
+		t.Errorf("got %d %+v after timeouts, the workers are still busy", status, res)
+	}
+}
+
+func TestServerShutdownInFlight(t *testing.T) {
+	pool := NewResrapMT(2, 2)
+	if err := pool.ParseGrammar("digits", digitsGrammar); err != nil {
+		t.Fatal(err)
+	}
+	pool.StartResrap()
+	srv := httptest.NewServer(NewServer(pool, ServerConfig{}))
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, 40)
+	for i := range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path, body := "/grammars/digits/generate", `{"tokens": 500}`
+			if i%2 == 1 {
+				path, body = "/grammars/digits/batch", `{"count": 20, "tokens": 500}`
+			}
+			resp, err := srv.Client().Post(srv.URL+path, "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	pool.ShutDownResrap() //Requests still in flight finish inline
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		if status != http.StatusOK {
+			t.Errorf("request finished with status %d during shutdown", status)
+		}
+	}
+}
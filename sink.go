@@ -0,0 +1,187 @@
+package resrap
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// CodebaseSink receives the directories and files of a generated codebase.
+// Paths are slash separated and relative to the sink, "." being its root.
+type CodebaseSink interface {
+	// MkdirAll creates a directory along with any missing parents
+	MkdirAll(dir string) error
+	// WriteFile creates or replaces a file, its directory has already been created
+	WriteFile(name string, data []byte) error
+}
+
+// archiveTime is the modification time stamped on archive entries, fixed so archives are reproducible
+var archiveTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// DiskSink writes the codebase to a directory on disk
+type DiskSink struct {
+	root string
+}
+
+// NewDiskSink creates a DiskSink writing under root, the default sink of codebase generation
+func NewDiskSink(root string) *DiskSink {
+	return &DiskSink{root: root}
+}
+
+func (d *DiskSink) MkdirAll(dir string) error {
+	return os.MkdirAll(filepath.Join(d.root, filepath.FromSlash(dir)), 0755)
+}
+
+func (d *DiskSink) WriteFile(name string, data []byte) error {
+	return os.WriteFile(filepath.Join(d.root, filepath.FromSlash(name)), data, 0644)
+}
+
+// MemorySink keeps the codebase in memory, readable back as an fs.FS
+type MemorySink struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+}
+
+// NewMemorySink creates an empty MemorySink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{files: make(fstest.MapFS)}
+}
+
+func (m *MemorySink) MkdirAll(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir != "." && dir != "" {
+		if _, ok := m.files[dir]; ok {
+			break
+		}
+		m.files[dir] = &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: archiveTime}
+		dir = path.Dir(dir)
+	}
+	return nil
+}
+
+func (m *MemorySink) WriteFile(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = &fstest.MapFile{Data: data, Mode: 0644, ModTime: archiveTime}
+	return nil
+}
+
+// FS returns the files written so far as an fstest.MapFS
+func (m *MemorySink) FS() fstest.MapFS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(fstest.MapFS, len(m.files))
+	for name, f := range m.files {
+		out[name] = f
+	}
+	return out
+}
+
+// TarSink streams the codebase as a tar archive, gzip compressed when asked to.
+// Close must be called once generation is done to flush the archive.
+type TarSink struct {
+	mu   sync.Mutex
+	gz   *gzip.Writer
+	tw   *tar.Writer
+	dirs map[string]bool
+}
+
+// NewTarSink creates a TarSink writing to w, a .tar.gz when gzipped is set
+func NewTarSink(w io.Writer, gzipped bool) *TarSink {
+	t := &TarSink{dirs: make(map[string]bool)}
+	if gzipped {
+		t.gz = gzip.NewWriter(w)
+		w = t.gz
+	}
+	t.tw = tar.NewWriter(w)
+	return t
+}
+
+func (t *TarSink) MkdirAll(dir string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return mkdirAllArchive(dir, t.dirs, func(d string) error {
+		return t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: d + "/", Mode: 0755, ModTime: archiveTime})
+	})
+}
+
+func (t *TarSink) WriteFile(name string, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data)), ModTime: archiveTime}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := t.tw.Write(data)
+	return err
+}
+
+// Close finishes the archive, it does not close the underlying writer
+func (t *TarSink) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.gz != nil {
+		return t.gz.Close()
+	}
+	return nil
+}
+
+// ZipSink streams the codebase as a zip archive.
+// Close must be called once generation is done to flush the archive.
+type ZipSink struct {
+	mu   sync.Mutex
+	zw   *zip.Writer
+	dirs map[string]bool
+}
+
+// NewZipSink creates a ZipSink writing to w
+func NewZipSink(w io.Writer) *ZipSink {
+	return &ZipSink{zw: zip.NewWriter(w), dirs: make(map[string]bool)}
+}
+
+func (z *ZipSink) MkdirAll(dir string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return mkdirAllArchive(dir, z.dirs, func(d string) error {
+		_, err := z.zw.CreateHeader(&zip.FileHeader{Name: d + "/", Modified: archiveTime})
+		return err
+	})
+}
+
+func (z *ZipSink) WriteFile(name string, data []byte) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	w, err := z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: archiveTime})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Close finishes the archive, it does not close the underlying writer
+func (z *ZipSink) Close() error {
+	return z.zw.Close()
+}
+
+// mkdirAllArchive calls add for dir and each of its parents missing from seen, outermost first
+func mkdirAllArchive(dir string, seen map[string]bool, add func(string) error) error {
+	if dir == "." || dir == "" || seen[dir] {
+		return nil
+	}
+	if err := mkdirAllArchive(path.Dir(dir), seen, add); err != nil {
+		return err
+	}
+	seen[dir] = true
+	return add(dir)
+}
@@ -0,0 +1,185 @@
+package resrap
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// sinkTree generates testDSL under the root proj into sink
+func sinkTree(t *testing.T, sink CodebaseSink) CodebaseSummary {
+	t.Helper()
+	tree, err := ParseDSL(testDSL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := tree.GenerateStructure(codebaseResrap(t), "proj", WithSink(sink), WithCodebaseSeed(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+// archiveEntry is a file of an archive, or a directory when dir is set
+type archiveEntry struct {
+	dir  bool
+	data string
+}
+
+// checkArchive compares the entries of an archive, in archive order, to the memory tree want:
+// every directory comes once and before what it holds
+func checkArchive(t *testing.T, kind string, names []string, entries map[string]archiveEntry, want fstest.MapFS) {
+	t.Helper()
+	seen := map[string]bool{}
+	for _, name := range names {
+		trimmed := strings.TrimSuffix(name, "/")
+		if seen[trimmed] {
+			t.Errorf("%s: %s added twice", kind, name)
+		}
+		if dir := path.Dir(trimmed); dir != "." && !seen[dir] {
+			t.Errorf("%s: %s added before its directory", kind, name)
+		}
+		seen[trimmed] = true
+		w, ok := want[trimmed]
+		switch {
+		case !ok:
+			t.Errorf("%s: unexpected entry %s", kind, name)
+		case w.Mode.IsDir() != entries[name].dir || (!w.Mode.IsDir() && string(w.Data) != entries[name].data):
+			t.Errorf("%s: %s differs from the memory sink", kind, name)
+		}
+	}
+	if len(seen) != len(want) {
+		t.Errorf("%s: %d entries, want %d", kind, len(seen), len(want))
+	}
+}
+
+func readTar(t *testing.T, r io.Reader) ([]string, map[string]archiveEntry) {
+	t.Helper()
+	var names []string
+	entries := map[string]archiveEntry{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hdr.ModTime.Equal(archiveTime) {
+			t.Errorf("%s stamped %v", hdr.Name, hdr.ModTime)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		entries[hdr.Name] = archiveEntry{dir: hdr.Typeflag == tar.TypeDir, data: string(data)}
+	}
+}
+
+func TestSinks(t *testing.T) {
+	memory := NewMemorySink()
+	summary := sinkTree(t, memory)
+	want := memory.FS()
+	if err := fstest.TestFS(want, "proj/src/q_1.sql"); err != nil {
+		t.Error(err)
+	}
+	if len(want) != summary.Files+summary.Dirs+1 {
+		t.Errorf("memory sink holds %d entries, want %d files and %d directories under proj",
+			len(want), summary.Files, summary.Dirs)
+	}
+
+	t.Run("disk", func(t *testing.T) {
+		dir := t.TempDir()
+		sinkTree(t, NewDiskSink(dir))
+		got, err := fs.Glob(os.DirFS(dir), "proj/src/*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wanted, _ := fs.Glob(want, "proj/src/*")
+		if !slices.Equal(got, wanted) {
+			t.Errorf("disk holds %v, want %v", got, wanted)
+		}
+		for name, f := range want {
+			if f.Mode.IsDir() {
+				continue
+			}
+			if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != string(f.Data) {
+				t.Errorf("%s differs from the memory sink (%v)", name, err)
+			}
+		}
+	})
+
+	for _, gzipped := range []bool{false, true} {
+		kind := map[bool]string{false: "tar", true: "tar.gz"}[gzipped]
+		t.Run(kind, func(t *testing.T) {
+			var buf bytes.Buffer
+			sink := NewTarSink(&buf, gzipped)
+			sinkTree(t, sink)
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			var again bytes.Buffer
+			other := NewTarSink(&again, gzipped)
+			sinkTree(t, other)
+			other.Close()
+			if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+				t.Error("the same codebase gave two different archives")
+			}
+
+			var r io.Reader = &buf
+			if gzipped {
+				gz, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gz
+			}
+			names, entries := readTar(t, r)
+			checkArchive(t, kind, names, entries, want)
+		})
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		sink := NewZipSink(&buf)
+		sinkTree(t, sink)
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		entries := map[string]archiveEntry{}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, f.Name)
+			entries[f.Name] = archiveEntry{dir: strings.HasSuffix(f.Name, "/"), data: string(data)}
+		}
+		checkArchive(t, "zip", names, entries, want)
+		if err := fstest.TestFS(zr, "proj/src/core", "proj/src/q_1.sql"); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,400 @@
+package resrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SpecFormat is a notation a codebase specification can be written in
+type SpecFormat int
+
+const (
+	SpecDSL  SpecFormat = iota // The indentation based directory DSL
+	SpecJSON                   // JSON
+	SpecYAML                   // The block subset of YAML: mappings, sequences and scalars
+	SpecTOML                   // TOML, the tree nested through [[children]] arrays of tables
+)
+
+func (f SpecFormat) String() string {
+	switch f {
+	case SpecDSL:
+		return "dsl"
+	case SpecJSON:
+		return "json"
+	case SpecYAML:
+		return "yaml"
+	case SpecTOML:
+		return "toml"
+	default:
+		return fmt.Sprintf("SpecFormat(%d)", int(f))
+	}
+}
+
+// SpecFormatFromPath picks the format of a specification file from its extension, the DSL by default
+func SpecFormatFromPath(location string) SpecFormat {
+	switch strings.ToLower(filepath.Ext(location)) {
+	case ".json":
+		return SpecJSON
+	case ".yaml", ".yml":
+		return SpecYAML
+	case ".toml":
+		return SpecTOML
+	default:
+		return SpecDSL
+	}
+}
+
+// specNode is the shape of a Node in the JSON, YAML and TOML formats
+type specNode struct {
+	Name      string      `json:"name,omitempty"`
+	Type      string      `json:"type,omitempty"` // "dir" or "file", inferred from grammar when empty
+	Grammar   string      `json:"grammar,omitempty"`
+	Count     int         `json:"count,omitempty"`
+	Tokens    int         `json:"tokens,omitempty"`
+	MaxTokens int         `json:"max_tokens,omitempty"`
+	Pattern   string      `json:"pattern,omitempty"`
+	Start     string      `json:"start,omitempty"`
+	Seed      *uint64     `json:"seed,omitempty"`
+	Export    []string    `json:"export,omitempty"`
+	Import    []string    `json:"import,omitempty"`
+	NamePool  string      `json:"name_pool,omitempty"`
+	Children  []*specNode `json:"children,omitempty"`
+}
+
+// ParseSpec parses a codebase specification written in format into a tree, the same tree ParseDSL returns.
+// For JSON, YAML and TOML the document is the root directory: an object whose children are the
+// top level entries, or directly the list of top level entries (JSON and YAML only).
+func ParseSpec(data []byte, format SpecFormat) (*Node, error) {
+	if format == SpecDSL {
+		return ParseDSL(string(data))
+	}
+	var doc any
+	var err error
+	switch format {
+	case SpecJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&doc)
+	case SpecYAML:
+		doc, err = parseYAML(string(data))
+	case SpecTOML:
+		doc, err = parseTOML(string(data))
+	default:
+		return nil, fmt.Errorf("unknown specification format %v", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v specification: %w", format, err)
+	}
+	if list, ok := doc.([]any); ok {
+		doc = map[string]any{"children": list}
+	}
+
+	// Normalize every format through JSON so they share the same field mapping
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var spec specNode
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid %v specification: %w", format, err)
+	}
+	if spec.Name != "" || spec.Grammar != "" {
+		entry := spec
+		spec = specNode{Children: []*specNode{&entry}} // A single top level entry
+	}
+	spec.Type = "dir"
+	root, err := spec.toNode()
+	if err != nil {
+		return nil, err
+	}
+	root.Name = ""
+	return root, nil
+}
+
+// ParseSpecFile reads a codebase specification, in the format given by its extension
+func ParseSpecFile(location string) (*Node, error) {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSpec(data, SpecFormatFromPath(location))
+}
+
+// toNode converts a specNode and its children, applying the same checks as ParseDSL
+func (s *specNode) toNode() (*Node, error) {
+	n := &Node{
+		Name:       strings.TrimSuffix(s.Name, "/"),
+		FileType:   s.Grammar,
+		Count:      s.Count,
+		TokenCount: s.Tokens,
+		MaxTokens:  s.MaxTokens,
+		Pattern:    s.Pattern,
+		StartRule:  s.Start,
+		Exports:    s.Export,
+		Imports:    s.Import,
+		NamePool:   s.NamePool,
+	}
+	if s.Seed != nil {
+		n.Seed, n.HasSeed = *s.Seed, true
+	}
+	switch {
+	case s.Type == "file" || (s.Type == "" && s.Grammar != ""):
+		n.Type = FILE
+	case s.Type == "dir" || s.Type == "":
+		n.Type = DIR
+	default:
+		return nil, fmt.Errorf("invalid entry type '%s', expected 'dir' or 'file'", s.Type)
+	}
+
+	if n.Type == DIR {
+		if n.Name == "" && s.Type == "" {
+			return nil, fmt.Errorf("directory entry without a name")
+		}
+		if n.Name != "" && (n.Name == "." || n.Name == ".." || strings.ContainsAny(n.Name, "/\\")) {
+			return nil, fmt.Errorf("invalid directory name '%s'", s.Name)
+		}
+		if s.Grammar != "" || s.Count != 0 || s.Tokens != 0 || s.MaxTokens != 0 || s.Pattern != "" || s.Start != "" || s.Seed != nil ||
+			len(s.Export) > 0 || len(s.Import) > 0 || s.NamePool != "" {
+			return nil, fmt.Errorf("%s: directories can't have file settings", n.location())
+		}
+		for _, child := range s.Children {
+			c, err := child.toNode()
+			if err != nil {
+				return nil, err
+			}
+			n.Children = append(n.Children, c)
+		}
+		return n, nil
+	}
+
+	switch {
+	case len(s.Children) > 0:
+		return nil, fmt.Errorf("%s: file entries can't have children", n.location())
+	case n.FileType == "" || strings.IndexFunc(n.FileType, func(r rune) bool { return !isIdentPart(r) }) != -1:
+		return nil, fmt.Errorf("%s: invalid grammar name '%s'", n.location(), n.FileType)
+	case n.Pattern == "" || strings.ContainsAny(n.Pattern, " \t"):
+		return nil, fmt.Errorf("%s: invalid file name pattern '%s'", n.location(), n.Pattern)
+	case n.Count <= 0 || n.TokenCount <= 0:
+		return nil, fmt.Errorf("%s: count and token count must be positive numbers", n.location())
+	case n.MaxTokens != 0 && n.MaxTokens < n.TokenCount:
+		return nil, fmt.Errorf("%s: invalid token range %d..%d, upper bound is below lower bound", n.location(), n.TokenCount, n.MaxTokens)
+	case n.NamePool != "" && !isIdentifier(n.NamePool):
+		return nil, fmt.Errorf("%s: invalid pool name '%s'", n.location(), n.NamePool)
+	}
+	for _, link := range append(append([]string(nil), n.Exports...), n.Imports...) {
+		if _, _, err := parseSymbolLink(link); err != nil {
+			return nil, fmt.Errorf("%s: %w", n.location(), err)
+		}
+	}
+	return n, nil
+}
+
+// toSpec converts a Node and its children into their JSON, YAML and TOML shape
+func (n *Node) toSpec() *specNode {
+	s := &specNode{Name: n.Name}
+	if n.Type == FILE {
+		s.Name = ""
+		s.Grammar = n.FileType
+		s.Count = n.Count
+		s.Tokens = n.TokenCount
+		s.MaxTokens = n.MaxTokens
+		s.Pattern = n.Pattern
+		s.Start = n.StartRule
+		if n.HasSeed {
+			seed := n.Seed
+			s.Seed = &seed
+		}
+		s.Export = n.Exports
+		s.Import = n.Imports
+		s.NamePool = n.NamePool
+	}
+	for _, child := range n.Children {
+		s.Children = append(s.Children, child.toSpec())
+	}
+	return s
+}
+
+// Encode writes the tree rooted at n in format, ParseSpec reads it back into the same tree
+func (n *Node) Encode(format SpecFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case SpecDSL:
+		for _, child := range n.Children {
+			child.encodeDSL(&buf, 0)
+		}
+	case SpecJSON:
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(n.toSpec()); err != nil {
+			return nil, err
+		}
+	case SpecYAML:
+		encodeYAML(&buf, n.toSpec(), 0, false)
+	case SpecTOML:
+		encodeTOML(&buf, n.toSpec(), "")
+	default:
+		return nil, fmt.Errorf("unknown specification format %v", format)
+	}
+	return bytes.TrimLeft(buf.Bytes(), "\n"), nil
+}
+
+func (n *Node) encodeDSL(buf *bytes.Buffer, depth int) {
+	buf.WriteString(strings.Repeat("  ", depth))
+	if n.Type == DIR {
+		buf.WriteString(n.Name + "/\n")
+		for _, child := range n.Children {
+			child.encodeDSL(buf, depth+1)
+		}
+		return
+	}
+	fmt.Fprintf(buf, "%s[%dx%d", n.FileType, n.Count, n.TokenCount)
+	if n.MaxTokens != 0 {
+		fmt.Fprintf(buf, "..%d", n.MaxTokens)
+	}
+	buf.WriteString(" " + n.Pattern)
+	if n.StartRule != "" {
+		buf.WriteString(" start=" + n.StartRule)
+	}
+	if n.HasSeed {
+		fmt.Fprintf(buf, " seed=%d", n.Seed)
+	}
+	for _, link := range n.Exports {
+		buf.WriteString(" export=" + link)
+	}
+	for _, link := range n.Imports {
+		buf.WriteString(" import=" + link)
+	}
+	if n.NamePool != "" {
+		buf.WriteString(" name=" + n.NamePool)
+	}
+	buf.WriteString("]\n")
+}
+
+// specFields lists the scalar settings of s in encoding order
+func (s *specNode) specFields() [][2]string {
+	var fields [][2]string
+	add := func(key, value string) {
+		fields = append(fields, [2]string{key, value})
+	}
+	if s.Name != "" {
+		add("name", quoteScalar(s.Name))
+	}
+	if s.Grammar != "" {
+		add("grammar", quoteScalar(s.Grammar))
+		add("count", strconv.Itoa(s.Count))
+		add("tokens", strconv.Itoa(s.Tokens))
+		if s.MaxTokens != 0 {
+			add("max_tokens", strconv.Itoa(s.MaxTokens))
+		}
+		add("pattern", quoteScalar(s.Pattern))
+		if s.Start != "" {
+			add("start", quoteScalar(s.Start))
+		}
+		if s.Seed != nil {
+			add("seed", strconv.FormatUint(*s.Seed, 10))
+		}
+		if len(s.Export) > 0 {
+			add("export", quoteList(s.Export))
+		}
+		if len(s.Import) > 0 {
+			add("import", quoteList(s.Import))
+		}
+		if s.NamePool != "" {
+			add("name_pool", quoteScalar(s.NamePool))
+		}
+	}
+	return fields
+}
+
+// quoteScalar double quotes a string for YAML and TOML, using only the escapes both read:
+// \" \\ \b \t \n \f \r, and \u or \U for the other characters that aren't printable
+func quoteScalar(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			switch {
+			case unicode.IsPrint(r):
+				b.WriteRune(r)
+			case r > 0xffff:
+				fmt.Fprintf(&b, `\U%08X`, r)
+			default:
+				fmt.Fprintf(&b, `\u%04X`, r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// quoteList writes an inline array of strings, valid in both YAML and TOML
+func quoteList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = quoteScalar(s)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func encodeYAML(buf *bytes.Buffer, s *specNode, indent int, inList bool) {
+	pad := strings.Repeat(" ", indent)
+	first := true
+	line := func(text string) {
+		if first && inList {
+			buf.WriteString(text + "\n") // The "- " was written by the parent
+		} else {
+			buf.WriteString(pad + text + "\n")
+		}
+		first = false
+	}
+	for _, f := range s.specFields() {
+		line(f[0] + ": " + f[1])
+	}
+	if len(s.Children) > 0 {
+		line("children:")
+		for _, child := range s.Children {
+			buf.WriteString(pad + "  - ")
+			encodeYAML(buf, child, indent+4, true)
+		}
+	}
+	if first && inList {
+		buf.WriteString("{}\n")
+	}
+}
+
+func encodeTOML(buf *bytes.Buffer, s *specNode, table string) {
+	for _, f := range s.specFields() {
+		buf.WriteString(f[0] + " = " + f[1] + "\n")
+	}
+	child := "children"
+	if table != "" {
+		child = table + ".children"
+	}
+	for _, c := range s.Children {
+		buf.WriteString("\n[[" + child + "]]\n")
+		encodeTOML(buf, c, child)
+	}
+}
@@ -0,0 +1,143 @@
+package resrap
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const specDSL = `# every setting the formats carry
+src/
+  c[10x200..800 {ident}_*.c start=function seed=42 export=identifier:names]
+  headers
+    c[5x100 *.h import=identifier:names name=headers]
+  sql[3x30 q_*.sql]
+docs/
+`
+
+// specShape strips what depends on the notation a tree was read from
+func specShape(n *Node) *Node {
+	c := *n
+	c.Line = 0
+	c.Children = nil
+	for _, child := range n.Children {
+		c.Children = append(c.Children, specShape(child))
+	}
+	return &c
+}
+
+func TestSpecRoundTrip(t *testing.T) {
+	tree, err := ParseDSL(specDSL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := specShape(tree)
+	for _, format := range []SpecFormat{SpecDSL, SpecJSON, SpecYAML, SpecTOML} {
+		data, err := tree.Encode(format)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		back, err := ParseSpec(data, format)
+		if err != nil {
+			t.Fatalf("%v: encoded tree doesn't parse: %v\n%s", format, err, data)
+		}
+		if got := specShape(back); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: tree changed through\n%s", format, data)
+		}
+		again, err := back.Encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(data) {
+			t.Errorf("%v: encoding isn't stable\nfirst:\n%s\nsecond:\n%s", format, data, again)
+		}
+	}
+}
+
+func TestSpecFormatsAgree(t *testing.T) {
+	docs := map[SpecFormat]string{
+		SpecDSL: "src/\n  c[10x200..800 *.c seed=42]\n",
+		SpecJSON: `{"children": [{"name": "src", "children": [
+			{"grammar": "c", "count": 10, "tokens": 200, "max_tokens": 800, "pattern": "*.c", "seed": 42}]}]}`,
+		SpecYAML: "- name: src\n  children:\n    - grammar: c\n      count: 10\n      tokens: 200\n      max_tokens: 800\n      pattern: \"*.c\"\n      seed: 42\n",
+		SpecTOML: "[[children]]\nname = \"src\"\n\n[[children.children]]\ngrammar = \"c\"\ncount = 10\ntokens = 200\nmax_tokens = 800\npattern = \"*.c\"\nseed = 42\n",
+	}
+	var want *Node
+	for _, format := range []SpecFormat{SpecDSL, SpecJSON, SpecYAML, SpecTOML} {
+		tree, err := ParseSpec([]byte(docs[format]), format)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		if want == nil {
+			want = specShape(tree)
+		} else if got := specShape(tree); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: tree differs from the DSL's", format)
+		}
+	}
+}
+
+func TestSpecRejectsUnknownKeys(t *testing.T) {
+	docs := map[SpecFormat]string{
+		SpecJSON: `[{"grammar": "c", "count": 1, "tokens": 5, "pattern": "*.c", "colour": "red"}]`,
+		SpecYAML: "- grammar: c\n  count: 1\n  tokens: 5\n  pattern: \"*.c\"\n  colour: red\n",
+		SpecTOML: "[[children]]\ngrammar = \"c\"\ncount = 1\ntokens = 5\npattern = \"*.c\"\ncolour = \"red\"\n",
+	}
+	for format, doc := range docs {
+		if _, err := ParseSpec([]byte(doc), format); err == nil {
+			t.Errorf("%v: unknown key accepted", format)
+		}
+	}
+}
+
+func TestSpecQuoting(t *testing.T) {
+	name := "q\"\t\x01\x7f\u00a0é😀\U000e0001"
+	tree := &Node{Type: DIR, Children: []*Node{{Type: DIR, Name: name, Children: []*Node{
+		{Type: FILE, FileType: "c", Count: 1, TokenCount: 5, Pattern: `a\b"*.c`},
+	}}}}
+	// Only the escapes TOML and YAML both read
+	escape := regexp.MustCompile(`\\(u[0-9A-F]{4}|U[0-9A-F]{8}|["\\btnfr])`)
+	for _, format := range []SpecFormat{SpecYAML, SpecTOML} {
+		data, err := tree.Encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rest := escape.ReplaceAllString(string(data), ""); strings.Contains(rest, `\`) {
+			t.Errorf("%v: escape outside of the shared set in\n%s", format, data)
+		}
+		for _, want := range []string{`"q\"\t\u0001\u007F\u00A0é😀\U000E0001"`, `"a\\b\"*.c"`} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("%v: %s not found in\n%s", format, want, data)
+			}
+		}
+		back, err := ParseSpec(data, format)
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", format, err, data)
+		}
+		if got := back.Children[0]; got.Name != name || got.Children[0].Pattern != `a\b"*.c` {
+			t.Errorf("%v: read back %q and %q", format, got.Name, got.Children[0].Pattern)
+		}
+	}
+}
+
+func TestSpecRejectsInvalidEntries(t *testing.T) {
+	docs := []struct {
+		format SpecFormat
+		doc    string
+		want   string
+	}{
+		{SpecYAML, "- grammar: c\n  count: 1\n  tokens: 5\n  pattern: *.c\n", "must be quoted"},
+		{SpecYAML, "- grammar: c\n  count: 1\n  tokens: 5\n  pattern: \"*.c\"\n  start: &anchor x\n", "must be quoted"},
+		{SpecYAML, "- name: src\n  seed: 4\n", "directories can't have file settings"},
+		{SpecJSON, `[{"name": "src", "start": "program"}]`, "directories can't have file settings"},
+		{SpecJSON, `[{"name": "src", "max_tokens": 10}]`, "directories can't have file settings"},
+		{SpecTOML, "[[children]]\nname = \"src\"\nseed = 0\n", "directories can't have file settings"},
+		{SpecTOML, "[[children]]\ngrammar = \"c\"\ncount = 1\ntokens = 5\npattern = *.c\n", "unsupported value"},
+	}
+	for _, d := range docs {
+		_, err := ParseSpec([]byte(d.doc), d.format)
+		if err == nil || !strings.Contains(err.Error(), d.want) {
+			t.Errorf("%v %q: got %v, want an error about %q", d.format, d.doc, err, d.want)
+		}
+	}
+}
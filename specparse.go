@@ -0,0 +1,401 @@
+package resrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Small readers for the parts of YAML and TOML a codebase specification needs.
+// Both produce the generic values encoding/json would (map[string]any, []any, string, json.Number, bool, nil).
+
+// specLine is a meaningful line of a YAML or TOML document
+type specLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// stripSpecComment cuts a '#' comment that is outside of a quoted string
+func stripSpecComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func specLines(input string) ([]specLine, error) {
+	var lines []specLine
+	for i, raw := range strings.Split(strings.ReplaceAll(input, "\r\n", "\n"), "\n") {
+		text := strings.TrimRight(stripSpecComment(raw), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", i+1)
+		}
+		lines = append(lines, specLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	return lines, nil
+}
+
+// parseSpecScalar reads a quoted string, number, boolean or null
+func parseSpecScalar(s string, num int) (any, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string %s", num, s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("line %d: invalid string %s", num, s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s == "null" || s == "~":
+		return nil, nil
+	case strings.HasPrefix(s, "["):
+		return parseInlineArray(s, num, parseSpecScalar)
+	case s == "{}":
+		return map[string]any{}, nil
+	}
+	// Numbers stay exact, seeds use the full 64 bits
+	if v, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 0, 64); err == nil {
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	}
+	if v, err := strconv.ParseUint(strings.ReplaceAll(s, "_", ""), 0, 64); err == nil {
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported value %s", num, s)
+}
+
+// parseInlineArray reads a one line [a, b, ...] array, its items read with item
+func parseInlineArray(s string, num int, item func(string, int) (any, error)) (any, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("line %d: unterminated array %s", num, s)
+	}
+	list := []any{}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	var quote byte
+	begin := 0
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) {
+			c := inner[i]
+			switch {
+			case quote != 0:
+				if c == '\\' && quote == '"' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c != ',':
+				continue
+			}
+		}
+		elem := strings.TrimSpace(inner[begin:i])
+		begin = i + 1
+		if elem == "" {
+			if i == len(inner) && len(list) > 0 || len(inner) == 0 {
+				continue //Trailing comma, or no items at all
+			}
+			return nil, fmt.Errorf("line %d: empty array item in %s", num, s)
+		}
+		v, err := item(elem, num)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// yamlIndicators start plain YAML values that mean something else than a string, e.g. '*' an alias
+const yamlIndicators = "*&!|>%@`"
+
+// yamlScalar is parseSpecScalar with YAML's unquoted strings
+func yamlScalar(s string, num int) (any, error) {
+	if strings.HasPrefix(s, "[") {
+		return parseInlineArray(s, num, yamlScalar)
+	}
+	if strings.ContainsAny(s[:1], yamlIndicators) {
+		return nil, fmt.Errorf("line %d: %s must be quoted, YAML doesn't read a plain value starting with '%c' as a string", num, s, s[0])
+	}
+	v, err := parseSpecScalar(s, num)
+	if err != nil && !strings.ContainsAny(s[:1], `"'[{`) {
+		return s, nil
+	}
+	return v, err
+}
+
+// splitKey splits 'key<sep> value', the key optionally quoted
+func splitKey(text, sep string, num int) (string, string, error) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", fmt.Errorf("line %d: unterminated key", num)
+		}
+		key := text[1 : end+1]
+		rest := strings.TrimLeft(text[end+2:], " ")
+		if !strings.HasPrefix(rest, strings.TrimSpace(sep)) {
+			return "", "", fmt.Errorf("line %d: expected '%s' after key", num, strings.TrimSpace(sep))
+		}
+		return key, strings.TrimSpace(rest[len(strings.TrimSpace(sep)):]), nil
+	}
+	i := strings.Index(text, sep)
+	if i < 0 && sep == ": " && strings.HasSuffix(text, ":") {
+		i = len(text) - 1
+	}
+	if i <= 0 {
+		return "", "", fmt.Errorf("line %d: expected 'key%svalue'", num, sep)
+	}
+	value := ""
+	if i+len(sep) <= len(text) {
+		value = text[i+len(sep):]
+	}
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(value), nil
+}
+
+// yamlParser reads block style YAML: nested mappings and sequences of plain, quoted or numeric scalars
+type yamlParser struct {
+	lines []specLine
+	pos   int
+}
+
+func parseYAML(input string) (any, error) {
+	lines, err := specLines(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) > 0 && lines[0].text == "---" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+// block reads the mapping or sequence whose lines start at column indent
+func (p *yamlParser) block(indent int) (any, error) {
+	if strings.HasPrefix(p.lines[p.pos].text, "- ") || p.lines[p.pos].text == "-" {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	list := []any{}
+	for p.pos < len(p.lines) {
+		line := &p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+		if line.text != "-" && !strings.HasPrefix(line.text, "- ") {
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			// Item on the following, deeper lines
+			p.pos++
+			if p.pos >= len(p.lines) || p.lines[p.pos].indent <= indent {
+				list = append(list, nil)
+				continue
+			}
+			v, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		if isYAMLKey(rest) || strings.HasPrefix(rest, "- ") {
+			// Item is a block starting on this line, re-read the line from the item column
+			line.indent += len(line.text) - len(rest)
+			line.text = rest
+			v, err := p.block(line.indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		v, err := yamlScalar(rest, line.num)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		p.pos++
+	}
+	return list, nil
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	m := map[string]any{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+		if !isYAMLKey(line.text) {
+			if strings.HasPrefix(line.text, "- ") {
+				break
+			}
+			return nil, fmt.Errorf("line %d: expected 'key: value'", line.num)
+		}
+		key, value, err := splitKey(line.text, ": ", line.num)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key '%s'", line.num, key)
+		}
+		p.pos++
+		if value != "" {
+			if m[key], err = yamlScalar(value, line.num); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Nested block, sequences may sit at the same column as their key
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if next.indent > indent || (next.indent == indent && strings.HasPrefix(next.text, "- ")) {
+				if m[key], err = p.block(next.indent); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		m[key] = nil
+	}
+	return m, nil
+}
+
+// isYAMLKey reports whether a line starts a 'key: value' pair
+func isYAMLKey(text string) bool {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := strings.IndexByte(text[1:], text[0])
+		return end >= 0 && strings.HasPrefix(strings.TrimLeft(text[end+2:], " "), ":")
+	}
+	return strings.Contains(text, ": ") || strings.HasSuffix(text, ":")
+}
+
+// parseTOML reads key/value pairs, [tables] and [[arrays of tables]] with dotted headers
+func parseTOML(input string) (any, error) {
+	lines, err := specLines(input)
+	if err != nil {
+		return nil, err
+	}
+	root := map[string]any{}
+	current := root
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line.text, "[["):
+			if !strings.HasSuffix(line.text, "]]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", line.num)
+			}
+			path := strings.Split(strings.TrimSpace(line.text[2:len(line.text)-2]), ".")
+			parent, err := tomlTable(root, path[:len(path)-1], line.num)
+			if err != nil {
+				return nil, err
+			}
+			last := strings.TrimSpace(path[len(path)-1])
+			list, _ := parent[last].([]any)
+			if parent[last] != nil && list == nil {
+				return nil, fmt.Errorf("line %d: '%s' is not an array of tables", line.num, last)
+			}
+			current = map[string]any{}
+			parent[last] = append(list, current)
+		case strings.HasPrefix(line.text, "["):
+			if !strings.HasSuffix(line.text, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", line.num)
+			}
+			if current, err = tomlTable(root, strings.Split(strings.TrimSpace(line.text[1:len(line.text)-1]), "."), line.num); err != nil {
+				return nil, err
+			}
+		default:
+			key, value, err := splitKey(line.text, " = ", line.num)
+			if err != nil {
+				if key, value, err = splitKey(line.text, "=", line.num); err != nil {
+					return nil, err
+				}
+			}
+			if _, dup := current[key]; dup {
+				return nil, fmt.Errorf("line %d: duplicate key '%s'", line.num, key)
+			}
+			if value == "" {
+				return nil, fmt.Errorf("line %d: missing value for '%s'", line.num, key)
+			}
+			if current[key], err = parseSpecScalar(value, line.num); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return root, nil
+}
+
+// tomlTable walks a dotted table path from root, creating tables and entering the last element of arrays
+func tomlTable(root map[string]any, path []string, num int) (map[string]any, error) {
+	table := root
+	for _, part := range path {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("line %d: empty table name", num)
+		}
+		switch v := table[part].(type) {
+		case nil:
+			next := map[string]any{}
+			table[part] = next
+			table = next
+		case map[string]any:
+			table = v
+		case []any:
+			next, ok := v[len(v)-1].(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("line %d: '%s' is not a table", num, part)
+			}
+			table = next
+		default:
+			return nil, fmt.Errorf("line %d: '%s' is not a table", num, part)
+		}
+	}
+	return table, nil
+}
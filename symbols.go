@@ -0,0 +1,121 @@
+package resrap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Symbol pools share generated names between the files of a codebase.
+// A file entry exports the output of a grammar rule (export=rule:pool) or its file names (name=pool),
+// and later entries replace a rule with one of the pooled symbols (import=rule:pool) instead of walking it.
+
+// parseSymbolLink splits a "rule:pool" link, both sides being identifiers
+func parseSymbolLink(link string) (rule, pool string, err error) {
+	rule, pool, ok := strings.Cut(link, ":")
+	if !ok || !isIdentifier(rule) || !isIdentifier(pool) {
+		return "", "", fmt.Errorf("invalid symbol link '%s', expected 'rule:pool'", link)
+	}
+	return rule, pool, nil
+}
+
+func isIdentifier(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool { return !isIdentPart(r) }) == -1
+}
+
+// captureFrame is a rule being walked whose output is exported once it returns
+type captureFrame struct {
+	pool  string
+	start int //Offset of the rule's output in the walk result
+	depth int //Jump stack depth inside the rule
+}
+
+// walkHooks connect a single walk to the symbol pools of a codebase
+type walkHooks struct {
+	exports map[uint32]string   //Rule id -> pool its output is exported to
+	imports map[uint32][]string //Rule id -> symbols the rule is replaced with
+	frames  []captureFrame
+	found   map[string][]string //Symbols exported by the walk, by pool
+	trace   *expansionTrace     //Rules expanded, only recorded by GenerateTraced
+}
+
+// substitute picks a pooled symbol for rule when it is imported and the pool has any
+func (h *walkHooks) substitute(rule uint32, prng *prng) (string, bool) {
+	if h == nil || len(h.imports[rule]) == 0 {
+		return "", false
+	}
+	symbols := h.imports[rule]
+	return symbols[prng.RandomInt(0, len(symbols))], true
+}
+
+// enter starts capturing rule when it is exported, at is the current result length
+func (h *walkHooks) enter(rule uint32, at, depth int) {
+	if h == nil {
+		return
+	}
+	if pool, ok := h.exports[rule]; ok {
+		h.frames = append(h.frames, captureFrame{pool: pool, start: at, depth: depth})
+	}
+	h.trace.enter(rule, at, depth)
+}
+
+// leave exports the rules that returned when the jump stack went down to depth.
+// Rules cut off by the token limit never return and are not exported.
+func (h *walkHooks) leave(depth int, result *strings.Builder) {
+	if h == nil {
+		return
+	}
+	for len(h.frames) > 0 && h.frames[len(h.frames)-1].depth > depth {
+		frame := h.frames[len(h.frames)-1]
+		h.frames = h.frames[:len(h.frames)-1]
+		if symbol := strings.TrimSpace(result.String()[frame.start:]); symbol != "" {
+			h.found[frame.pool] = append(h.found[frame.pool], symbol)
+		}
+	}
+	h.trace.leave(depth, result.Len())
+}
+
+// newWalkHooks builds the hooks of a planned file from the pools its stage can see, nil when it shares nothing
+func newWalkHooks(graph *syntaxGraph, f ManifestEntry, pools map[string][]string) *walkHooks {
+	if len(f.Exports) == 0 && len(f.Imports) == 0 {
+		return nil
+	}
+	h := &walkHooks{exports: make(map[uint32]string), imports: make(map[uint32][]string), found: make(map[string][]string)}
+	for _, link := range f.Exports {
+		rule, pool, _ := parseSymbolLink(link)
+		h.exports[graph.namemap[rule]] = pool
+	}
+	for _, link := range f.Imports {
+		rule, pool, _ := parseSymbolLink(link)
+		h.imports[graph.namemap[rule]] = append(h.imports[graph.namemap[rule]], pools[pool]...)
+	}
+	return h
+}
+
+// symbolPools collects the symbols exported by the files of a codebase, in plan order and without duplicates
+type symbolPools struct {
+	pools map[string][]string
+	seen  map[string]map[string]bool
+}
+
+func newSymbolPools() *symbolPools {
+	return &symbolPools{pools: make(map[string][]string), seen: make(map[string]map[string]bool)}
+}
+
+func (p *symbolPools) add(pool, symbol string) {
+	if p.seen[pool] == nil {
+		p.seen[pool] = make(map[string]bool)
+	}
+	if !p.seen[pool][symbol] {
+		p.seen[pool][symbol] = true
+		p.pools[pool] = append(p.pools[pool], symbol)
+	}
+}
+
+// snapshot returns the pools as they are now, unaffected by later adds
+func (p *symbolPools) snapshot() map[string][]string {
+	out := make(map[string][]string, len(p.pools))
+	for pool, symbols := range p.pools {
+		out[pool] = symbols[:len(symbols):len(symbols)]
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package resrap
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSymbolLink(t *testing.T) {
+	rule, pool, err := parseSymbolLink("func_name:funcs")
+	if err != nil || rule != "func_name" || pool != "funcs" {
+		t.Errorf("got %q, %q, %v", rule, pool, err)
+	}
+	for _, link := range []string{"", "rule", "rule:", ":pool", "a:b:c", "a-b:pool", "rule:po ol"} {
+		if _, _, err := parseSymbolLink(link); err == nil {
+			t.Errorf("%q accepted", link)
+		}
+	}
+}
+
+func TestSymbolPools(t *testing.T) {
+	p := newSymbolPools()
+	p.add("funcs", "main")
+	p.add("funcs", "init")
+	p.add("funcs", "main")
+	before := p.snapshot()
+	p.add("funcs", "exit")
+	p.add("types", "node")
+	if !slices.Equal(before["funcs"], []string{"main", "init"}) || len(before) != 1 {
+		t.Errorf("snapshot changed by later adds: %v", before)
+	}
+	after := p.snapshot()
+	if !slices.Equal(after["funcs"], []string{"main", "init", "exit"}) || !slices.Equal(after["types"], []string{"node"}) {
+		t.Errorf("pools %v", after)
+	}
+}
+
+// poolsResrap loads a grammar declaring names and one using references
+func poolsResrap(t *testing.T) *Resrap {
+	t.Helper()
+	r := NewResrap()
+	for name, grammar := range map[string]string{
+		"defs": "program : ('def ' name ';\\n')+ ;\nname : [a-z] [a-z] [a-z] ;\nunused : 'u' ;\n",
+		"uses": "program : ('use ' ref ';\\n')+ ;\nref : 'NONE' ;\n",
+	} {
+		if err := r.ParseGrammar(name, grammar); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+// symbolsOf returns what follows prefix on the lines of the files whose path matches expr
+func symbolsOf(t *testing.T, files map[string]string, expr, prefix string) []string {
+	t.Helper()
+	var symbols []string
+	for p, data := range files {
+		if ok, _ := regexp.MatchString(expr, p); !ok {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+			if s, ok := strings.CutPrefix(line, prefix); ok {
+				symbols = append(symbols, strings.TrimSuffix(s, ";"))
+			}
+		}
+	}
+	return symbols
+}
+
+func TestCodebaseSymbolPools(t *testing.T) {
+	r := poolsResrap(t)
+	tree, err := ParseDSL(`defs[3x12 d*.txt export=name:names name=files]
+uses[4x12 u*.txt import=ref:names]
+uses[2x12 f*.txt import=ref:files]
+defs[1x4 e*.txt export=unused:empty]
+uses[2x12 n*.txt import=ref:empty]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generate := func(opts ...CodebaseOption) (map[string]string, CodebaseSummary) {
+		t.Helper()
+		sink := NewMemorySink()
+		summary, err := tree.GenerateStructure(r, "", append(opts, WithSink(sink))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{}
+		for p, f := range sink.FS() {
+			files[p] = string(f.Data)
+		}
+		return files, summary
+	}
+	files, summary := generate(WithWorkers(1))
+
+	exported := map[string]bool{}
+	for _, name := range symbolsOf(t, files, `^d`, "def ") {
+		exported[name] = true
+	}
+	uses := symbolsOf(t, files, `^u`, "use ")
+	if len(uses) == 0 {
+		t.Fatal("no references generated")
+	}
+	for _, ref := range uses {
+		if !exported[ref] {
+			t.Errorf("reference %q isn't a name exported by d*.txt", ref)
+		}
+	}
+	for _, ref := range symbolsOf(t, files, `^f`, "use ") {
+		if ref != "d1.txt" && ref != "d2.txt" && ref != "d3.txt" {
+			t.Errorf("reference %q isn't a file name of d*.txt", ref)
+		}
+	}
+	// Nothing reaches unused, so the pool stays empty and ref is walked as usual
+	for _, ref := range symbolsOf(t, files, `^n`, "use ") {
+		if ref != "NONE" {
+			t.Errorf("reference %q from an empty pool", ref)
+		}
+	}
+
+	if again, _ := generate(WithWorkers(8)); !maps.Equal(again, files) {
+		t.Error("8 workers: pooled symbols differ from one worker")
+	}
+
+	// The manifest records the pools, so it regenerates the same references
+	sink := NewMemorySink()
+	if _, err := r.GenerateFromManifest(summary.Manifest, "", WithSink(sink)); err != nil {
+		t.Fatal(err)
+	}
+	for p, f := range sink.FS() {
+		if !f.Mode.IsDir() && string(f.Data) != files[p] {
+			t.Errorf("%s differs when generated from the manifest", p)
+		}
+	}
+}
+
+func TestCodebaseSymbolPoolOrder(t *testing.T) {
+	r := poolsResrap(t)
+	for dsl, want := range map[string]string{
+		"uses[2x5 u*.txt import=ref:names]\ndefs[2x5 d*.txt export=name:names]\n": "line 1: symbol pool 'names' is not exported by an earlier entry",
+		"defs[2x5 d*.txt export=name:names import=name:names]\n":                  "line 1: symbol pool 'names' is not exported by an earlier entry",
+		"uses[2x5 u*.txt import=nope:names]\n":                                    "line 1: symbol rule 'nope' not found in grammar 'uses'",
+		"defs[2x5 d*.txt export=name]\n":                                          "line 1: invalid symbol link 'name'",
+	} {
+		tree, err := ParseDSL(dsl)
+		if err == nil {
+			_, err = tree.GenerateStructure(r, "", WithDryRun())
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want one containing %q", dsl, err, want)
+		}
+	}
+}
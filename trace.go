@@ -0,0 +1,65 @@
+package resrap
+
+// Expansion is a rule expanded while generating, its output being code[Start:End]
+type Expansion struct {
+	Rule     string
+	Depth    int // 0 for the starting rule, callers come before the rules they call
+	Start    int
+	End      int
+	Complete bool // False when the token limit cut the rule short
+}
+
+// GenerateTraced generates like GenerateWithSeeded, also returning every rule expanded on the way
+// in the order the walk entered them
+func (r *Resrap) GenerateTraced(name, starting_node string, seed uint64, tokens int) (string, []Expansion) {
+	l, ok := r.languageGraph[name]
+	if !ok || l.graph == nil {
+		return "", nil
+	}
+	trace := &expansionTrace{rules: l.graph.ruleOf()}
+	trace.enter(l.graph.namemap[starting_node], 0, 0)
+	prng := newPRNG(r.source, seed)
+	job := codeGenReq{name: name, startnode: starting_node, tokens: tokens, seed: seed, hooks: &walkHooks{trace: trace}}
+	code, stats, err := observedWalk(r.languageGraph, r.metrics, r.logger, job, &prng)
+	if err != nil {
+		return "", nil
+	}
+	trace.finish(len(code), !stats.cut)
+	return code, trace.expansions
+}
+
+// expansionTrace records the rules a walk expands
+type expansionTrace struct {
+	rules      map[uint32]string
+	expansions []Expansion
+	open       []int //Indexes of the expansions not returned yet, innermost last
+}
+
+func (t *expansionTrace) enter(rule uint32, at, depth int) {
+	if t == nil {
+		return
+	}
+	t.open = append(t.open, len(t.expansions))
+	t.expansions = append(t.expansions, Expansion{Rule: t.rules[rule], Depth: depth, Start: at})
+}
+
+// leave completes the expansions deeper than depth, the walk being at offset at
+func (t *expansionTrace) leave(depth, at int) {
+	if t == nil {
+		return
+	}
+	for len(t.open) > 0 && t.expansions[t.open[len(t.open)-1]].Depth > depth {
+		e := &t.expansions[t.open[len(t.open)-1]]
+		t.open = t.open[:len(t.open)-1]
+		e.End, e.Complete = at, true
+	}
+}
+
+// finish closes what the walk left open, which only completed when the walk wasn't cut
+func (t *expansionTrace) finish(at int, completed bool) {
+	for _, idx := range t.open {
+		t.expansions[idx].End = at
+		t.expansions[idx].Complete = completed
+	}
+	t.open = nil
+}
@@ -0,0 +1,267 @@
+package resrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// tree-sitter grammars are imported from the grammar.json that `tree-sitter generate` writes
+// next to the parser. Rules whose body is a single token (a string, a pattern or token(...))
+// become lexical rules, the others are generated with a space after every token, as tree-sitter's
+// default extras skip whitespace. Patterns are read as regexes, their classes and repetitions
+// converted like those of the other formats.
+
+type tsGrammar struct {
+	Name      string          `json:"name"`
+	Rules     json.RawMessage `json:"rules"`
+	Extras    []tsNode        `json:"extras"`
+	Externals []tsNode        `json:"externals"`
+}
+
+// tsNode is a rule expression of grammar.json, the fields used depending on its type
+type tsNode struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`    //SYMBOL and FIELD
+	Value   any      `json:"value"`   //Text of STRING, PATTERN and ALIAS, precedence of PREC*
+	Flags   string   `json:"flags"`   //PATTERN flags, like "i"
+	Content *tsNode  `json:"content"` //Wrapped expression of REPEAT, TOKEN, PREC*, ALIAS, FIELD...
+	Members []tsNode `json:"members"` //SEQ and CHOICE
+}
+
+type tsImporter struct {
+	g       *ggrammar
+	defined map[string]bool
+	current string   //Rule being read, for warnings and token rule names
+	tokens  []*grule //Token rules split out of the current rule
+}
+
+// importTreeSitter converts a tree-sitter grammar.json
+func importTreeSitter(src string, _ importConfig) (*ggrammar, error) {
+	var tg tsGrammar
+	if err := json.Unmarshal([]byte(src), &tg); err != nil {
+		return nil, err
+	}
+	names, bodies, err := tsRules(tg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("grammar has no rules")
+	}
+
+	p := &tsImporter{g: &ggrammar{start: names[0], sep: " "}, defined: map[string]bool{}}
+	if tg.Extras != nil && len(tg.Extras) == 0 {
+		p.g.sep = "" //No extras, nothing may come between tokens
+	}
+	for _, name := range names {
+		p.defined[name] = true
+	}
+	for i, name := range names {
+		p.current, p.tokens = name, nil
+		body := bodies[i]
+		lexical := body.token()
+		r := &grule{name: name, expr: p.expr(body, lexical), lexical: lexical}
+		p.g.rules = append(append(p.g.rules, r), p.tokens...)
+	}
+
+	// Tokens of the external scanner have no rules to generate them from
+	for _, ext := range tg.Externals {
+		if ext.Type != "SYMBOL" || p.defined[ext.Name] || !p.referenced(ext.Name) {
+			continue
+		}
+		p.g.warn(ext.Name, "token of the external scanner, generated as nothing")
+		p.g.rules = append(p.g.rules, &grule{name: ext.Name, expr: &gexpr{kind: gEmpty}, lexical: true})
+		p.defined[ext.Name] = true
+	}
+	return p.g, nil
+}
+
+// tsRules reads the rules object, keeping the order of the rules: the first one is the start rule
+func tsRules(raw json.RawMessage) ([]string, []tsNode, error) {
+	if len(raw) == 0 {
+		return nil, nil, fmt.Errorf("grammar has no rules")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("rules must be an object")
+	}
+	var names []string
+	var bodies []tsNode
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var body tsNode
+		if err := dec.Decode(&body); err != nil {
+			return nil, nil, fmt.Errorf("rule %s: %w", tok, err)
+		}
+		names = append(names, tok.(string))
+		bodies = append(bodies, body)
+	}
+	return names, bodies, nil
+}
+
+// token reports whether n matches a single token, looking through precedences
+func (n tsNode) token() bool {
+	switch n.Type {
+	case "TOKEN", "IMMEDIATE_TOKEN", "STRING", "PATTERN":
+		return true
+	case "PREC", "PREC_LEFT", "PREC_RIGHT", "PREC_DYNAMIC":
+		return n.Content != nil && n.Content.token()
+	}
+	return false
+}
+
+// text returns the string value of STRING, PATTERN and ALIAS nodes
+func (n tsNode) text() string {
+	s, _ := n.Value.(string)
+	return s
+}
+
+// referenced reports whether a rule of the grammar references name
+func (p *tsImporter) referenced(name string) bool {
+	found := false
+	for _, r := range p.g.rules {
+		r.expr.refs(func(ref string) { found = found || ref == name })
+	}
+	return found
+}
+
+func (p *tsImporter) expr(n tsNode, lexical bool) *gexpr {
+	switch n.Type {
+	case "BLANK":
+		return &gexpr{kind: gEmpty}
+	case "STRING":
+		return gLiteral(n.text())
+	case "SYMBOL":
+		return gRule(n.Name)
+	case "SEQ", "CHOICE":
+		items := make([]*gexpr, len(n.Members))
+		for i, m := range n.Members {
+			items[i] = p.expr(m, lexical)
+		}
+		if n.Type == "SEQ" {
+			return gSeqOf(items...)
+		}
+		return gAltOf(items...)
+	}
+
+	if n.Type == "PATTERN" || n.Type == "TOKEN" || n.Type == "IMMEDIATE_TOKEN" {
+		if n.Type == "IMMEDIATE_TOKEN" && p.g.sep != "" {
+			p.g.warn(p.current, "token.immediate is generated after a separator")
+		}
+		if !lexical {
+			return p.tokenRule(n)
+		}
+		if n.Type == "PATTERN" {
+			return p.pattern(n.text(), n.Flags)
+		}
+	}
+
+	if n.Content == nil {
+		p.g.warn(p.current, "%s not supported, generated as nothing", strings.ToLower(n.Type))
+		return &gexpr{kind: gNone}
+	}
+	switch n.Type {
+	case "REPEAT":
+		return gRepeat(gStar, p.expr(*n.Content, lexical))
+	case "REPEAT1":
+		return gRepeat(gPlus, p.expr(*n.Content, lexical))
+	case "OPTIONAL":
+		return gRepeat(gOpt, p.expr(*n.Content, lexical))
+	case "TOKEN", "IMMEDIATE_TOKEN", "PREC", "PREC_LEFT", "PREC_RIGHT", "PREC_DYNAMIC", "FIELD", "ALIAS", "RESERVED":
+		return p.expr(*n.Content, lexical) //Aliases and fields rename the node, not its text
+	}
+	p.g.warn(p.current, "%s not supported, generated as nothing", strings.ToLower(n.Type))
+	return &gexpr{kind: gNone}
+}
+
+// tokenRule splits a token used inside a non lexical rule into a lexical rule of its own,
+// so its characters are generated without separators
+func (p *tsImporter) tokenRule(n tsNode) *gexpr {
+	name := fmt.Sprintf("%s_token%d", p.current, len(p.tokens)+1)
+	for p.defined[name] {
+		name += "_"
+	}
+	p.defined[name] = true
+	r := &grule{name: name, lexical: true}
+	p.tokens = append(p.tokens, r)
+	r.expr = p.expr(n, true)
+	return gRule(name)
+}
+
+var jsUnicodeEscape = regexp.MustCompile(`\\u\{([0-9a-fA-F]+)\}|\\u([0-9a-fA-F]{4})`)
+
+// pattern reads the JavaScript regex of a PATTERN
+func (p *tsImporter) pattern(src, flags string) *gexpr {
+	goSrc := jsUnicodeEscape.ReplaceAllString(src, `\x{$1$2}`)
+	parseFlags := syntax.Perl
+	if strings.Contains(flags, "i") {
+		parseFlags |= syntax.FoldCase
+	}
+	re, err := syntax.Parse(goSrc, parseFlags)
+	if err != nil {
+		p.g.warn(p.current, "pattern /%s/ can't be read, generated as nothing", src)
+		return &gexpr{kind: gNone}
+	}
+	return p.regex(re)
+}
+
+// regex translates a parsed regex, anchors and word boundaries matching nothing
+func (p *tsImporter) regex(re *syntax.Regexp) *gexpr {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return &gexpr{kind: gNone}
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			return gLiteral(string(re.Rune))
+		}
+		items := make([]*gexpr, len(re.Rune)) //Case insensitive, like [eE] is parsed
+		for i, r := range re.Rune {
+			set := charSet{{r, r}}
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				set = set.union(charSet{{f, f}})
+			}
+			items[i] = gChars(set)
+		}
+		return gSeqOf(items...)
+	case syntax.OpCharClass:
+		var set charSet
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			set = set.union(charSet{{re.Rune[i], re.Rune[i+1]}})
+		}
+		return gChars(set)
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return gChars(anyChar)
+	case syntax.OpCapture:
+		return p.regex(re.Sub[0])
+	case syntax.OpStar:
+		return gRepeat(gStar, p.regex(re.Sub[0]))
+	case syntax.OpPlus:
+		return gRepeat(gPlus, p.regex(re.Sub[0]))
+	case syntax.OpQuest:
+		return gRepeat(gOpt, p.regex(re.Sub[0]))
+	case syntax.OpRepeat:
+		repeated, exact := gCount(p.regex(re.Sub[0]), re.Min, re.Max)
+		if !exact {
+			p.g.warn(p.current, "repetition counts above %d are approximated", maxCopies)
+		}
+		return repeated
+	case syntax.OpConcat, syntax.OpAlternate:
+		items := make([]*gexpr, len(re.Sub))
+		for i, sub := range re.Sub {
+			items[i] = p.regex(sub)
+		}
+		if re.Op == syntax.OpConcat {
+			return gSeqOf(items...)
+		}
+		return gAltOf(items...)
+	}
+	return &gexpr{kind: gEmpty}
+}
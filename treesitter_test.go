@@ -0,0 +1,93 @@
+package resrap
+
+import "testing"
+
+func TestImportTreeSitter(t *testing.T) {
+	testImports(t, FormatTreeSitter, []importCase{
+		{
+			name: "rules, tokens and precedences",
+			src: `{
+  "name": "calc",
+  "rules": {
+    "program": {"type": "REPEAT1", "content": {"type": "SYMBOL", "name": "statement"}},
+    "statement": {"type": "SEQ", "members": [
+      {"type": "FIELD", "name": "value", "content": {"type": "SYMBOL", "name": "expr"}},
+      {"type": "IMMEDIATE_TOKEN", "content": {"type": "STRING", "value": ";"}}
+    ]},
+    "expr": {"type": "CHOICE", "members": [
+      {"type": "PREC_LEFT", "value": 1, "content": {"type": "SEQ", "members": [
+        {"type": "SYMBOL", "name": "expr"}, {"type": "STRING", "value": "+"}, {"type": "SYMBOL", "name": "expr"}
+      ]}},
+      {"type": "SYMBOL", "name": "number"},
+      {"type": "ALIAS", "value": "name", "named": true, "content": {"type": "SYMBOL", "name": "ident"}}
+    ]},
+    "number": {"type": "PATTERN", "value": "[0-2]+(\\.[0-2]{2})?"},
+    "ident": {"type": "TOKEN", "content": {"type": "SEQ", "members": [
+      {"type": "PATTERN", "value": "[a-c]"}, {"type": "REPEAT", "content": {"type": "PATTERN", "value": "[a-c_]"}}
+    ]}}
+  }
+}`,
+			want: `program: statement+;
+statement: expr statement_token1 ' ';
+statement_token1: ';';
+expr: expr '+ ' expr | number ' ' | ident ' ';
+number: number_1+ number_4?;
+number_1: '0' | '1' | '2';
+number_2: '0' | '1' | '2';
+number_3: '0' | '1' | '2';
+number_4: '.' number_2 number_3;
+ident: ident_1 ident_2*;
+ident_1: 'a' | 'b' | 'c';
+ident_2: '_' | 'a' | 'b' | 'c';
+`,
+			warnings: []string{"statement: token.immediate is generated after a separator"},
+			sample:   "1 ; ",
+		},
+		{
+			name: "inline tokens and no extras",
+			src: `{
+  "name": "tight",
+  "extras": [],
+  "rules": {
+    "pair": {"type": "SEQ", "members": [
+      {"type": "STRING", "value": "'"},
+      {"type": "TOKEN", "content": {"type": "PATTERN", "value": "\\d"}},
+      {"type": "IMMEDIATE_TOKEN", "content": {"type": "STRING", "value": "!"}},
+      {"type": "OPTIONAL", "content": {"type": "BLANK"}}
+    ]}
+  }
+}`,
+			want: `program: pair;
+pair: '\'' pair_token1 pair_token2;
+pair_token1: pair_token1_1;
+pair_token1_1: '0' | '1' | '2' | '3' | '4' | '5' | '6' | '7' | '8' | '9';
+pair_token2: '!';
+`,
+			sample: "'9!",
+		},
+		{
+			name: "externals and unreadable patterns",
+			src: `{
+  "name": "lossy",
+  "externals": [{"type": "SYMBOL", "name": "heredoc"}, {"type": "SYMBOL", "name": "unused"}],
+  "rules": {
+    "doc": {"type": "CHOICE", "members": [
+      {"type": "SYMBOL", "name": "heredoc"},
+      {"type": "SYMBOL", "name": "word"},
+      {"type": "SYMBOL", "name": "many"}
+    ]},
+    "word": {"type": "PATTERN", "value": "(?=a)b"},
+    "many": {"type": "PATTERN", "value": "x{20,}"}
+  }
+}`,
+			want: `program: doc;
+doc: heredoc ' ' | word ' ' | many ' ';
+word: '';
+many: 'xxxxxxxxxxxxxxx' 'x'+;
+heredoc: '';
+`,
+			warnings: []string{"word: pattern /(?=a)b/ can't be read, generated as nothing", "many: repetition counts above 16 are approximated", "heredoc: token of the external scanner, generated as nothing", "word: none of its alternatives could be converted, generated as nothing"},
+			sample:   " ",
+		},
+	})
+}